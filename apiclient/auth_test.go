@@ -0,0 +1,112 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// authRequest sends a GET through a client using authenticator and returns
+// the request as seen by the server.
+func authRequest(t *testing.T, configure func(c *Client)) *http.Request {
+	var seen *http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	configure(c)
+	_, err = c.Get(context.Background(), "/", nil)
+	require.NoError(t, err)
+	return seen
+}
+
+func TestAuthenticators(t *testing.T) {
+	t.Setenv("APICLIENT_TEST_TOKEN", "from-env")
+
+	r := authRequest(t, func(c *Client) { c.Authenticator = BasicAuth("user", StaticSecret("pass")) })
+	user, pass, ok := r.BasicAuth()
+	require.True(t, ok)
+	require.Equal(t, "user", user)
+	require.Equal(t, "pass", pass)
+
+	r = authRequest(t, func(c *Client) { c.Authenticator = BearerAuth(EnvSecret("APICLIENT_TEST_TOKEN")) })
+	require.Equal(t, "Bearer from-env", r.Header.Get("Authorization"))
+
+	r = authRequest(t, func(c *Client) {
+		c.Authenticator = ChainAuth(
+			APIKeyHeader("X-Api-Key", StaticSecret("header-key")),
+			APIKeyQuery("api_key", StaticSecret("query-key")),
+		)
+	})
+	require.Equal(t, "header-key", r.Header.Get("X-Api-Key"))
+	require.Equal(t, "query-key", r.URL.Query().Get("api_key"))
+}
+
+func TestAuthenticator_legacyFields(t *testing.T) {
+	r := authRequest(t, func(c *Client) {
+		c.RequiresAuthorization = true
+		c.AuthKey = "legacy"
+	})
+	require.Equal(t, "legacy", r.Header.Get("Authorization"))
+
+	r = authRequest(t, func(c *Client) {
+		c.RequiresAuthorization = true
+		c.AuthHeaderName = "X-Auth"
+		c.AuthKey = "legacy"
+	})
+	require.Equal(t, "legacy", r.Header.Get("X-Auth"))
+
+	// an Authenticator replaces the legacy fields
+	r = authRequest(t, func(c *Client) {
+		c.RequiresAuthorization = true
+		c.AuthKey = "legacy"
+		c.Authenticator = BearerAuth(StaticSecret("new"))
+	})
+	require.Equal(t, "Bearer new", r.Header.Get("Authorization"))
+}
+
+func TestAuthenticator_errorStopsRequest(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	c.Authenticator = BearerAuth(EnvSecret("APICLIENT_TEST_UNSET_TOKEN"))
+	_, err = c.Get(context.Background(), "/", nil)
+	require.Error(t, err)
+	require.Equal(t, 0, calls)
+}
+
+func TestFileSecret_rereadsOnRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte("first\n"), 0o600))
+
+	secret := NewFileSecret(path)
+	value, err := secret.Secret()
+	require.NoError(t, err)
+	require.Equal(t, "first", value)
+
+	require.NoError(t, os.WriteFile(path, []byte("second-value\n"), 0o600))
+	// make sure the rotation is visible even on coarse file system clocks
+	later := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(path, later, later))
+	value, err = secret.Secret()
+	require.NoError(t, err)
+	require.Equal(t, "second-value", value)
+
+	require.NoError(t, os.Remove(path))
+	_, err = secret.Secret()
+	require.Error(t, err)
+}
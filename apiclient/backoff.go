@@ -0,0 +1,57 @@
+package apiclient
+
+import (
+	"math/rand"
+	"time"
+)
+
+// BackoffStrategy computes how long to wait before a retry. attempt is the
+// number of the retry starting at 1 and previous is the delay used before the
+// previous retry, zero for the first one.
+type BackoffStrategy func(attempt int, previous time.Duration) time.Duration
+
+// ConstantBackoff waits the same delay before every retry.
+func ConstantBackoff(delay time.Duration) BackoffStrategy {
+	return func(int, time.Duration) time.Duration {
+		return delay
+	}
+}
+
+// ExponentialBackoff doubles the delay for every retry starting at base,
+// the delay never exceeds max.
+func ExponentialBackoff(base, max time.Duration) BackoffStrategy {
+	return func(attempt int, _ time.Duration) time.Duration {
+		delay := base
+		for i := 1; i < attempt; i++ {
+			delay *= 2
+			if delay >= max || delay <= 0 { // <= 0 on overflow
+				return max
+			}
+		}
+		if delay > max {
+			return max
+		}
+		return delay
+	}
+}
+
+// DecorrelatedJitterBackoff picks a random delay between base and three
+// times the previous delay, capped at max. Retries of concurrent callers are
+// spread out while still growing roughly exponentially.
+// See https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+func DecorrelatedJitterBackoff(base, max time.Duration) BackoffStrategy {
+	return func(_ int, previous time.Duration) time.Duration {
+		if previous < base {
+			previous = base
+		}
+		upper := previous * 3
+		if upper <= base { // overflow or zero base
+			return max
+		}
+		delay := base + time.Duration(rand.Int63n(int64(upper-base)+1))
+		if delay > max {
+			return max
+		}
+		return delay
+	}
+}
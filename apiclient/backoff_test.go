@@ -0,0 +1,49 @@
+package apiclient
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConstantBackoff(t *testing.T) {
+	backoff := ConstantBackoff(time.Second)
+	for attempt := 1; attempt < 5; attempt++ {
+		require.Equal(t, time.Second, backoff(attempt, time.Duration(attempt)*time.Minute))
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(100*time.Millisecond, time.Second)
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for i, want := range expected {
+		t.Run(fmt.Sprintf("attempt:%d", i+1), func(t *testing.T) {
+			require.Equal(t, want, backoff(i+1, 0))
+		})
+	}
+	require.Equal(t, time.Second, backoff(100, 0), "large attempts must not overflow")
+}
+
+func TestDecorrelatedJitterBackoff(t *testing.T) {
+	base, max := 10*time.Millisecond, 200*time.Millisecond
+	backoff := DecorrelatedJitterBackoff(base, max)
+	var previous time.Duration
+	for attempt := 1; attempt < 50; attempt++ {
+		delay := backoff(attempt, previous)
+		require.GreaterOrEqual(t, delay, base)
+		require.LessOrEqual(t, delay, max)
+		if previous >= base {
+			require.LessOrEqual(t, delay, previous*3)
+		}
+		previous = delay
+	}
+}
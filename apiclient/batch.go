@@ -0,0 +1,223 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrBatchSkipped is the error of the batch calls which were not started
+// because an earlier call failed in fail-fast mode.
+var ErrBatchSkipped = errors.New("apiclient: batch call skipped after an earlier failure")
+
+// BatchMode decides what a batch does when one of its calls fails.
+type BatchMode int
+
+const (
+	// BatchCollectAll runs every call and collects all errors.
+	BatchCollectAll BatchMode = iota
+	// BatchFailFast cancels the calls in flight and skips the remaining ones
+	// after the first failure.
+	BatchFailFast
+)
+
+const defaultBatchConcurrency = 10
+
+// BatchConfig configures NewBatchExecutor. Zero values are replaced by the
+// defaults noted on each field.
+type BatchConfig struct {
+	// Concurrency is the maximum number of calls in flight, defaults to 10.
+	Concurrency int
+	Mode        BatchMode
+	// ItemTimeout bounds every call, zero means no timeout.
+	ItemTimeout time.Duration
+	// Timeout bounds the whole batch, calls not started by then fail with
+	// the context error. Zero means only the context deadline applies.
+	Timeout time.Duration
+}
+
+// BatchCall is one call of a batch. It must use ctx, which carries the item
+// timeout and is canceled in fail-fast mode.
+type BatchCall func(ctx context.Context, client APIClient) (*Response, error)
+
+// BatchResult is the outcome of the call at Index of a batch.
+type BatchResult struct {
+	Index    int
+	Response *Response
+	Err      error
+	Duration time.Duration
+}
+
+// BatchSummary sums up the results of a batch.
+type BatchSummary struct {
+	Total     int
+	Succeeded int
+	Failed    int
+	// Skipped counts the calls which were never started.
+	Skipped  int
+	Duration time.Duration
+	// MaxDuration is the duration of the slowest call.
+	MaxDuration time.Duration
+}
+
+// BatchError is returned by Execute in fail-fast mode, it wraps the error of
+// the first failed call.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("batch call %d failed: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// BatchExecutor runs batches of calls on an APIClient with bounded
+// parallelism. It is safe for concurrent use.
+type BatchExecutor struct {
+	client APIClient
+	config BatchConfig
+}
+
+// NewBatchExecutor returns a BatchExecutor for client.
+//
+//	executor := NewBatchExecutor(client, BatchConfig{Concurrency: 20, ItemTimeout: 2 * time.Second})
+//	calls := make([]BatchCall, len(ids))
+//	for i, id := range ids {
+//	  id := id
+//	  calls[i] = func(ctx context.Context, client APIClient) (*Response, error) {
+//	    return client.Get(WithRouteTemplate(ctx, "/products/{id}"), "/products/"+id, nil)
+//	  }
+//	}
+//	results, summary, err := executor.Execute(ctx, calls)
+func NewBatchExecutor(client APIClient, config BatchConfig) *BatchExecutor {
+	if config.Concurrency < 1 {
+		config.Concurrency = defaultBatchConcurrency
+	}
+	return &BatchExecutor{client: client, config: config}
+}
+
+// Execute runs calls and returns their results in the order of calls. In
+// fail-fast mode the error is a *BatchError for the first failed call, in
+// collect-all mode it is always nil and the errors are in the results.
+// Execute returns once every started call has returned.
+func (b *BatchExecutor) Execute(ctx context.Context, calls []BatchCall) ([]BatchResult, BatchSummary, error) {
+	start := time.Now()
+	if b.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.config.Timeout)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]BatchResult, len(calls))
+	started := make([]bool, len(calls))
+	var failOnce sync.Once
+	var failure *BatchError
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	workers := b.config.Concurrency
+	if workers > len(calls) {
+		workers = len(calls)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if ctx.Err() != nil {
+					continue // canceled while waiting for a worker
+				}
+				started[i] = true
+				results[i] = b.run(ctx, i, calls[i])
+				if results[i].Err != nil && b.config.Mode == BatchFailFast {
+					failOnce.Do(func() {
+						failure = &BatchError{Index: i, Err: results[i].Err}
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := range calls {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	summary := BatchSummary{Total: len(calls), Duration: time.Since(start)}
+	for i := range results {
+		results[i].Index = i
+		if !started[i] {
+			results[i].Err = ErrBatchSkipped
+			if failure == nil {
+				results[i].Err = ctx.Err()
+			}
+			summary.Skipped++
+			continue
+		}
+		if results[i].Err != nil {
+			summary.Failed++
+		} else {
+			summary.Succeeded++
+		}
+		if results[i].Duration > summary.MaxDuration {
+			summary.MaxDuration = results[i].Duration
+		}
+	}
+	b.record(ctx, summary)
+	if failure != nil {
+		return results, summary, failure
+	}
+	return results, summary, nil
+}
+
+// record records the summary of a batch through the metrics Provider and logs
+// it with the Logger of the client when it is a *Client.
+func (b *BatchExecutor) record(ctx context.Context, summary BatchSummary) {
+	name := ""
+	if c, ok := b.client.(*Client); ok {
+		ctx, name = withClient(ctx, c), c.name()
+	}
+	m := metricsInstruments()
+	m.batches.With("client", name).Add(1)
+	m.batchCalls.With("client", name, "result", "succeeded").Add(float64(summary.Succeeded))
+	m.batchCalls.With("client", name, "result", "failed").Add(float64(summary.Failed))
+	m.batchCalls.With("client", name, "result", "skipped").Add(float64(summary.Skipped))
+	m.batchDuration.With("client", name).Observe(summary.Duration.Seconds())
+	logAt(ctx, LogLevelDebug, "APIClient batch completed", LogFields{
+		"total":          summary.Total,
+		"succeeded":      summary.Succeeded,
+		"failed":         summary.Failed,
+		"skipped":        summary.Skipped,
+		LogFieldDuration: summary.Duration,
+	})
+}
+
+// run executes a single call with the item timeout.
+func (b *BatchExecutor) run(ctx context.Context, index int, call BatchCall) BatchResult {
+	if b.config.ItemTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.config.ItemTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	resp, err := call(ctx, b.client)
+	return BatchResult{Index: index, Response: resp, Err: err, Duration: time.Since(start)}
+}
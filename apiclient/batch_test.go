@@ -0,0 +1,165 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CodeNamor/http/metrics"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+// getCalls returns n calls getting "/" with client.
+func getCalls(n int) []BatchCall {
+	calls := make([]BatchCall, n)
+	for i := range calls {
+		calls[i] = func(ctx context.Context, client APIClient) (*Response, error) {
+			return client.Get(ctx, "/", nil)
+		}
+	}
+	return calls
+}
+
+func TestBatchExecutor_collectAll(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var inFlight, maxInFlight, n int32
+	client := NewMockAPIClient(ctrl)
+	client.EXPECT().Get(gomock.Any(), "/", gomock.Nil()).Times(20).DoAndReturn(
+		func(ctx context.Context, path string, _ interface{}) (*Response, error) {
+			current := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			if atomic.AddInt32(&n, 1)%5 == 0 {
+				return nil, errors.New("boom")
+			}
+			return &Response{StatusCode: http.StatusOK}, nil
+		})
+
+	results, summary, err := NewBatchExecutor(client, BatchConfig{Concurrency: 4}).Execute(context.Background(), getCalls(20))
+	require.NoError(t, err)
+	require.Len(t, results, 20)
+	for i, result := range results {
+		require.Equal(t, i, result.Index)
+	}
+	require.LessOrEqual(t, atomic.LoadInt32(&maxInFlight), int32(4))
+	require.Equal(t, BatchSummary{
+		Total:       20,
+		Succeeded:   16,
+		Failed:      4,
+		Duration:    summary.Duration,
+		MaxDuration: summary.MaxDuration,
+	}, summary)
+	require.Greater(t, summary.MaxDuration, time.Duration(0))
+}
+
+func TestBatchExecutor_failFast(t *testing.T) {
+	failure := errors.New("boom")
+	calls := []BatchCall{
+		func(ctx context.Context, client APIClient) (*Response, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+		func(ctx context.Context, client APIClient) (*Response, error) {
+			return nil, failure
+		},
+		func(ctx context.Context, client APIClient) (*Response, error) {
+			t.Error("call after the failure was started")
+			return nil, nil
+		},
+	}
+
+	results, summary, err := NewBatchExecutor(nil, BatchConfig{Concurrency: 2, Mode: BatchFailFast}).Execute(context.Background(), calls)
+	var batchErr *BatchError
+	require.True(t, errors.As(err, &batchErr))
+	require.Equal(t, 1, batchErr.Index)
+	require.True(t, errors.Is(err, failure))
+	require.True(t, errors.Is(results[0].Err, context.Canceled))
+	require.Equal(t, ErrBatchSkipped, results[2].Err)
+	require.Equal(t, 2, summary.Failed)
+	require.Equal(t, 1, summary.Skipped)
+}
+
+func TestBatchExecutor_timeouts(t *testing.T) {
+	slow := func(ctx context.Context, client APIClient) (*Response, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return &Response{}, nil
+		}
+	}
+
+	results, summary, err := NewBatchExecutor(nil, BatchConfig{ItemTimeout: 10 * time.Millisecond}).Execute(context.Background(), []BatchCall{slow, slow})
+	require.NoError(t, err)
+	require.True(t, errors.Is(results[1].Err, context.DeadlineExceeded))
+	require.Equal(t, 2, summary.Failed)
+
+	results, summary, err = NewBatchExecutor(nil, BatchConfig{Concurrency: 1, Timeout: 20 * time.Millisecond}).Execute(context.Background(), []BatchCall{slow, slow})
+	require.NoError(t, err)
+	require.True(t, errors.Is(results[0].Err, context.DeadlineExceeded))
+	require.True(t, errors.Is(results[1].Err, context.DeadlineExceeded))
+	require.Equal(t, 1, summary.Failed)
+	require.Equal(t, 1, summary.Skipped)
+}
+
+func TestBatchExecutor_recordsSummary(t *testing.T) {
+	registry := metrics.NewPrometheusRegistry()
+	ConfigureMetrics(MetricsConfig{Provider: registry})
+	defer ConfigureMetrics(MetricsConfig{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	logger := &recordingLogger{}
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	c.Name = "orders"
+	c.Logger = logger
+
+	call := func(path string) BatchCall {
+		return func(ctx context.Context, client APIClient) (*Response, error) {
+			return client.Request(ctx, http.MethodGet, path, ExpectStatus(http.StatusOK))
+		}
+	}
+	_, summary, err := NewBatchExecutor(c, BatchConfig{Concurrency: 1, Mode: BatchFailFast}).
+		Execute(context.Background(), []BatchCall{call("/ok"), call("/fail"), call("/ok")})
+	require.Error(t, err)
+	require.Equal(t, []int{3, 1, 1, 1}, []int{summary.Total, summary.Succeeded, summary.Failed, summary.Skipped})
+
+	var b strings.Builder
+	require.NoError(t, registry.Write(&b))
+	out := b.String()
+	require.Contains(t, out, `http_client_batches_total{client="orders"} 1`)
+	require.Contains(t, out, `http_client_batch_calls_total{client="orders",result="succeeded"} 1`)
+	require.Contains(t, out, `http_client_batch_calls_total{client="orders",result="failed"} 1`)
+	require.Contains(t, out, `http_client_batch_calls_total{client="orders",result="skipped"} 1`)
+	require.Contains(t, out, `http_client_batch_duration_count{client="orders"} 1`)
+
+	var completed *logEntry
+	for i := range logger.entries {
+		if logger.entries[i].msg == "APIClient batch completed" {
+			completed = &logger.entries[i]
+		}
+	}
+	require.NotNil(t, completed, "the summary is logged with the Logger of the client")
+	require.Equal(t, "orders", completed.fields[LogFieldClient])
+	require.Equal(t, 1, completed.fields["skipped"])
+}
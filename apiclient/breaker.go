@@ -0,0 +1,317 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a circuit of a circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all calls through while recording their outcome.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails all calls fast with a *CircuitOpenError.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of trial calls through to
+	// decide whether the circuit closes again.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// CircuitOpenError is returned without calling the upstream while the
+// circuit for Key is open. RetryAt is when the circuit becomes half-open.
+type CircuitOpenError struct {
+	Key     string
+	State   CircuitState
+	RetryAt time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker for %s is %s", e.Key, e.State)
+}
+
+// CircuitBreakerConfig configures NewCircuitBreakerClient. Zero values are
+// replaced by the defaults noted on each field.
+type CircuitBreakerConfig struct {
+	// KeyFunc selects the circuit of a request, defaults to the URL host.
+	KeyFunc func(req *http.Request) string
+	// WindowSize is the number of most recent calls the failure and slow
+	// call rates are computed over, defaults to 20.
+	WindowSize int
+	// MinimumCalls is the number of calls in the window needed before the
+	// circuit can trip, defaults to 10.
+	MinimumCalls int
+	// FailureRateThreshold trips the circuit when the share of failed calls
+	// in the window reaches it, defaults to 0.5.
+	FailureRateThreshold float64
+	// SlowCallDuration marks calls taking at least this long as slow, zero
+	// disables slow call tracking.
+	SlowCallDuration time.Duration
+	// SlowCallRateThreshold trips the circuit when the share of slow calls
+	// in the window reaches it, defaults to 1.
+	SlowCallRateThreshold float64
+	// OpenTimeout is how long the circuit stays open before it lets trial
+	// calls through, defaults to 30s.
+	OpenTimeout time.Duration
+	// HalfOpenCalls is the number of trial calls in the half-open state that
+	// must all succeed to close the circuit, defaults to 1.
+	HalfOpenCalls int
+	// IsFailure decides whether a call failed, defaults to transport errors
+	// and 5xx statuses.
+	IsFailure func(resp *http.Response, err error) bool
+	// OnStateChange is called after every state transition of a circuit.
+	OnStateChange func(key string, from, to CircuitState)
+}
+
+// NewCircuitBreakerClient wraps client with a circuit breaker that keeps a
+// circuit per host, or per key returned by config.KeyFunc. Place it inside
+// the retry engine so every attempt is recorded and an open circuit ends the
+// retries right away, the DefaultRetryPolicy never retries a
+// *CircuitOpenError.
+//
+//	breaker := NewCircuitBreakerClient(&http.Client{}, CircuitBreakerConfig{
+//	  FailureRateThreshold: 0.5,
+//	  SlowCallDuration:     2 * time.Second,
+//	})
+//	retryClient := NewRetryClient(breaker, RetryConfig{MaxAttempts: 3})
+//
+// State transitions are counted in expvar and the current state of every
+// circuit is published in the HTTPClientCircuitStates expvar map.
+func NewCircuitBreakerClient(client RetryClient, config CircuitBreakerConfig) RetryClient {
+	if config.KeyFunc == nil {
+		config.KeyFunc = func(req *http.Request) string { return req.URL.Host }
+	}
+	if config.WindowSize < 1 {
+		config.WindowSize = 20
+	}
+	if config.MinimumCalls < 1 {
+		config.MinimumCalls = 10
+	}
+	if config.MinimumCalls > config.WindowSize {
+		config.MinimumCalls = config.WindowSize
+	}
+	if config.FailureRateThreshold <= 0 {
+		config.FailureRateThreshold = 0.5
+	}
+	if config.SlowCallRateThreshold <= 0 {
+		config.SlowCallRateThreshold = 1
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = 30 * time.Second
+	}
+	if config.HalfOpenCalls < 1 {
+		config.HalfOpenCalls = 1
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = defaultIsFailure
+	}
+	return &circuitBreakerClient{
+		client:   client,
+		config:   config,
+		circuits: map[string]*circuit{},
+	}
+}
+
+func defaultIsFailure(resp *http.Response, err error) bool {
+	return err != nil || resp == nil || resp.StatusCode >= http.StatusInternalServerError
+}
+
+type circuitBreakerClient struct {
+	client RetryClient
+	config CircuitBreakerConfig
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state    CircuitState
+	openedAt time.Time
+	// generation counts the transitions, so the outcome of a call admitted
+	// before a transition is not applied to the new state
+	generation uint64
+
+	// sliding window of the most recent outcomes in the closed state
+	failed []bool
+	slow   []bool
+	next   int
+	count  int
+
+	// trial calls in the half-open state
+	inFlight  int
+	succeeded int
+}
+
+type stateChange struct {
+	key      string
+	from, to CircuitState
+}
+
+func (cb *circuitBreakerClient) Do(req *http.Request) (*http.Response, error) {
+	key := cb.config.KeyFunc(req)
+
+	generation, err := cb.acquire(req.Context(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := cb.client.Do(req)
+	cb.record(req.Context(), key, generation, resp, err, time.Since(start))
+	return resp, err
+}
+
+// acquire checks whether a call may pass the circuit for key and returns the
+// generation of the circuit the call was admitted in.
+func (cb *circuitBreakerClient) acquire(ctx context.Context, key string) (uint64, error) {
+	cb.mu.Lock()
+	c, ok := cb.circuits[key]
+	if !ok {
+		c = &circuit{
+			failed: make([]bool, cb.config.WindowSize),
+			slow:   make([]bool, cb.config.WindowSize),
+		}
+		cb.circuits[key] = c
+		publishCircuitState(key, CircuitClosed)
+	}
+
+	var changes []stateChange
+	if c.state == CircuitOpen && time.Since(c.openedAt) >= cb.config.OpenTimeout {
+		changes = append(changes, cb.transition(key, c, CircuitHalfOpen))
+	}
+
+	var err error
+	switch c.state {
+	case CircuitOpen:
+		err = &CircuitOpenError{Key: key, State: CircuitOpen, RetryAt: c.openedAt.Add(cb.config.OpenTimeout)}
+	case CircuitHalfOpen:
+		if c.inFlight+c.succeeded >= cb.config.HalfOpenCalls {
+			err = &CircuitOpenError{Key: key, State: CircuitHalfOpen, RetryAt: time.Now()}
+		} else {
+			c.inFlight++
+		}
+	}
+	generation := c.generation
+	cb.mu.Unlock()
+
+	cb.notify(ctx, changes)
+	if err != nil {
+		metricsInstruments().circuitRejected.Add(1.0)
+	}
+	return generation, err
+}
+
+// record adds the outcome of a call admitted in generation to the circuit
+// for key and trips or closes it as needed. Calls admitted before the last
+// transition are ignored.
+func (cb *circuitBreakerClient) record(ctx context.Context, key string, generation uint64, resp *http.Response, err error, elapsed time.Duration) {
+	// a call cancelled by its caller says nothing about the upstream
+	ignored := err != nil && errors.Is(err, context.Canceled)
+	failed := !ignored && cb.config.IsFailure(resp, err)
+	slow := !ignored && cb.config.SlowCallDuration > 0 && elapsed >= cb.config.SlowCallDuration
+
+	cb.mu.Lock()
+	c := cb.circuits[key]
+	var changes []stateChange
+	switch {
+	case generation != c.generation:
+		// admitted before the last transition
+	case c.state == CircuitHalfOpen:
+		c.inFlight--
+		switch {
+		case ignored:
+		case failed || slow:
+			changes = append(changes, cb.transition(key, c, CircuitOpen))
+		default:
+			c.succeeded++
+			if c.succeeded >= cb.config.HalfOpenCalls {
+				changes = append(changes, cb.transition(key, c, CircuitClosed))
+			}
+		}
+	case c.state == CircuitClosed && !ignored:
+		c.failed[c.next] = failed
+		c.slow[c.next] = slow
+		c.next = (c.next + 1) % len(c.failed)
+		if c.count < len(c.failed) {
+			c.count++
+		}
+		if c.count >= cb.config.MinimumCalls && cb.shouldTrip(c) {
+			changes = append(changes, cb.transition(key, c, CircuitOpen))
+		}
+	}
+	cb.mu.Unlock()
+
+	cb.notify(ctx, changes)
+}
+
+func (cb *circuitBreakerClient) shouldTrip(c *circuit) bool {
+	var failures, slowCalls int
+	for i := 0; i < c.count; i++ {
+		if c.failed[i] {
+			failures++
+		}
+		if c.slow[i] {
+			slowCalls++
+		}
+	}
+	calls := float64(c.count)
+	if float64(failures)/calls >= cb.config.FailureRateThreshold {
+		return true
+	}
+	return cb.config.SlowCallDuration > 0 && float64(slowCalls)/calls >= cb.config.SlowCallRateThreshold
+}
+
+// transition moves c to state to and resets the bookkeeping of the new
+// state. It must be called with cb.mu held.
+func (cb *circuitBreakerClient) transition(key string, c *circuit, to CircuitState) stateChange {
+	change := stateChange{key: key, from: c.state, to: to}
+	c.state = to
+	c.generation++
+	c.inFlight, c.succeeded = 0, 0
+	switch to {
+	case CircuitOpen:
+		c.openedAt = time.Now()
+		metricsInstruments().circuitOpened.Add(1.0)
+	case CircuitHalfOpen:
+		metricsInstruments().circuitHalfOpened.Add(1.0)
+	case CircuitClosed:
+		c.next, c.count = 0, 0
+		metricsInstruments().circuitClosed.Add(1.0)
+	}
+	publishCircuitState(key, to)
+	return change
+}
+
+// notify logs the state changes and calls the OnStateChange callback outside
+// of the lock so the callback may use the client.
+func (cb *circuitBreakerClient) notify(ctx context.Context, changes []stateChange) {
+	for _, change := range changes {
+		logAt(ctx, LogLevelWarn, fmt.Sprintf("circuit breaker for %s changed from %s to %s", change.key, change.from, change.to), nil)
+		if cb.config.OnStateChange != nil {
+			cb.config.OnStateChange(change.key, change.from, change.to)
+		}
+	}
+}
+
+func publishCircuitState(key string, state CircuitState) {
+	s := new(expvar.String)
+	s.Set(state.String())
+	httpClientCircuitStates.Set(key, s)
+}
@@ -0,0 +1,229 @@
+package apiclient
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type transitionRecorder struct {
+	mu          sync.Mutex
+	transitions []string
+}
+
+func (r *transitionRecorder) record(key string, from, to CircuitState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.transitions = append(r.transitions, key+":"+from.String()+"->"+to.String())
+}
+
+func (r *transitionRecorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.transitions...)
+}
+
+func statusClient(status *int, calls *int) RetryClient {
+	return retryClientFunc(func(req *http.Request) (*http.Response, error) {
+		*calls++
+		return &http.Response{StatusCode: *status, Body: http.NoBody, Request: req}, nil
+	})
+}
+
+func doGet(t *testing.T, client RetryClient, url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	return client.Do(req)
+}
+
+func TestCircuitBreaker_tripsAndRecovers(t *testing.T) {
+	status, calls := http.StatusInternalServerError, 0
+	recorder := &transitionRecorder{}
+	breaker := NewCircuitBreakerClient(statusClient(&status, &calls), CircuitBreakerConfig{
+		WindowSize:           4,
+		MinimumCalls:         4,
+		FailureRateThreshold: 0.5,
+		OpenTimeout:          50 * time.Millisecond,
+		HalfOpenCalls:        2,
+		OnStateChange:        recorder.record,
+	})
+
+	for i := 0; i < 4; i++ {
+		_, err := doGet(t, breaker, "http://a.example.com/x")
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{"a.example.com:closed->open"}, recorder.get())
+
+	// open circuit fails fast without calling the upstream
+	_, err := doGet(t, breaker, "http://a.example.com/x")
+	var circuitOpen *CircuitOpenError
+	require.True(t, errors.As(err, &circuitOpen))
+	require.Equal(t, "a.example.com", circuitOpen.Key)
+	require.Equal(t, CircuitOpen, circuitOpen.State)
+	require.Equal(t, 4, calls)
+
+	// other hosts have their own circuit
+	_, err = doGet(t, breaker, "http://b.example.com/x")
+	require.NoError(t, err)
+	require.Equal(t, 5, calls)
+
+	// after the open timeout a failing trial call opens the circuit again
+	time.Sleep(60 * time.Millisecond)
+	_, err = doGet(t, breaker, "http://a.example.com/x")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"a.example.com:closed->open",
+		"a.example.com:open->half-open",
+		"a.example.com:half-open->open",
+	}, recorder.get())
+
+	// two successful trial calls close it
+	time.Sleep(60 * time.Millisecond)
+	status = http.StatusOK
+	for i := 0; i < 2; i++ {
+		_, err = doGet(t, breaker, "http://a.example.com/x")
+		require.NoError(t, err)
+	}
+	require.Equal(t, "a.example.com:half-open->closed", recorder.get()[4])
+	require.Equal(t, `"closed"`, httpClientCircuitStates.Get("a.example.com").String())
+}
+
+func TestCircuitBreaker_halfOpenLimitsTrialCalls(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	fail := true
+	slowClient := retryClientFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		f := fail
+		mu.Unlock()
+		if f {
+			return nil, errors.New("connection refused")
+		}
+		<-release
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	breaker := NewCircuitBreakerClient(slowClient, CircuitBreakerConfig{
+		WindowSize:   1,
+		MinimumCalls: 1,
+		OpenTimeout:  10 * time.Millisecond,
+	})
+	_, err := doGet(t, breaker, "http://a.example.com")
+	require.Error(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+
+	done := make(chan error)
+	go func() {
+		_, err := doGet(t, breaker, "http://a.example.com")
+		done <- err
+	}()
+	require.Eventually(t, func() bool {
+		_, err := doGet(t, breaker, "http://a.example.com")
+		var circuitOpen *CircuitOpenError
+		return errors.As(err, &circuitOpen) && circuitOpen.State == CircuitHalfOpen
+	}, time.Second, 5*time.Millisecond)
+	close(release)
+	require.NoError(t, <-done)
+
+	_, err = doGet(t, breaker, "http://a.example.com")
+	require.NoError(t, err, "circuit closed after the trial call succeeded")
+}
+
+func TestCircuitBreaker_staleTrialCallIgnored(t *testing.T) {
+	started := make(chan string, 10)
+	gates := map[string]chan struct{}{"/a": make(chan struct{}), "/c": make(chan struct{}), "/d": make(chan struct{})}
+	upstream := retryClientFunc(func(req *http.Request) (*http.Response, error) {
+		gate, ok := gates[req.URL.Path]
+		if !ok {
+			return &http.Response{StatusCode: http.StatusInternalServerError, Body: http.NoBody}, nil
+		}
+		started <- req.URL.Path
+		<-gate
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	recorder := &transitionRecorder{}
+	breaker := NewCircuitBreakerClient(upstream, CircuitBreakerConfig{
+		WindowSize:    1,
+		MinimumCalls:  1,
+		OpenTimeout:   10 * time.Millisecond,
+		HalfOpenCalls: 2,
+		OnStateChange: recorder.record,
+	})
+	trial := func(path string) chan error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := doGet(t, breaker, "http://a.example.com"+path)
+			done <- err
+		}()
+		require.Equal(t, path, <-started)
+		return done
+	}
+
+	_, err := doGet(t, breaker, "http://a.example.com/fail")
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	a := trial("/a")
+	_, err = doGet(t, breaker, "http://a.example.com/fail")
+	require.NoError(t, err, "a failed trial reopens the circuit")
+	time.Sleep(20 * time.Millisecond)
+	c := trial("/c")
+
+	// a trial of the previous half-open state does not count in this one
+	close(gates["/a"])
+	require.NoError(t, <-a)
+	close(gates["/c"])
+	require.NoError(t, <-c)
+	require.Equal(t, []string{
+		"a.example.com:closed->open",
+		"a.example.com:open->half-open",
+		"a.example.com:half-open->open",
+		"a.example.com:open->half-open",
+	}, recorder.get(), "one successful trial does not close the circuit")
+
+	close(gates["/d"])
+	_, err = doGet(t, breaker, "http://a.example.com/d")
+	require.NoError(t, err)
+	require.Equal(t, "a.example.com:half-open->closed", recorder.get()[4])
+}
+
+func TestCircuitBreaker_slowCalls(t *testing.T) {
+	slowClient := retryClientFunc(func(req *http.Request) (*http.Response, error) {
+		time.Sleep(15 * time.Millisecond)
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	breaker := NewCircuitBreakerClient(slowClient, CircuitBreakerConfig{
+		WindowSize:            2,
+		MinimumCalls:          2,
+		SlowCallDuration:      10 * time.Millisecond,
+		SlowCallRateThreshold: 1,
+	})
+	for i := 0; i < 2; i++ {
+		_, err := doGet(t, breaker, "http://slow.example.com")
+		require.NoError(t, err)
+	}
+	_, err := doGet(t, breaker, "http://slow.example.com")
+	var circuitOpen *CircuitOpenError
+	require.True(t, errors.As(err, &circuitOpen))
+}
+
+func TestCircuitBreaker_stopsRetries(t *testing.T) {
+	status, calls := http.StatusServiceUnavailable, 0
+	breaker := NewCircuitBreakerClient(statusClient(&status, &calls), CircuitBreakerConfig{
+		KeyFunc:      func(*http.Request) string { return "upstream" },
+		WindowSize:   2,
+		MinimumCalls: 2,
+	})
+	client := NewRetryClient(breaker, RetryConfig{MaxAttempts: 10, Backoff: ConstantBackoff(0)})
+
+	_, err := doGet(t, client, "http://a.example.com")
+	var circuitOpen *CircuitOpenError
+	require.True(t, errors.As(err, &circuitOpen))
+	require.Equal(t, 2, calls, "retries end as soon as the circuit opens")
+}
@@ -0,0 +1,448 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStatus tells how a Response was produced by a Cache.
+type CacheStatus string
+
+const (
+	// CacheHit is a fresh response served from the cache.
+	CacheHit CacheStatus = "hit"
+	// CacheMiss is a response fetched from the upstream.
+	CacheMiss CacheStatus = "miss"
+	// CacheRevalidated is a cached response the upstream confirmed with
+	// 304 Not Modified.
+	CacheRevalidated CacheStatus = "revalidated"
+	// CacheStale is a stale response served under stale-while-revalidate,
+	// stale-if-error or max-stale.
+	CacheStale CacheStatus = "stale"
+)
+
+// heuristicFreshnessCap caps the freshness derived from Last-Modified when
+// a response has no explicit expiration.
+const heuristicFreshnessCap = 24 * time.Hour
+
+// Cache is a private HTTP cache for Client following RFC 9111. It stores GET
+// responses according to Cache-Control (max-age, no-store, no-cache,
+// must-revalidate, stale-while-revalidate, stale-if-error), Expires and Vary,
+// and revalidates stale responses with If-None-Match and If-Modified-Since.
+// Successful unsafe requests invalidate the cached response of their URL.
+// Range requests bypass the cache, and partial and 5xx responses are never
+// stored. Requests with an Authorization or Cookie header are never served
+// from the cache, their responses are only stored when marked public. The
+// CacheStatus of every Response served through it is set.
+//
+//	client.Cache = NewCache(NewMemoryCacheStore(64 << 20))
+type Cache struct {
+	store CacheStore
+	now   func() time.Time
+
+	mu           sync.Mutex
+	revalidating map[string]bool
+}
+
+// NewCache creates a Cache that keeps its entries in store.
+func NewCache(store CacheStore) *Cache {
+	return &Cache{
+		store:        store,
+		now:          time.Now,
+		revalidating: map[string]bool{},
+	}
+}
+
+type fetchFunc func(ctx context.Context, request *http.Request) (*Response, error)
+
+// cacheEntry is a stored response, it is kept serialized in the CacheStore.
+type cacheEntry struct {
+	StatusCode   int               `json:"statusCode"`
+	Header       http.Header       `json:"header"`
+	Body         []byte            `json:"body"`
+	RequestTime  time.Time         `json:"requestTime"`
+	ResponseTime time.Time         `json:"responseTime"`
+	Vary         map[string]string `json:"vary,omitempty"`
+}
+
+func (ca *Cache) do(ctx context.Context, request *http.Request, fetch fetchFunc) (*Response, error) {
+	key := cacheKey(request)
+	switch request.Method {
+	case http.MethodGet:
+	case http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return fetch(ctx, request)
+	default:
+		resp, err := fetch(ctx, request)
+		if err == nil && resp != nil && resp.StatusCode < http.StatusBadRequest {
+			ca.store.Delete(key)
+		}
+		return resp, err
+	}
+
+	reqCC := parseCacheControl(request.Header)
+	if reqCC.has("no-store") || request.Header.Get("Range") != "" {
+		// partial responses are neither served from nor stored in the cache
+		return fetch(ctx, request)
+	}
+
+	if hasCredentials(request.Header) {
+		return ca.fetchAndStore(ctx, key, request, fetch)
+	}
+
+	entry := ca.load(key, request)
+	if entry == nil {
+		if reqCC.has("only-if-cached") {
+			return &Response{StatusCode: http.StatusGatewayTimeout, OriginalRequest: request, CacheStatus: CacheMiss}, nil
+		}
+		return ca.fetchAndStore(ctx, key, request, fetch)
+	}
+
+	now := ca.now()
+	respCC := parseCacheControl(entry.Header)
+	age := entry.age(now)
+	lifetime := entry.freshnessLifetime(respCC)
+	mustRevalidate := reqCC.has("no-cache") || respCC.has("no-cache")
+
+	if !mustRevalidate && entry.satisfies(reqCC, age, lifetime) {
+		return entry.response(request, CacheHit), nil
+	}
+
+	staleness := age - lifetime
+	if !mustRevalidate && !respCC.has("must-revalidate") && !reqCC.has("max-age") {
+		if swr, ok := respCC.duration("stale-while-revalidate"); ok && staleness <= swr {
+			ca.revalidateInBackground(ctx, key, request, entry, fetch)
+			return entry.response(request, CacheStale), nil
+		}
+	}
+	if reqCC.has("only-if-cached") {
+		return entry.response(request, CacheStale), nil
+	}
+
+	resp, err := fetch(ctx, conditionalRequest(ctx, request, entry))
+	if err != nil || (resp != nil && resp.StatusCode >= http.StatusInternalServerError) {
+		if entry.staleIfError(reqCC, respCC, staleness) {
+			logAt(ctx, LogLevelWarn, "serving stale response: revalidation failed", LogFields{LogFieldURL: request.URL, LogFieldError: errOrStatus(resp, err)})
+			return entry.response(request, CacheStale), nil
+		}
+		return resp, err
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		entry.refresh(resp, now, ca.now())
+		ca.save(key, entry)
+		return entry.response(request, CacheRevalidated), nil
+	}
+	return ca.storeResponse(key, request, resp, now), nil
+}
+
+func (ca *Cache) fetchAndStore(ctx context.Context, key string, request *http.Request, fetch fetchFunc) (*Response, error) {
+	requestTime := ca.now()
+	resp, err := fetch(ctx, request)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+	return ca.storeResponse(key, request, resp, requestTime), nil
+}
+
+// storeResponse stores resp if it is cacheable and returns it as a miss.
+func (ca *Cache) storeResponse(key string, request *http.Request, resp *Response, requestTime time.Time) *Response {
+	resp.CacheStatus = CacheMiss
+	entry := &cacheEntry{
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		Body:         resp.Body,
+		RequestTime:  requestTime,
+		ResponseTime: ca.now(),
+	}
+	if !cacheable(request, entry) {
+		if !hasCredentials(request.Header) {
+			ca.store.Delete(key) // the new response replaces any stored one
+		}
+		return resp
+	}
+	entry.Vary = varyValues(entry.Header, request.Header)
+	ca.save(key, entry)
+	return resp
+}
+
+func (ca *Cache) revalidateInBackground(ctx context.Context, key string, request *http.Request, entry *cacheEntry, fetch fetchFunc) {
+	ca.mu.Lock()
+	if ca.revalidating[key] {
+		ca.mu.Unlock()
+		return
+	}
+	ca.revalidating[key] = true
+	ca.mu.Unlock()
+
+	// the caller does not wait for the revalidation, so it must not be
+	// cancelled with the caller's context
+	bgCtx := detachedContext{parent: ctx}
+	conditional := conditionalRequest(bgCtx, request, entry)
+	go func() {
+		defer func() {
+			ca.mu.Lock()
+			delete(ca.revalidating, key)
+			ca.mu.Unlock()
+		}()
+		requestTime := ca.now()
+		resp, err := fetch(bgCtx, conditional)
+		if err != nil || resp == nil {
+			logAt(ctx, LogLevelWarn, "background revalidation failed", LogFields{LogFieldURL: request.URL, LogFieldError: err})
+			return
+		}
+		if resp.StatusCode == http.StatusNotModified {
+			entry.refresh(resp, requestTime, ca.now())
+			ca.save(key, entry)
+			return
+		}
+		if resp.StatusCode < http.StatusInternalServerError {
+			ca.storeResponse(key, conditional, resp, requestTime)
+		}
+	}()
+}
+
+func (ca *Cache) load(key string, request *http.Request) *cacheEntry {
+	data, ok := ca.store.Get(key)
+	if !ok {
+		return nil
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		ca.store.Delete(key)
+		return nil
+	}
+	for name, value := range entry.Vary {
+		if strings.Join(request.Header.Values(name), ", ") != value {
+			return nil
+		}
+	}
+	return entry
+}
+
+func (ca *Cache) save(key string, entry *cacheEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	ca.store.Set(key, data)
+}
+
+func cacheKey(request *http.Request) string {
+	return http.MethodGet + " " + request.URL.String()
+}
+
+// hasCredentials reports whether header carries credentials, whose responses
+// may differ from caller to caller.
+func hasCredentials(header http.Header) bool {
+	return header.Get("Authorization") != "" || header.Get("Cookie") != ""
+}
+
+// cacheable reports whether a response to request may be stored.
+func cacheable(request *http.Request, entry *cacheEntry) bool {
+	if entry.StatusCode == http.StatusPartialContent || entry.StatusCode >= http.StatusInternalServerError {
+		return false
+	}
+	respCC := parseCacheControl(entry.Header)
+	if respCC.has("no-store") || parseCacheControl(request.Header).has("no-store") {
+		return false
+	}
+	if hasCredentials(request.Header) && !respCC.has("public") && !respCC.has("s-maxage") && !respCC.has("must-revalidate") {
+		return false // a personal response (RFC 9111 section 3.5)
+	}
+	for _, vary := range entry.Header.Values("Vary") {
+		if strings.TrimSpace(vary) == "*" {
+			return false
+		}
+	}
+	if respCC.has("max-age") || entry.Header.Get("Expires") != "" {
+		return true
+	}
+	switch entry.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusPermanentRedirect,
+		http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusGone,
+		http.StatusRequestURITooLong:
+		// heuristically cacheable, only useful with a validator or Last-Modified
+		return entry.Header.Get("ETag") != "" || entry.Header.Get("Last-Modified") != ""
+	}
+	return false
+}
+
+// varyValues captures the request headers named by the Vary header.
+func varyValues(respHeader, reqHeader http.Header) map[string]string {
+	var values map[string]string
+	for _, vary := range respHeader.Values("Vary") {
+		for _, name := range strings.Split(vary, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if values == nil {
+				values = map[string]string{}
+			}
+			values[name] = strings.Join(reqHeader.Values(name), ", ")
+		}
+	}
+	return values
+}
+
+// conditionalRequest copies request with the validators of entry.
+func conditionalRequest(ctx context.Context, request *http.Request, entry *cacheEntry) *http.Request {
+	conditional := request.Clone(ctx)
+	if etag := entry.Header.Get("ETag"); etag != "" {
+		conditional.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+		conditional.Header.Set("If-Modified-Since", lastModified)
+	}
+	return conditional
+}
+
+// age computes the current age of the entry (RFC 9111 section 4.2.3).
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	date := e.date()
+	apparentAge := e.ResponseTime.Sub(date)
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+	var ageValue time.Duration
+	if seconds, err := strconv.Atoi(e.Header.Get("Age")); err == nil && seconds > 0 {
+		ageValue = time.Duration(seconds) * time.Second
+	}
+	correctedAge := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	if apparentAge > correctedAge {
+		correctedAge = apparentAge
+	}
+	return correctedAge + now.Sub(e.ResponseTime)
+}
+
+// freshnessLifetime computes how long the entry is fresh (RFC 9111 section 4.2.1).
+func (e *cacheEntry) freshnessLifetime(respCC cacheControl) time.Duration {
+	if maxAge, ok := respCC.duration("max-age"); ok {
+		return maxAge
+	}
+	if expiresValue := e.Header.Get("Expires"); expiresValue != "" {
+		expires, err := http.ParseTime(expiresValue)
+		if err != nil {
+			return 0 // invalid Expires means already expired
+		}
+		return expires.Sub(e.date())
+	}
+	if lastModified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil {
+		heuristic := e.date().Sub(lastModified) / 10
+		if heuristic > heuristicFreshnessCap {
+			heuristic = heuristicFreshnessCap
+		}
+		if heuristic > 0 {
+			return heuristic
+		}
+	}
+	return 0
+}
+
+func (e *cacheEntry) date() time.Time {
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return date
+	}
+	return e.ResponseTime
+}
+
+// satisfies reports whether the entry can be served without contacting the
+// upstream given the request directives max-age, min-fresh and max-stale.
+func (e *cacheEntry) satisfies(reqCC cacheControl, age, lifetime time.Duration) bool {
+	if maxAge, ok := reqCC.duration("max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := reqCC.duration("min-fresh"); ok {
+		age += minFresh
+	}
+	if age < lifetime {
+		return true
+	}
+	if !reqCC.has("max-stale") || parseCacheControl(e.Header).has("must-revalidate") {
+		return false
+	}
+	maxStale, ok := reqCC.duration("max-stale")
+	return !ok || age-lifetime <= maxStale // max-stale without value accepts any staleness
+}
+
+func (e *cacheEntry) staleIfError(reqCC, respCC cacheControl, staleness time.Duration) bool {
+	if respCC.has("must-revalidate") || respCC.has("no-cache") {
+		return false
+	}
+	for _, cc := range []cacheControl{reqCC, respCC} {
+		if window, ok := cc.duration("stale-if-error"); ok && staleness <= window {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh updates the entry with the headers of a 304 Not Modified response.
+func (e *cacheEntry) refresh(notModified *Response, requestTime, responseTime time.Time) {
+	for name, values := range notModified.Header {
+		if name == "Content-Length" {
+			continue
+		}
+		e.Header[name] = values
+	}
+	e.RequestTime = requestTime
+	e.ResponseTime = responseTime
+}
+
+func (e *cacheEntry) response(request *http.Request, status CacheStatus) *Response {
+	return &Response{
+		Body:            append([]byte(nil), e.Body...),
+		StatusCode:      e.StatusCode,
+		Header:          e.Header.Clone(),
+		OriginalRequest: request,
+		CacheStatus:     status,
+	}
+}
+
+// cacheControl holds the parsed directives of Cache-Control headers.
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, arg, _ := strings.Cut(directive, "=")
+			cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// duration returns a delta-seconds directive value.
+func (cc cacheControl) duration(directive string) (time.Duration, bool) {
+	value, ok := cc[directive]
+	if !ok || value == "" {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+func errOrStatus(resp *Response, err error) interface{} {
+	if err != nil {
+		return err
+	}
+	return "status " + strconv.Itoa(resp.StatusCode)
+}
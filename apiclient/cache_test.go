@@ -0,0 +1,308 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newCachingClient returns a client with a Cache using a fake clock in front
+// of handler, and a counter of the requests the handler received. The server
+// sends its Date from the same clock.
+func newCachingClient(t *testing.T, handler http.HandlerFunc) (*Client, *fakeClock, *int32, func()) {
+	var calls int32
+	clock := &fakeClock{now: time.Now()}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Date", clock.Now().UTC().Format(http.TimeFormat))
+		handler(w, r)
+	}))
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	c.Cache = NewCache(NewMemoryCacheStore(1 << 20))
+	c.Cache.now = clock.Now
+	return c, clock, &calls, ts.Close
+}
+
+func cachedGet(t *testing.T, c *Client, path string, header http.Header) *Response {
+	req, err := http.NewRequest(http.MethodGet, c.BaseURL.String()+path, nil)
+	require.NoError(t, err)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := c.Do(context.Background(), req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestCache_maxAgeAndETagRevalidation(t *testing.T) {
+	c, clock, calls, teardown := newCachingClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte("reference data"))
+	})
+	defer teardown()
+
+	resp := cachedGet(t, c, "/ref", nil)
+	require.Equal(t, CacheMiss, resp.CacheStatus)
+	require.Equal(t, "reference data", string(resp.Body))
+
+	resp = cachedGet(t, c, "/ref", nil)
+	require.Equal(t, CacheHit, resp.CacheStatus)
+	require.Equal(t, "reference data", string(resp.Body))
+	require.Equal(t, int32(1), atomic.LoadInt32(calls))
+
+	clock.Advance(2 * time.Minute)
+	resp = cachedGet(t, c, "/ref", nil)
+	require.Equal(t, CacheRevalidated, resp.CacheStatus)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "reference data", string(resp.Body))
+	require.Equal(t, int32(2), atomic.LoadInt32(calls))
+
+	// the revalidation made it fresh again
+	resp = cachedGet(t, c, "/ref", nil)
+	require.Equal(t, CacheHit, resp.CacheStatus)
+
+	// request no-cache forces revalidation
+	resp = cachedGet(t, c, "/ref", http.Header{"Cache-Control": []string{"no-cache"}})
+	require.Equal(t, CacheRevalidated, resp.CacheStatus)
+	require.Equal(t, int32(3), atomic.LoadInt32(calls))
+}
+
+func TestCache_lastModifiedRevalidation(t *testing.T) {
+	lastModified := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	c, clock, _, teardown := newCachingClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=10")
+		w.Header().Set("Last-Modified", lastModified)
+		if r.Header.Get("If-Modified-Since") == lastModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		_, _ = w.Write([]byte("data"))
+	})
+	defer teardown()
+
+	require.Equal(t, CacheMiss, cachedGet(t, c, "/lm", nil).CacheStatus)
+	clock.Advance(time.Minute)
+	resp := cachedGet(t, c, "/lm", nil)
+	require.Equal(t, CacheRevalidated, resp.CacheStatus)
+	require.Equal(t, "data", string(resp.Body))
+}
+
+func TestCache_notStored(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{name: "no-store", header: http.Header{"Cache-Control": []string{"no-store, max-age=60"}}},
+		{name: "vary star", header: http.Header{"Cache-Control": []string{"max-age=60"}, "Vary": []string{"*"}}},
+		{name: "no freshness or validator", header: http.Header{}},
+		{name: "uncacheable status", header: http.Header{"Etag": []string{`"x"`}}, status: http.StatusInternalServerError},
+		{name: "server error with max-age", header: http.Header{"Cache-Control": []string{"max-age=60"}}, status: http.StatusServiceUnavailable},
+		{name: "partial content", header: http.Header{"Cache-Control": []string{"max-age=60"}}, status: http.StatusPartialContent},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _, calls, teardown := newCachingClient(t, func(w http.ResponseWriter, r *http.Request) {
+				for name, values := range tc.header {
+					w.Header()[name] = values
+				}
+				if tc.status != 0 {
+					w.WriteHeader(tc.status)
+				}
+			})
+			defer teardown()
+			require.Equal(t, CacheMiss, cachedGet(t, c, "/x", nil).CacheStatus)
+			require.Equal(t, CacheMiss, cachedGet(t, c, "/x", nil).CacheStatus)
+			require.Equal(t, int32(2), atomic.LoadInt32(calls))
+		})
+	}
+}
+
+func TestCache_rangeRequestsBypass(t *testing.T) {
+	c, _, calls, teardown := newCachingClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		http.ServeContent(w, r, "x", time.Time{}, strings.NewReader("0123456789"))
+	})
+	defer teardown()
+
+	ranged := cachedGet(t, c, "/x", http.Header{"Range": []string{"bytes=2-4"}})
+	require.Equal(t, http.StatusPartialContent, ranged.StatusCode)
+	require.Equal(t, "234", string(ranged.Body))
+	resp := cachedGet(t, c, "/x", nil)
+	require.Equal(t, CacheMiss, resp.CacheStatus, "the partial response was not stored")
+	require.Equal(t, "0123456789", string(resp.Body))
+
+	ranged = cachedGet(t, c, "/x", http.Header{"Range": []string{"bytes=5-"}})
+	require.Equal(t, "56789", string(ranged.Body), "ranges are not served from the cached response")
+	require.Equal(t, CacheHit, cachedGet(t, c, "/x", nil).CacheStatus)
+	require.Equal(t, int32(3), atomic.LoadInt32(calls))
+}
+
+func TestCache_credentials(t *testing.T) {
+	c, _, calls, teardown := newCachingClient(t, func(w http.ResponseWriter, r *http.Request) {
+		cacheControl := "max-age=60"
+		if r.URL.Path == "/public" {
+			cacheControl += ", public"
+		}
+		w.Header().Set("Cache-Control", cacheControl)
+		_, _ = w.Write([]byte("user=" + r.Header.Get("Authorization") + r.Header.Get("Cookie")))
+	})
+	defer teardown()
+
+	alice := http.Header{"Authorization": []string{"alice"}}
+	bob := http.Header{"Authorization": []string{"bob"}}
+	carol := http.Header{"Cookie": []string{"carol"}}
+	require.Equal(t, "user=alice", string(cachedGet(t, c, "/x", alice).Body))
+	require.Equal(t, "user=bob", string(cachedGet(t, c, "/x", bob).Body))
+	require.Equal(t, "user=carol", string(cachedGet(t, c, "/x", carol).Body))
+	resp := cachedGet(t, c, "/x", nil)
+	require.Equal(t, CacheMiss, resp.CacheStatus, "personal responses are not stored")
+	require.Equal(t, "user=", string(resp.Body))
+
+	// requests with credentials are not served the stored response either
+	require.Equal(t, CacheHit, cachedGet(t, c, "/x", nil).CacheStatus)
+	resp = cachedGet(t, c, "/x", alice)
+	require.Equal(t, CacheMiss, resp.CacheStatus)
+	require.Equal(t, "user=alice", string(resp.Body))
+	require.Equal(t, CacheHit, cachedGet(t, c, "/x", nil).CacheStatus, "a personal response does not evict the stored one")
+	require.Equal(t, int32(5), atomic.LoadInt32(calls))
+
+	// public responses are stored
+	require.Equal(t, CacheMiss, cachedGet(t, c, "/public", alice).CacheStatus)
+	resp = cachedGet(t, c, "/public", nil)
+	require.Equal(t, CacheHit, resp.CacheStatus)
+	require.Equal(t, "user=alice", string(resp.Body))
+}
+
+func TestCache_expiresAndVary(t *testing.T) {
+	c, clock, calls, teardown := newCachingClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Expires", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = w.Write([]byte(r.Header.Get("Accept-Language")))
+	})
+	defer teardown()
+
+	en := http.Header{"Accept-Language": []string{"en"}}
+	de := http.Header{"Accept-Language": []string{"de"}}
+	require.Equal(t, CacheMiss, cachedGet(t, c, "/v", en).CacheStatus)
+	require.Equal(t, CacheHit, cachedGet(t, c, "/v", en).CacheStatus)
+
+	resp := cachedGet(t, c, "/v", de)
+	require.Equal(t, CacheMiss, resp.CacheStatus, "a different Accept-Language is another variant")
+	require.Equal(t, "de", string(resp.Body))
+	require.Equal(t, int32(2), atomic.LoadInt32(calls))
+
+	clock.Advance(2 * time.Hour)
+	require.Equal(t, CacheMiss, cachedGet(t, c, "/v", de).CacheStatus, "expired without validators")
+}
+
+func TestCache_staleWhileRevalidate(t *testing.T) {
+	var version int32 = 1
+	c, clock, calls, teardown := newCachingClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=10, stale-while-revalidate=60")
+		_, _ = w.Write([]byte{byte('0' + atomic.LoadInt32(&version))})
+	})
+	defer teardown()
+
+	require.Equal(t, "1", string(cachedGet(t, c, "/swr", nil).Body))
+	atomic.StoreInt32(&version, 2)
+	clock.Advance(30 * time.Second)
+
+	resp := cachedGet(t, c, "/swr", nil)
+	require.Equal(t, CacheStale, resp.CacheStatus)
+	require.Equal(t, "1", string(resp.Body), "stale response is served right away")
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(calls) == 2 && cachedGet(t, c, "/swr", nil).CacheStatus == CacheHit
+	}, time.Second, 5*time.Millisecond)
+	require.Equal(t, "2", string(cachedGet(t, c, "/swr", nil).Body))
+
+	// beyond the stale-while-revalidate window the client waits for the upstream
+	clock.Advance(5 * time.Minute)
+	require.Equal(t, CacheMiss, cachedGet(t, c, "/swr", nil).CacheStatus)
+}
+
+func TestCache_staleIfError(t *testing.T) {
+	var failing int32
+	c, clock, _, teardown := newCachingClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=10, stale-if-error=60")
+		_, _ = w.Write([]byte("good"))
+	})
+	defer teardown()
+
+	cachedGet(t, c, "/sie", nil)
+	atomic.StoreInt32(&failing, 1)
+	clock.Advance(30 * time.Second)
+
+	resp := cachedGet(t, c, "/sie", nil)
+	require.Equal(t, CacheStale, resp.CacheStatus)
+	require.Equal(t, "good", string(resp.Body))
+
+	clock.Advance(5 * time.Minute)
+	resp = cachedGet(t, c, "/sie", nil)
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode, "outside the window the error is returned")
+}
+
+func TestCache_unsafeMethodInvalidates(t *testing.T) {
+	c, _, calls, teardown := newCachingClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+	})
+	defer teardown()
+
+	cachedGet(t, c, "/item", nil)
+	require.Equal(t, CacheHit, cachedGet(t, c, "/item", nil).CacheStatus)
+
+	resp, err := c.Put(context.Background(), "/item", strings.NewReader("{}"))
+	require.NoError(t, err)
+	require.Equal(t, CacheStatus(""), resp.CacheStatus)
+
+	require.Equal(t, CacheMiss, cachedGet(t, c, "/item", nil).CacheStatus)
+	require.Equal(t, int32(3), atomic.LoadInt32(calls))
+}
+
+func TestParseCacheControl(t *testing.T) {
+	cc := parseCacheControl(http.Header{"Cache-Control": []string{`max-age=60, No-Cache, private="x"`, "stale-if-error=5"}})
+	maxAge, ok := cc.duration("max-age")
+	require.True(t, ok)
+	require.Equal(t, time.Minute, maxAge)
+	require.True(t, cc.has("no-cache"))
+	require.Equal(t, "x", cc["private"])
+	sie, ok := cc.duration("stale-if-error")
+	require.True(t, ok)
+	require.Equal(t, 5*time.Second, sie)
+	_, ok = cc.duration("no-cache")
+	require.False(t, ok)
+}
@@ -0,0 +1,151 @@
+package apiclient
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CacheStore stores serialized cache entries for Cache. Implementations must
+// be safe for concurrent use.
+type CacheStore interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+// MemoryCacheStore is an in-memory CacheStore which evicts the least
+// recently used entries once the stored keys and values exceed a number of
+// bytes.
+type MemoryCacheStore struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	key   string
+	value []byte
+}
+
+// NewMemoryCacheStore creates a MemoryCacheStore holding at most maxBytes.
+func NewMemoryCacheStore(maxBytes int64) *MemoryCacheStore {
+	return &MemoryCacheStore{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+// Get implements CacheStore.
+func (s *MemoryCacheStore) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(element)
+	return element.Value.(*memoryCacheItem).value, true
+}
+
+// Set implements CacheStore. Values larger than the store are not stored.
+func (s *MemoryCacheStore) Set(key string, value []byte) {
+	size := int64(len(key) + len(value))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+	if size > s.maxBytes {
+		return
+	}
+	s.entries[key] = s.order.PushFront(&memoryCacheItem{key: key, value: value})
+	s.size += size
+	for s.size > s.maxBytes {
+		oldest := s.order.Back()
+		s.remove(oldest.Value.(*memoryCacheItem).key)
+	}
+}
+
+// Delete implements CacheStore.
+func (s *MemoryCacheStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+}
+
+// Size returns the number of bytes currently stored.
+func (s *MemoryCacheStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+func (s *MemoryCacheStore) remove(key string) {
+	element, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	item := element.Value.(*memoryCacheItem)
+	s.size -= int64(len(item.key) + len(item.value))
+	s.order.Remove(element)
+	delete(s.entries, key)
+}
+
+// DiskCacheStore is a CacheStore keeping one file per entry in a directory.
+// It does not limit its size, clean the directory up from outside if needed.
+type DiskCacheStore struct {
+	dir string
+}
+
+// NewDiskCacheStore creates a DiskCacheStore in dir, creating dir if needed.
+func NewDiskCacheStore(dir string) (*DiskCacheStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &DiskCacheStore{dir: dir}, nil
+}
+
+// Get implements CacheStore.
+func (s *DiskCacheStore) Get(key string) ([]byte, bool) {
+	value, err := ioutil.ReadFile(s.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// Set implements CacheStore. The file is written to a temporary file first
+// so readers never see a partial entry.
+func (s *DiskCacheStore) Set(key string, value []byte) {
+	tmp, err := ioutil.TempFile(s.dir, "tmp-")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(value)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(key))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+}
+
+// Delete implements CacheStore.
+func (s *DiskCacheStore) Delete(key string) {
+	_ = os.Remove(s.path(key))
+}
+
+func (s *DiskCacheStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,59 @@
+package apiclient
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryCacheStore_evictsLeastRecentlyUsed(t *testing.T) {
+	store := NewMemoryCacheStore(30)
+	store.Set("a", []byte("123456789")) // 10 bytes with the key
+	store.Set("b", []byte("123456789"))
+	store.Set("c", []byte("123456789"))
+	require.Equal(t, int64(30), store.Size())
+
+	_, ok := store.Get("a") // a is now the most recently used
+	require.True(t, ok)
+	store.Set("d", []byte("123456789"))
+
+	_, ok = store.Get("b")
+	require.False(t, ok, "b was the least recently used")
+	for _, key := range []string{"a", "c", "d"} {
+		_, ok = store.Get(key)
+		require.True(t, ok, key)
+	}
+
+	store.Set("a", []byte("1"))
+	require.Equal(t, int64(22), store.Size(), "replacing a value updates the size")
+
+	store.Set("huge", make([]byte, 100))
+	_, ok = store.Get("huge")
+	require.False(t, ok, "values larger than the store are not kept")
+
+	store.Delete("c")
+	_, ok = store.Get("c")
+	require.False(t, ok)
+	require.Equal(t, int64(12), store.Size())
+}
+
+func TestDiskCacheStore(t *testing.T) {
+	store, err := NewDiskCacheStore(t.TempDir())
+	require.NoError(t, err)
+
+	_, ok := store.Get("GET https://example.com/a")
+	require.False(t, ok)
+
+	store.Set("GET https://example.com/a", []byte("value"))
+	value, ok := store.Get("GET https://example.com/a")
+	require.True(t, ok)
+	require.Equal(t, "value", string(value))
+
+	store.Set("GET https://example.com/a", []byte("other"))
+	value, _ = store.Get("GET https://example.com/a")
+	require.Equal(t, "other", string(value))
+
+	store.Delete("GET https://example.com/a")
+	_, ok = store.Get("GET https://example.com/a")
+	require.False(t, ok)
+}
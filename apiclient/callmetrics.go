@@ -0,0 +1,102 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Outcomes of a Client.Do call, used as the outcome label of its metrics.
+const (
+	OutcomeSuccess        = "success"
+	OutcomeClientError    = "4xx"
+	OutcomeServerError    = "5xx"
+	OutcomeTimeout        = "timeout"
+	OutcomeTransportError = "transport_error"
+)
+
+// callStats collects what happens below Do during one call.
+type callStats struct {
+	attempts int32
+}
+
+func withCallStats(ctx context.Context) (context.Context, *callStats) {
+	stats := &callStats{}
+	return context.WithValue(ctx, callStatsKey, stats), stats
+}
+
+// countAttempt counts an attempt sent upstream for the call in ctx.
+func countAttempt(ctx context.Context) {
+	if stats, ok := ctx.Value(callStatsKey).(*callStats); ok {
+		atomic.AddInt32(&stats.attempts, 1)
+	}
+}
+
+// beginCall starts a Do or DoStream call. The returned context collects the
+// call stats, and finish records the metrics of the call and logs its
+// completion once the size of the response body is known.
+func (c *Client) beginCall(ctx context.Context, request *http.Request, name string) (context.Context, func(resp *Response, size int64, err error)) {
+	ctx, stats := withCallStats(ctx)
+	start := time.Now()
+	return ctx, func(resp *Response, size int64, err error) {
+		duration := time.Since(start)
+		c.recordCall(ctx, request.Method, stats, duration, resp, size, err)
+		if err != nil || resp == nil {
+			return
+		}
+		fields := LogFields{
+			LogFieldMethod:   request.Method,
+			LogFieldURL:      request.URL,
+			LogFieldStatus:   resp.StatusCode,
+			LogFieldDuration: duration,
+			"bytes":          size,
+		}
+		if resp.StatusCode >= http.StatusBadRequest && resp.Body != nil {
+			fields[LogFieldBody] = resp.Body
+		}
+		c.log(ctx, LogLevelDebug, "APIClient "+name+"(): completed", fields)
+	}
+}
+
+// recordCall records the count, latency, retries, response size and outcome
+// of a Do or DoStream call, labelled by client and route template so the number of
+// series stays bounded.
+func (c *Client) recordCall(ctx context.Context, method string, stats *callStats, duration time.Duration, resp *Response, size int64, err error) {
+	m := metricsInstruments()
+	client, route := c.name(), RouteTemplate(ctx)
+	outcome := callOutcome(resp, err)
+	m.calls.With("client", client, "method", method, "route", route, "outcome", outcome).Add(1)
+	m.callDuration.With("client", client, "method", method, "route", route, "outcome", outcome).Observe(duration.Seconds())
+	if retries := atomic.LoadInt32(&stats.attempts) - 1; retries > 0 {
+		m.callRetries.With("client", client, "method", method, "route", route).Add(float64(retries))
+	}
+	if err == nil && resp != nil {
+		m.responseSize.With("client", client, "method", method, "route", route).Observe(float64(size))
+		if resp.CompressedSize > 0 {
+			m.compressedSize.With("client", client, "method", method, "route", route, "encoding", resp.ContentEncoding).Observe(float64(resp.CompressedSize))
+		}
+	}
+}
+
+// callOutcome classifies the result of a Do call.
+func callOutcome(resp *Response, err error) string {
+	if err != nil {
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return OutcomeTimeout
+		}
+		return OutcomeTransportError
+	}
+	switch {
+	case resp == nil:
+		return OutcomeTransportError
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return OutcomeServerError
+	case resp.StatusCode >= http.StatusBadRequest:
+		return OutcomeClientError
+	}
+	return OutcomeSuccess
+}
@@ -0,0 +1,82 @@
+package apiclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CodeNamor/http/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiClient_recordsCallMetrics(t *testing.T) {
+	registry := metrics.NewPrometheusRegistry()
+	ConfigureMetrics(MetricsConfig{Provider: registry, LatencyBuckets: []float64{10}})
+	defer ConfigureMetrics(MetricsConfig{})
+
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			if atomic.AddInt32(&calls, 1) < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			_, _ = w.Write([]byte("hello"))
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/slow":
+			time.Sleep(50 * time.Millisecond)
+		case "/stream":
+			_, _ = w.Write([]byte("streamed"))
+		}
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(NewRetryClient(&http.Client{}, RetryConfig{MaxAttempts: 3, Backoff: ConstantBackoff(0)}), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	c.Name = "orders"
+
+	_, err = c.Get(WithRouteTemplate(context.Background(), "/flaky"), "/flaky", nil)
+	require.NoError(t, err)
+	_, err = c.Get(WithRouteTemplate(context.Background(), "/missing"), "/missing", nil)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(WithRouteTemplate(context.Background(), "/slow"), 10*time.Millisecond)
+	defer cancel()
+	_, err = c.Get(ctx, "/slow", nil)
+	require.Error(t, err)
+
+	// streams are recorded once their body is closed
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/stream", nil)
+	require.NoError(t, err)
+	stream, err := c.DoStream(WithRouteTemplate(context.Background(), "/stream"), req)
+	require.NoError(t, err)
+	_, err = io.Copy(io.Discard, stream.Body)
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+
+	var b strings.Builder
+	require.NoError(t, registry.Write(&b))
+	out := b.String()
+	require.Contains(t, out, `http_client_calls_total{client="orders",method="GET",route="/flaky",outcome="success"} 1`)
+	require.Contains(t, out, `http_client_calls_total{client="orders",method="GET",route="/missing",outcome="4xx"} 1`)
+	require.Contains(t, out, `http_client_calls_total{client="orders",method="GET",route="/slow",outcome="timeout"} 1`)
+	require.Contains(t, out, `http_client_call_retries_total{client="orders",method="GET",route="/flaky"} 2`)
+	require.Contains(t, out, `http_client_call_duration_count{client="orders",method="GET",route="/flaky",outcome="success"} 1`)
+	require.Contains(t, out, `http_client_response_size_sum{client="orders",method="GET",route="/flaky"} 5`)
+	require.Contains(t, out, `http_client_calls_total{client="orders",method="GET",route="/stream",outcome="success"} 1`)
+	require.Contains(t, out, `http_client_response_size_sum{client="orders",method="GET",route="/stream"} 8`)
+}
+
+func TestCallOutcome(t *testing.T) {
+	require.Equal(t, OutcomeSuccess, callOutcome(&Response{StatusCode: http.StatusNoContent}, nil))
+	require.Equal(t, OutcomeClientError, callOutcome(&Response{StatusCode: http.StatusTooManyRequests}, nil))
+	require.Equal(t, OutcomeServerError, callOutcome(&Response{StatusCode: http.StatusBadGateway}, nil))
+	require.Equal(t, OutcomeTimeout, callOutcome(nil, context.DeadlineExceeded))
+	require.Equal(t, OutcomeTransportError, callOutcome(&Response{StatusCode: http.StatusInternalServerError}, context.Canceled))
+}
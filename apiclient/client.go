@@ -3,13 +3,10 @@ package apiclient
 import (
 	"context"
 	"errors"
-	cLog "github.com/CodeNamor/custom_logging"
-	log "github.com/sirupsen/logrus"
+	"github.com/CodeNamor/http/tracing"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
-	"path"
 )
 
 // APIClient base apiClient interface
@@ -21,24 +18,86 @@ type APIClient interface {
 	Put(ctx context.Context, path string, body io.Reader) (*Response, error)
 	Delete(ctx context.Context, path string, body io.Reader) (*Response, error)
 	PostXML(ctx context.Context, path string, body io.Reader, soapAction string) (*Response, error)
+	Request(ctx context.Context, method, path string, opts ...RequestOption) (*Response, error)
+	Patch(ctx context.Context, path string, opts ...RequestOption) (*Response, error)
+	Head(ctx context.Context, path string, opts ...RequestOption) (*Response, error)
+	Options(ctx context.Context, path string, opts ...RequestOption) (*Response, error)
 }
 
 // Client holds the baseURL, client and userAgent.
 type Client struct {
-	BaseURL               *url.URL
-	UserAgent             string
+	BaseURL   *url.URL
+	UserAgent string
+	// Name labels the metrics of the client, it defaults to the host of
+	// BaseURL.
+	Name string
+	// Authenticator adds credentials to every request.
+	Authenticator Authenticator
+	// Deprecated: RequiresAuthorization, AuthHeaderName and AuthKey are only
+	// used when Authenticator is nil, use APIKeyHeader instead.
 	RequiresAuthorization bool
 	AuthHeaderName        string
 	AuthKey               string
 	HTTPClient            RetryClient
+	// MaxBodySize is the maximum response body size in bytes that Do will
+	// read, zero means no limit. It can be overridden per request with
+	// WithMaxBodySize. Larger bodies fail with a *BodyTooLargeError.
+	MaxBodySize int64
+	// KeepTruncatedBody keeps the first MaxBodySize bytes of an oversized
+	// body in the BodyTooLargeError so it can be logged.
+	KeepTruncatedBody bool
+	// DisableIdempotencyKey stops Do from generating an Idempotency-Key for
+	// POST and PATCH requests, which also makes them non retryable.
+	DisableIdempotencyKey bool
+	// RateLimiter optionally limits the rate of requests sent by Do.
+	RateLimiter *RateLimiter
+	// Coalescer optionally merges identical concurrent GET, HEAD and OPTIONS
+	// requests into one upstream call.
+	Coalescer *Coalescer
+	// Cache optionally caches GET responses following RFC 9111.
+	Cache *Cache
+	// TokenSource supplies an access token for the Authorization header of
+	// every request, it takes precedence over AuthKey. A 401 response makes
+	// the client fetch a new token and retry the request once.
+	TokenSource TokenSource
+	// Logger receives the log entries of the client, defaults to the
+	// standard logrus logger.
+	Logger Logger
+	// Redactor removes secrets from what the client logs, defaults to
+	// DefaultRedactor().
+	Redactor *Redactor
+	// Compression optionally compresses request bodies and negotiates and
+	// decodes compressed responses. Without it the transport decodes gzip
+	// responses transparently unless its DisableCompression is set.
+	Compression *CompressionConfig
+	// WireDump dumps the requests and responses of the client for
+	// debugging, see WireDumpConfig.
+	WireDump *WireDumpConfig
+	// SpanRecorder records a client span for every call and a child span for
+	// every attempt. Calls made with a context that is part of a trace
+	// propagate it even without a recorder.
+	SpanRecorder tracing.SpanRecorder
 }
 
 // Response is the basic response from the APIClient
 type Response struct {
 	Body            []byte
 	StatusCode      int
+	Header          http.Header
 	OriginalRequest *http.Request
 	FaultString     string
+	// CacheStatus tells whether the response came from the Cache, it is
+	// empty when the client has no Cache or the request bypassed it.
+	CacheStatus CacheStatus
+	// Shared is set when the response was shared between coalesced callers.
+	Shared bool
+	// ContentEncoding is the coding the body was received in before it was
+	// decoded, such as gzip, empty when it was not encoded.
+	ContentEncoding string
+	// CompressedSize is the number of encoded body bytes received. It is
+	// only known for responses decoded with the Compression of the client,
+	// it is zero for those decoded by the transport.
+	CompressedSize int64
 }
 
 // InitClient inits the client given the params passed in.
@@ -59,161 +118,221 @@ func InitClient(httpClient RetryClient, baseURL string, userAgent string, reqAut
 	return c, nil
 }
 
+// PostXML creates a SOAP POST request with the soapAction header and calls Do
 func (c *Client) PostXML(ctx context.Context, urlPath string, body io.Reader, soapAction string) (*Response, error) {
-	u := *c.BaseURL
-	u.Path = path.Join(u.Path, urlPath)
-	request, err := http.NewRequest(http.MethodPost, u.String(), body)
-	if err != nil {
-		log.Errorf("error creating POST XML request: %v", err.Error())
-
-		return nil, err
-	}
-	request.Header.Set("Content-Type", "application/json")
-
-	request.Header.Set("Content-Type", "text/xml;charset=utf-8")
-	request.Header.Set("SOAPAction", soapAction)
-	request.Header.Set("User-Agent", c.UserAgent)
-	return c.Do(ctx, request)
+	return c.Request(ctx, http.MethodPost, urlPath, BodyReader(body, "text/xml;charset=utf-8"), Header("SOAPAction", soapAction))
 }
 
 // Get basic HTTP get call with support for request parameters and query parameters
 func (c *Client) Get(ctx context.Context, urlPath string, queryParams *url.Values) (*Response, error) {
-	var u url.URL
-	if queryParams != nil && len(*queryParams) > 0 {
-		q := c.BaseURL.Query()
-		for key, value := range *queryParams {
-			q[key] = append([]string{}, value...)
-		}
-		u = *c.BaseURL
-		u.Path = path.Join(u.Path, urlPath)
-		u.RawQuery = q.Encode()
-	} else {
-		u = *c.BaseURL
-		u.Path = path.Join(u.Path, urlPath)
-	}
-	request, err := http.NewRequest(http.MethodGet, u.String(), nil)
-	if err != nil {
-		log.Errorf("error creating GET request: %v", err.Error())
-		return nil, err
-	}
-	request.Header.Set("Accept", "application/json")
-	request.Header.Set("User-Agent", c.UserAgent)
-	return c.Do(ctx, request)
+	return c.Request(ctx, http.MethodGet, urlPath, queryOption(queryParams), Header("Accept", "application/json"))
 }
 
 // Do executes a HTTP request
 func (c *Client) Do(ctx context.Context, request *http.Request) (*Response, error) {
-	if request != nil {
-		log.Debugf("APIClient Do(): method %v, url %v", request.Method, request.URL)
+	if request == nil {
+		return c.do(ctx, request)
+	}
+	ctx, finish := c.beginCall(ctx, request, "Do")
+	var resp *Response
+	var err error
+	if c.Coalescer != nil && coalescable(request) {
+		resp, err = c.Coalescer.do(ctx, request, c.do)
 	} else {
-		log.Errorf("client.DO request was NIL, can not execute do request")
-		return nil, errors.New("request was NIL, can not execute do request")
-	}
-	if c.RequiresAuthorization {
-		if c.AuthHeaderName != "" {
-			request.Header.Set(c.AuthHeaderName, c.AuthKey)
-		} else {
-			request.Header.Set("Authorization", c.AuthKey)
-		}
+		resp, err = c.do(ctx, request)
+	}
+	var size int64
+	if resp != nil {
+		size = int64(len(resp.Body))
+	}
+	finish(resp, size, err)
+	return resp, err
+}
 
+func (c *Client) do(ctx context.Context, request *http.Request) (*Response, error) {
+	if c.Cache != nil && request != nil {
+		return c.Cache.do(ctx, request, c.fetch)
 	}
+	return c.fetch(ctx, request)
+}
 
+// fetch sends the request and reads the whole response body.
+func (c *Client) fetch(ctx context.Context, request *http.Request) (*Response, error) {
 	var resp = &Response{}
 
-	request = request.WithContext(ctx)
-
-	var response *http.Response
-	request.Close = true
-	response, err := c.HTTPClient.Do(request)
+	response, request, err := c.send(ctx, request, "Do")
 	if err != nil {
-		resp.StatusCode = http.StatusInternalServerError
-		log.Errorf("Error sending HTTP request to %s: %v", request.URL, err.Error())
-		select {
-		case <-ctx.Done():
-			return resp, ctx.Err()
-		default:
+		if request == nil {
+			return nil, err
 		}
-
+		resp.StatusCode = http.StatusInternalServerError
 		return resp, err
 	}
 
 	defer response.Body.Close()
 	if response.Body != nil {
-		resp.Body, err = ioutil.ReadAll(response.Body)
+		resp.Body, err = readBody(response.Body, c.maxBodySize(ctx), response.ContentLength, c.KeepTruncatedBody)
+		var tooLarge *BodyTooLargeError
+		if errors.As(err, &tooLarge) {
+			tooLarge.URL = request.URL.String()
+			resp.OriginalRequest = request
+			resp.StatusCode = response.StatusCode
+			resp.Header = response.Header
+			c.log(ctx, LogLevelError, "Error reading HTTP response body", LogFields{LogFieldURL: request.URL, LogFieldStatus: response.StatusCode, LogFieldError: err})
+			return resp, err
+		}
 		if err != nil {
 			resp.StatusCode = http.StatusInternalServerError
-			log.WithFields(cLog.FieldsFromCTX(ctx)).Errorf("Error reading HTTP response body: %v\n", err)
+			c.log(ctx, LogLevelError, "Error reading HTTP response body", LogFields{LogFieldURL: request.URL, LogFieldStatus: response.StatusCode, LogFieldError: err})
 			return resp, err
 		}
 	}
 	resp.OriginalRequest = request
 	resp.StatusCode = response.StatusCode
+	resp.Header = response.Header
+	resp.ContentEncoding, resp.CompressedSize = responseEncoding(request, response)
 	return resp, err
 }
 
-// Put creates a put request and calls Do
-func (c *Client) Put(ctx context.Context, urlPath string, body io.Reader) (*Response, error) {
-	u := *c.BaseURL
-	u.Path = path.Join(u.Path, urlPath)
-	request, err := http.NewRequest(http.MethodPut, u.String(), body)
+// send applies authorization and the context to the request and executes it
+// with the HTTPClient. It is shared by Do and DoStream so both log and record
+// metrics the same way. The returned request is nil only when the passed in
+// request was nil.
+func (c *Client) send(ctx context.Context, request *http.Request, caller string) (*http.Response, *http.Request, error) {
+	ctx = withClient(ctx, c)
+	if request != nil {
+		logAt(ctx, LogLevelDebug, "APIClient "+caller+"()", LogFields{LogFieldMethod: request.Method, LogFieldURL: request.URL})
+	} else {
+		logAt(ctx, LogLevelError, "client."+caller+" request was NIL, can not execute do request", nil)
+		return nil, nil, errors.New("request was NIL, can not execute do request")
+	}
+	if authenticator := c.authenticator(); authenticator != nil {
+		if err := authenticator.Authenticate(ctx, request); err != nil {
+			logAt(ctx, LogLevelError, "Error authenticating request", LogFields{LogFieldMethod: request.Method, LogFieldURL: request.URL, LogFieldError: err})
+			return nil, request, err
+		}
+	}
+	var token *Token
+	if c.TokenSource != nil {
+		var err error
+		token, err = c.TokenSource.Token(ctx)
+		if err != nil {
+			logAt(ctx, LogLevelError, "Error getting access token", LogFields{LogFieldMethod: request.Method, LogFieldURL: request.URL, LogFieldError: err})
+			return nil, request, err
+		}
+		request.Header.Set("Authorization", token.authorization())
+	}
+	setCorrelationHeaders(ctx, request)
+	c.setIdempotencyKey(ctx, request)
+	var encoding *bodyEncoding
+	if c.Compression != nil {
+		if err := c.Compression.compressRequest(request); err != nil {
+			logAt(ctx, LogLevelError, "Error compressing request body", LogFields{LogFieldMethod: request.Method, LogFieldURL: request.URL, LogFieldError: err})
+			return nil, request, err
+		}
+		ctx, encoding = withBodyEncoding(ctx)
+	}
+
+	ctx, span := c.startSpan(ctx, request)
+	request = request.WithContext(ctx)
+
+	request.Close = true
+	route := RouteTemplate(ctx)
+	if c.RateLimiter != nil {
+		if err := c.RateLimiter.Wait(ctx, route); err != nil {
+			logAt(ctx, LogLevelError, "Error sending HTTP request", LogFields{LogFieldMethod: request.Method, LogFieldURL: request.URL, LogFieldError: err})
+			endSpan(span, nil, err)
+			return nil, request, err
+		}
+	}
+	var dumper *wireDumper
+	if config := c.wireDump(ctx); config != nil {
+		dumper, request = c.startWireDump(ctx, config, request)
+	}
+	response, err := c.HTTPClient.Do(request)
+	if err == nil && response.StatusCode == http.StatusUnauthorized && token != nil && canRewind(request) {
+		response, err = c.retryWithNewToken(ctx, request, response, token)
+	}
+	if err == nil && c.Compression != nil {
+		c.Compression.decodeResponse(response, encoding)
+	}
+	if dumper != nil {
+		response = dumper.finish(response, err)
+	}
+	endSpan(span, response, err)
+	if c.RateLimiter != nil {
+		c.RateLimiter.Observe(route, response)
+	}
 	if err != nil {
-		log.Errorf("failed to create PUT request: %v", err.Error())
-		return nil, err
+		logAt(ctx, LogLevelError, "Error sending HTTP request", LogFields{LogFieldMethod: request.Method, LogFieldURL: request.URL, LogFieldError: err})
+		select {
+		case <-ctx.Done():
+			return nil, request, ctx.Err()
+		default:
+		}
+
+		return nil, request, err
 	}
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("User-Agent", c.UserAgent)
-	return c.Do(ctx, request)
+	return response, request, nil
 }
 
-// Delete creates a Delete request and calls Do
-func (c *Client) Delete(ctx context.Context, urlPath string, body io.Reader) (*Response, error) {
-	u := *c.BaseURL
-	u.Path = path.Join(u.Path, urlPath)
-	request, err := http.NewRequest(http.MethodDelete, u.String(), body)
+// log writes a log entry with the Logger of the client.
+func (c *Client) log(ctx context.Context, level LogLevel, msg string, fields LogFields) {
+	logAt(withClient(ctx, c), level, msg, fields)
+}
+
+// name returns the Name of the client or the host of its BaseURL.
+func (c *Client) name() string {
+	if c.Name != "" || c.BaseURL == nil {
+		return c.Name
+	}
+	return c.BaseURL.Host
+}
+
+// retryWithNewToken sends request once more with a fresh token after the
+// upstream rejected token with 401 Unauthorized.
+func (c *Client) retryWithNewToken(ctx context.Context, request *http.Request, unauthorized *http.Response, token *Token) (*http.Response, error) {
+	if invalidator, ok := c.TokenSource.(TokenInvalidator); ok {
+		invalidator.Invalidate(token)
+	}
+	fresh, err := c.TokenSource.Token(ctx)
+	if err != nil || fresh.AccessToken == token.AccessToken {
+		return unauthorized, nil // a new token would not change the outcome
+	}
+	retry, err := rewindRequest(request)
 	if err != nil {
-		log.Errorf("failed to create DELETE request: %v", err.Error())
-		return nil, err
+		return unauthorized, nil
 	}
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("User-Agent", c.UserAgent)
-	return c.Do(ctx, request)
+	drainAndClose(unauthorized)
+	logAt(ctx, LogLevelDebug, "retrying with a new access token after 401", LogFields{LogFieldMethod: request.Method, LogFieldURL: request.URL})
+	retry.Header.Set("Authorization", fresh.authorization())
+	return c.HTTPClient.Do(retry)
+}
+
+// Put creates a put request and calls Do
+func (c *Client) Put(ctx context.Context, urlPath string, body io.Reader) (*Response, error) {
+	return c.Request(ctx, http.MethodPut, urlPath, BodyReader(body, "application/json"))
+}
+
+// Delete creates a Delete request and calls Do
+func (c *Client) Delete(ctx context.Context, urlPath string, body io.Reader) (*Response, error) {
+	return c.Request(ctx, http.MethodDelete, urlPath, BodyReader(body, "application/json"))
 }
 
 // Post creates a post request and calls Do
 func (c *Client) Post(ctx context.Context, urlPath string, body io.Reader) (*Response, error) {
-	u := *c.BaseURL
-	u.Path = path.Join(u.Path, urlPath)
-	request, err := http.NewRequest(http.MethodPost, u.String(), body)
-	if err != nil {
-		log.Errorf("failed to create POST : %v", err.Error())
-		return nil, err
-	}
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("User-Agent", c.UserAgent)
-	return c.Do(ctx, request)
+	return c.Request(ctx, http.MethodPost, urlPath, BodyReader(body, "application/json"))
 }
 
 // PostWithQueryParams creates a post request and calls Do
 func (c *Client) PostWithQueryParams(ctx context.Context, urlPath string, queryParams *url.Values, body io.Reader) (*Response, error) {
-	var u url.URL
-	if queryParams != nil && len(*queryParams) > 0 {
-		q := c.BaseURL.Query()
-		for key, value := range *queryParams {
-			q[key] = append([]string{}, value...)
-		}
-		u = *c.BaseURL
-		u.Path = path.Join(u.Path, urlPath)
-		u.RawQuery = q.Encode()
-	} else {
-		u = *c.BaseURL
-		u.Path = path.Join(u.Path, urlPath)
-	}
-	request, err := http.NewRequest(http.MethodPost, u.String(), body)
-	if err != nil {
-		log.Errorf("failed to create POST : %v", err.Error())
-		return nil, err
+	return c.Request(ctx, http.MethodPost, urlPath, queryOption(queryParams), BodyReader(body, "application/json"))
+}
+
+// queryOption adapts the optional query parameters of the legacy methods.
+func queryOption(queryParams *url.Values) RequestOption {
+	if queryParams == nil {
+		return func(*requestOptions) {}
 	}
-	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("User-Agent", c.UserAgent)
-	return c.Do(ctx, request)
+	return Query(*queryParams)
 }
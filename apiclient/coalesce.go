@@ -0,0 +1,163 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Coalescer merges identical concurrent requests of safe methods (GET, HEAD
+// and OPTIONS) into a single upstream call. Set it on Client.Coalescer to
+// enable coalescing for the client. Requests are identical when their method,
+// URL, credentials (the Authorization and Cookie headers) and the values of
+// the vary headers match, Range requests are never coalesced. Every caller
+// receives its own copy of the Response, and a caller whose context is done stops waiting
+// without cancelling the call for the others. The shared call is only
+// cancelled once every caller has given up.
+//
+//	client.Coalescer = NewCoalescer("Accept", "Accept-Language")
+type Coalescer struct {
+	varyHeaders []string
+
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	done    chan struct{}
+	resp    *Response
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// NewCoalescer creates a Coalescer which keys requests by method, URL,
+// credentials and the values of varyHeaders.
+func NewCoalescer(varyHeaders ...string) *Coalescer {
+	canonical := make([]string, len(varyHeaders))
+	for i, header := range varyHeaders {
+		canonical[i] = http.CanonicalHeaderKey(header)
+	}
+	return &Coalescer{
+		varyHeaders: canonical,
+		calls:       map[string]*coalescedCall{},
+	}
+}
+
+// coalescable reports whether request may share its response with others.
+// Range requests are left alone, as they may ask for other parts of the same
+// URL.
+func coalescable(request *http.Request) bool {
+	if request.Header.Get("Range") != "" {
+		return false
+	}
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return request.Body == nil || request.Body == http.NoBody
+	}
+	return false
+}
+
+// credentialHeaders are always part of the key, so callers with different
+// credentials never share a response.
+var credentialHeaders = []string{"Authorization", "Cookie"}
+
+func (co *Coalescer) key(request *http.Request) string {
+	var b strings.Builder
+	b.WriteString(request.Method)
+	b.WriteByte(' ')
+	b.WriteString(request.URL.String())
+	writeHeaders := func(headers []string) {
+		for _, header := range headers {
+			b.WriteByte('\n')
+			b.WriteString(header)
+			b.WriteByte(':')
+			b.WriteString(strings.Join(request.Header.Values(header), ","))
+		}
+	}
+	writeHeaders(credentialHeaders)
+	writeHeaders(co.varyHeaders)
+	return b.String()
+}
+
+// do runs fn for the first caller with a given key and hands the result to
+// every caller arriving while it is in flight.
+func (co *Coalescer) do(ctx context.Context, request *http.Request, fn func(context.Context, *http.Request) (*Response, error)) (*Response, error) {
+	key := co.key(request)
+
+	co.mu.Lock()
+	call, shared := co.calls[key]
+	if !shared {
+		// the shared call keeps the values of the first caller's context,
+		// but not its deadline or cancellation
+		callCtx, cancel := context.WithCancel(detachedContext{parent: ctx})
+		call = &coalescedCall{done: make(chan struct{}), cancel: cancel}
+		co.calls[key] = call
+		go func() {
+			call.resp, call.err = fn(callCtx, request)
+			co.mu.Lock()
+			if co.calls[key] == call {
+				delete(co.calls, key)
+			}
+			co.mu.Unlock()
+			cancel()
+			close(call.done)
+		}()
+	} else {
+		metricsInstruments().coalesced.Add(1.0)
+	}
+	call.waiters++
+	co.mu.Unlock()
+
+	select {
+	case <-call.done:
+		co.mu.Lock()
+		shared = shared || call.waiters > 1
+		co.mu.Unlock()
+		return call.copyFor(ctx, request, shared), call.err
+	case <-ctx.Done():
+		co.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// later callers must start a new call rather than join this one
+			if co.calls[key] == call {
+				delete(co.calls, key)
+			}
+			call.cancel()
+		}
+		co.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// copyFor returns an independent copy of the shared response for a caller.
+func (call *coalescedCall) copyFor(ctx context.Context, request *http.Request, shared bool) *Response {
+	if call.resp == nil {
+		return nil
+	}
+	resp := *call.resp
+	if call.resp.Body != nil {
+		resp.Body = append([]byte(nil), call.resp.Body...)
+	}
+	resp.Header = call.resp.Header.Clone()
+	if call.resp.OriginalRequest != nil {
+		resp.OriginalRequest = request.WithContext(ctx)
+	}
+	resp.Shared = shared
+	return &resp
+}
+
+// detachedContext keeps the values of its parent but is never done.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
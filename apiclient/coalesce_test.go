@@ -0,0 +1,206 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newCoalescingServer(release <-chan struct{}) (*httptest.Server, *int32, chan struct{}) {
+	var calls int32
+	aborted := make(chan struct{}, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			aborted <- struct{}{}
+			return
+		}
+		_, _ = w.Write([]byte("lang=" + r.Header.Get("Accept-Language")))
+	}))
+	return ts, &calls, aborted
+}
+
+func TestApiClient_Coalescer(t *testing.T) {
+	release := make(chan struct{})
+	ts, calls, _ := newCoalescingServer(release)
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	c.Coalescer = NewCoalescer("accept-language")
+
+	const callers = 10
+	responses := make([]*Response, callers)
+	wg := sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := c.Get(context.Background(), "/config", nil)
+			require.NoError(t, err)
+			responses[i] = resp
+		}(i)
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(calls) == 1 }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond) // let every caller join the call
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int32(1), atomic.LoadInt32(calls))
+	for _, resp := range responses {
+		require.Equal(t, "lang=", string(resp.Body))
+		require.True(t, resp.Shared)
+	}
+	responses[0].Body[0] = 'X'
+	require.Equal(t, "lang=", string(responses[1].Body), "every caller gets its own copy")
+
+	// vary headers and unsafe methods are not coalesced
+	request := func(method, lang string) {
+		req, err := http.NewRequest(method, ts.URL+"/config", strings.NewReader(""))
+		require.NoError(t, err)
+		if method == http.MethodGet {
+			req.Body = nil
+		}
+		req.Header.Set("Accept-Language", lang)
+		resp, err := c.Do(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, "lang="+lang, string(resp.Body))
+		require.False(t, resp.Shared)
+	}
+	request(http.MethodGet, "en")
+	request(http.MethodGet, "de")
+	request(http.MethodPost, "en")
+	require.Equal(t, int32(4), atomic.LoadInt32(calls))
+}
+
+func TestApiClient_Coalescer_credentials(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		_, _ = w.Write([]byte(r.Header.Get("Authorization") + r.Header.Get("Cookie")))
+	}))
+	defer ts.Close()
+	var releaseOnce sync.Once
+	defer releaseOnce.Do(func() { close(release) })
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	c.Coalescer = NewCoalescer()
+
+	credentials := [][2]string{
+		{"Authorization", "Bearer alice"},
+		{"Authorization", "Bearer bob"},
+		{"Cookie", "session=carol"},
+	}
+	bodies := make([]string, len(credentials))
+	wg := sync.WaitGroup{}
+	for i, credential := range credentials {
+		wg.Add(1)
+		go func(i int, name, value string) {
+			defer wg.Done()
+			resp, err := c.Request(context.Background(), http.MethodGet, "/me", Header(name, value))
+			require.NoError(t, err)
+			require.False(t, resp.Shared)
+			bodies[i] = string(resp.Body)
+		}(i, credential[0], credential[1])
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 3 }, time.Second, time.Millisecond)
+	releaseOnce.Do(func() { close(release) })
+	wg.Wait()
+
+	require.Equal(t, []string{"Bearer alice", "Bearer bob", "session=carol"}, bodies)
+}
+
+func TestApiClient_Coalescer_cancellation(t *testing.T) {
+	release := make(chan struct{})
+	ts, calls, aborted := newCoalescingServer(release)
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	c.Coalescer = NewCoalescer()
+
+	t.Run("cancelled caller does not cancel the others", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		first := make(chan error)
+		go func() {
+			_, err := c.Get(ctx, "/a", nil)
+			first <- err
+		}()
+		require.Eventually(t, func() bool { return atomic.LoadInt32(calls) == 1 }, time.Second, time.Millisecond)
+
+		second := make(chan *Response)
+		go func() {
+			resp, err := c.Get(context.Background(), "/a", nil)
+			require.NoError(t, err)
+			second <- resp
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		cancel()
+		require.Equal(t, context.Canceled, <-first)
+		close(release)
+		require.Equal(t, "lang=", string((<-second).Body))
+		require.Equal(t, int32(1), atomic.LoadInt32(calls))
+	})
+
+	t.Run("shared call is cancelled once every caller gave up", func(t *testing.T) {
+		c.Coalescer = NewCoalescer()
+		atomic.StoreInt32(calls, 0)
+		blocked := make(chan struct{}) // never released
+		ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(calls, 1)
+			select {
+			case <-blocked:
+			case <-r.Context().Done():
+				aborted <- struct{}{}
+			}
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := c.Get(ctx, "/b", nil)
+		require.Equal(t, context.DeadlineExceeded, err)
+		select {
+		case <-aborted:
+		case <-time.After(time.Second):
+			t.Fatal("shared request was not cancelled")
+		}
+	})
+	t.Run("caller arriving after every caller gave up starts a new call", func(t *testing.T) {
+		co := NewCoalescer()
+		release := make(chan struct{})
+		var started int32
+		fn := func(ctx context.Context, request *http.Request) (*Response, error) {
+			atomic.AddInt32(&started, 1)
+			<-release // the upstream call does not return right away when cancelled
+			return &Response{StatusCode: http.StatusOK}, ctx.Err()
+		}
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/c", nil)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = co.do(ctx, req, fn)
+		require.Equal(t, context.Canceled, err)
+
+		late := make(chan error)
+		go func() {
+			_, err := co.do(context.Background(), req, fn)
+			late <- err
+		}()
+		require.Eventually(t, func() bool { return atomic.LoadInt32(&started) == 2 }, time.Second, time.Millisecond)
+		close(release)
+		require.NoError(t, <-late)
+	})
+}
@@ -0,0 +1,237 @@
+package apiclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Content codings supported by CompressionConfig. Deflate is the zlib format
+// of RFC 1950, as defined for HTTP by RFC 9110.
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+// CompressionConfig configures the compression of request and response
+// bodies of a Client.
+type CompressionConfig struct {
+	// RequestThreshold is the size in bytes from which request bodies are
+	// compressed, zero leaves request bodies uncompressed. Only enable it for
+	// upstreams which accept a Content-Encoding on requests.
+	RequestThreshold int
+	// RequestEncoding is the coding of compressed request bodies, gzip or
+	// deflate, defaults to gzip.
+	RequestEncoding string
+	// AcceptEncodings are sent in the Accept-Encoding header, defaults to
+	// gzip and deflate. Responses in gzip or deflate are decoded when they
+	// are listed here, other codings are returned as is.
+	AcceptEncodings []string
+}
+
+func (config *CompressionConfig) requestEncoding() string {
+	if config.RequestEncoding == "" {
+		return EncodingGzip
+	}
+	return config.RequestEncoding
+}
+
+func (config *CompressionConfig) acceptEncodings() []string {
+	if len(config.AcceptEncodings) == 0 {
+		return []string{EncodingGzip, EncodingDeflate}
+	}
+	return config.AcceptEncodings
+}
+
+// compressRequest compresses the body of request when it reaches the
+// threshold and negotiates the encoding of the response. Bodies which
+// already have a Content-Encoding are left alone.
+func (config *CompressionConfig) compressRequest(request *http.Request) error {
+	if request.Header.Get("Accept-Encoding") == "" {
+		request.Header.Set("Accept-Encoding", strings.Join(config.acceptEncodings(), ", "))
+	}
+	if config.RequestThreshold <= 0 || request.Body == nil || request.Body == http.NoBody ||
+		request.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	if request.ContentLength > 0 && request.ContentLength < int64(config.RequestThreshold) {
+		return nil
+	}
+	body, err := ioutil.ReadAll(request.Body)
+	_ = request.Body.Close()
+	if err != nil {
+		return err
+	}
+	if len(body) >= config.RequestThreshold {
+		encoding := config.requestEncoding()
+		compressed, err := compress(encoding, body)
+		if err != nil {
+			return err
+		}
+		body = compressed
+		request.Header.Set("Content-Encoding", encoding)
+	}
+	request.ContentLength = int64(len(body))
+	request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return nil
+}
+
+func compress(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	switch encoding {
+	case EncodingGzip:
+		writer = gzip.NewWriter(&buf)
+	case EncodingDeflate:
+		writer = zlib.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported request encoding %q", encoding)
+	}
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeBytes decodes body in encoding, reading at most limit decoded bytes.
+func decodeBytes(encoding string, body []byte, limit int) ([]byte, error) {
+	var reader io.Reader
+	switch strings.ToLower(encoding) {
+	case EncodingGzip:
+		gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		reader = gzipReader
+	case EncodingDeflate:
+		zlibReader, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		reader = zlibReader
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+	return ioutil.ReadAll(io.LimitReader(reader, int64(limit)))
+}
+
+// responseEncoding returns the original coding and encoded size of the body
+// of a response returned by send.
+func responseEncoding(request *http.Request, response *http.Response) (string, int64) {
+	if recorded := bodyEncodingFromContext(request.Context()); recorded != nil && recorded.encoding != "" {
+		return recorded.encoding, atomic.LoadInt64(&recorded.compressed)
+	}
+	if response.Uncompressed {
+		return EncodingGzip, 0 // decoded by the transport
+	}
+	return "", 0
+}
+
+// bodyEncoding records the content coding of a response body and how many
+// encoded bytes were read, for the Response and the metrics.
+type bodyEncoding struct {
+	encoding   string
+	compressed int64
+}
+
+// withBodyEncoding returns a copy of ctx carrying a bodyEncoding, so the
+// caller of send can find it on the returned request.
+func withBodyEncoding(ctx context.Context) (context.Context, *bodyEncoding) {
+	encoding := &bodyEncoding{}
+	return context.WithValue(ctx, bodyEncodingKey, encoding), encoding
+}
+
+func bodyEncodingFromContext(ctx context.Context) *bodyEncoding {
+	encoding, _ := ctx.Value(bodyEncodingKey).(*bodyEncoding)
+	return encoding
+}
+
+// decodeResponse replaces the body of a response in one of the accepted
+// codings with its decoded body.
+func (config *CompressionConfig) decodeResponse(response *http.Response, recorded *bodyEncoding) {
+	encoding := strings.ToLower(strings.TrimSpace(response.Header.Get("Content-Encoding")))
+	if encoding == "" || response.Body == nil || response.Body == http.NoBody || !matches(config.acceptEncodings(), encoding) {
+		return
+	}
+	if encoding != EncodingGzip && encoding != EncodingDeflate {
+		return
+	}
+	counted := &countingReader{reader: response.Body}
+	response.Body = &decodedBody{encoding: encoding, counted: counted, closer: response.Body}
+	response.Header.Del("Content-Encoding")
+	response.Header.Del("Content-Length")
+	response.ContentLength = -1
+	response.Uncompressed = true
+	if recorded != nil {
+		recorded.encoding = encoding
+		counted.recorded = recorded
+	}
+}
+
+// decodedBody decodes a response body lazily, so reading the gzip or zlib
+// header happens when the body is read rather than in send.
+type decodedBody struct {
+	encoding string
+	counted  *countingReader
+	closer   io.Closer
+	decoder  io.ReadCloser
+	err      error
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.decoder == nil {
+		var reader io.ReadCloser
+		var err error
+		if b.encoding == EncodingGzip {
+			reader, err = gzip.NewReader(b.counted)
+		} else {
+			reader, err = zlib.NewReader(b.counted)
+		}
+		if err == io.EOF {
+			return 0, io.EOF // an empty body, such as the one of a HEAD request
+		}
+		if err != nil {
+			b.err = fmt.Errorf("decoding %s response body: %w", b.encoding, err)
+			return 0, b.err
+		}
+		b.decoder = reader
+	}
+	return b.decoder.Read(p)
+}
+
+func (b *decodedBody) Close() error {
+	if b.decoder != nil {
+		_ = b.decoder.Close()
+	}
+	return b.closer.Close()
+}
+
+// countingReader counts the bytes read from reader into recorded.
+type countingReader struct {
+	reader   io.Reader
+	recorded *bodyEncoding
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if r.recorded != nil {
+		atomic.AddInt64(&r.recorded.compressed, int64(n))
+	}
+	return n, err
+}
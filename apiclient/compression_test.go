@@ -0,0 +1,140 @@
+package apiclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Compression_request(t *testing.T) {
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := r.Body
+		switch r.Header.Get("Content-Encoding") {
+		case EncodingGzip:
+			reader, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			body = reader
+		case EncodingDeflate:
+			reader, err := zlib.NewReader(r.Body)
+			require.NoError(t, err)
+			body = reader
+		}
+		decoded, _ := ioutil.ReadAll(body)
+		got = append(got, r.Header.Get("Content-Encoding")+":"+string(decoded))
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	c.Compression = &CompressionConfig{RequestThreshold: 10}
+
+	large := `{"items":"` + strings.Repeat("a", 100) + `"}`
+	_, err = c.Post(context.Background(), "/", strings.NewReader(large))
+	require.NoError(t, err)
+	_, err = c.Post(context.Background(), "/", strings.NewReader(`{}`))
+	require.NoError(t, err)
+	// a body of unknown length is read to decide
+	_, err = c.Post(context.Background(), "/", ioutil.NopCloser(strings.NewReader(large)))
+	require.NoError(t, err)
+	require.Equal(t, []string{"gzip:" + large, ":{}", "gzip:" + large}, got)
+
+	// deflate bodies are in the zlib format
+	c.Compression.RequestEncoding = EncodingDeflate
+	_, err = c.Post(context.Background(), "/", strings.NewReader(large))
+	require.NoError(t, err)
+	require.Equal(t, "deflate:"+large, got[len(got)-1])
+}
+
+func TestClient_Compression_response(t *testing.T) {
+	payload := `{"items":"` + strings.Repeat("a", 1000) + `"}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		switch {
+		case r.URL.Path == "/plain":
+			_, _ = w.Write([]byte(payload))
+			return
+		case strings.HasPrefix(r.Header.Get("Accept-Encoding"), EncodingDeflate):
+			writer, _ := zlib.NewWriterLevel(&buf, zlib.BestCompression)
+			_, _ = writer.Write([]byte(payload))
+			_ = writer.Close()
+			w.Header().Set("Content-Encoding", EncodingDeflate)
+		default:
+			writer := gzip.NewWriter(&buf)
+			_, _ = writer.Write([]byte(payload))
+			_ = writer.Close()
+			w.Header().Set("Content-Encoding", EncodingGzip)
+		}
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(buf.Bytes())
+		}
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+
+	// the transport decodes gzip transparently without Compression
+	resp, err := c.Get(context.Background(), "/", nil)
+	require.NoError(t, err)
+	require.Equal(t, payload, string(resp.Body))
+	require.Equal(t, EncodingGzip, resp.ContentEncoding)
+	require.Zero(t, resp.CompressedSize)
+
+	c.Compression = &CompressionConfig{}
+	resp, err = c.Get(context.Background(), "/", nil)
+	require.NoError(t, err)
+	require.Equal(t, payload, string(resp.Body))
+	require.Equal(t, EncodingGzip, resp.ContentEncoding)
+	require.Greater(t, resp.CompressedSize, int64(0))
+	require.Less(t, resp.CompressedSize, int64(len(payload)))
+	require.Empty(t, resp.Header.Get("Content-Encoding"))
+
+	c.Compression = &CompressionConfig{AcceptEncodings: []string{EncodingDeflate}}
+	resp, err = c.Get(context.Background(), "/", nil)
+	require.NoError(t, err)
+	require.Equal(t, payload, string(resp.Body))
+	require.Equal(t, EncodingDeflate, resp.ContentEncoding)
+
+	resp, err = c.Head(context.Background(), "/")
+	require.NoError(t, err)
+	require.Empty(t, resp.Body)
+
+	resp, err = c.Get(context.Background(), "/plain", nil)
+	require.NoError(t, err)
+	require.Equal(t, payload, string(resp.Body))
+	require.Empty(t, resp.ContentEncoding)
+
+	request, err := http.NewRequest(http.MethodGet, ts.URL+"/", nil)
+	require.NoError(t, err)
+	stream, err := c.DoStream(context.Background(), request)
+	require.NoError(t, err)
+	defer stream.Close()
+	body, err := ioutil.ReadAll(stream.Body)
+	require.NoError(t, err)
+	require.Equal(t, payload, string(body))
+	require.Equal(t, EncodingDeflate, stream.ContentEncoding)
+}
+
+func TestClient_Compression_wireDump(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	ring := NewRingBufferWireDumpSink(1)
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	c.Compression = &CompressionConfig{RequestThreshold: 1}
+	c.WireDump = &WireDumpConfig{Sink: ring}
+
+	_, err = c.Post(context.Background(), "/", strings.NewReader(`{"password":"p"}`))
+	require.NoError(t, err)
+	require.Equal(t, `{"password":"[REDACTED]"}`, ring.Dumps()[0].RequestBody)
+}
@@ -0,0 +1,382 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LimitSample is the outcome of a request used to update a concurrency limit.
+type LimitSample struct {
+	// RTT is how long the request took.
+	RTT time.Duration
+	// InFlight is the number of requests in flight when it was sent.
+	InFlight int
+	// Dropped is set for timeouts and 503 Service Unavailable responses.
+	Dropped bool
+}
+
+// LimitAlgorithm computes the next concurrency limit from the current limit
+// and a sample. Implementations may keep state, each ConcurrencyLimiter needs
+// its own instance.
+type LimitAlgorithm interface {
+	Update(limit float64, sample LimitSample) float64
+}
+
+// AIMDLimit grows the limit additively by one while requests are healthy and
+// shrinks it multiplicatively when a request is dropped.
+type AIMDLimit struct {
+	// LatencyThreshold is the slowest latency that still counts as healthy,
+	// slower requests leave the limit unchanged. Zero accepts any latency.
+	LatencyThreshold time.Duration
+	// BackoffRatio multiplies the limit on a drop, defaults to 0.9.
+	BackoffRatio float64
+}
+
+// Update implements LimitAlgorithm.
+func (a *AIMDLimit) Update(limit float64, sample LimitSample) float64 {
+	if sample.Dropped {
+		ratio := a.BackoffRatio
+		if ratio <= 0 || ratio >= 1 {
+			ratio = 0.9
+		}
+		return limit * ratio
+	}
+	if a.LatencyThreshold > 0 && sample.RTT > a.LatencyThreshold {
+		return limit
+	}
+	// only grow when the limit is actually being used
+	if float64(sample.InFlight)*2 >= limit {
+		return limit + 1
+	}
+	return limit
+}
+
+// VegasLimit estimates the queueing at the upstream from the ratio of the
+// lowest latency seen to the current latency, like TCP Vegas. The limit
+// grows while the estimated queue is below Alpha, shrinks while it is above
+// Beta and is cut multiplicatively on drops.
+type VegasLimit struct {
+	// Alpha is the queue size below which the limit grows, defaults to 3.
+	Alpha float64
+	// Beta is the queue size above which the limit shrinks, defaults to 6.
+	Beta float64
+	// BackoffRatio multiplies the limit on a drop, defaults to 0.9.
+	BackoffRatio float64
+
+	mu        sync.Mutex
+	rttNoLoad time.Duration
+}
+
+// Update implements LimitAlgorithm.
+func (v *VegasLimit) Update(limit float64, sample LimitSample) float64 {
+	if sample.Dropped {
+		ratio := v.BackoffRatio
+		if ratio <= 0 || ratio >= 1 {
+			ratio = 0.9
+		}
+		return limit * ratio
+	}
+	if sample.RTT <= 0 {
+		return limit
+	}
+
+	v.mu.Lock()
+	if v.rttNoLoad == 0 || sample.RTT < v.rttNoLoad {
+		v.rttNoLoad = sample.RTT
+	}
+	rttNoLoad := v.rttNoLoad
+	v.mu.Unlock()
+
+	alpha, beta := v.Alpha, v.Beta
+	if alpha <= 0 {
+		alpha = 3
+	}
+	if beta <= alpha {
+		beta = 2 * alpha
+	}
+
+	queue := limit * (1 - float64(rttNoLoad)/float64(sample.RTT))
+	switch {
+	case queue < alpha:
+		return limit + 1
+	case queue > beta:
+		return limit - 1
+	}
+	return limit
+}
+
+// ConcurrencyLimitError is returned when a request can not be sent because
+// the concurrency limit is reached and the queue is full, or because it did
+// not leave the queue in time.
+type ConcurrencyLimitError struct {
+	Limit  int
+	Queued int
+}
+
+func (e *ConcurrencyLimitError) Error() string {
+	return fmt.Sprintf("concurrency limit of %d reached with %d requests queued", e.Limit, e.Queued)
+}
+
+// ConcurrencyLimiterConfig configures NewConcurrencyLimiter. Zero values are
+// replaced by the defaults noted on each field.
+type ConcurrencyLimiterConfig struct {
+	// Name is the limiter label of the metrics of the limiter, so several
+	// limiters can be told apart, defaults to "default".
+	Name string
+	// Algorithm adapts the limit, defaults to &AIMDLimit{}.
+	Algorithm LimitAlgorithm
+	// InitialLimit defaults to 20.
+	InitialLimit int
+	// MinLimit defaults to 1.
+	MinLimit int
+	// MaxLimit defaults to 200.
+	MaxLimit int
+	// MaxQueue is the number of requests that may wait for a slot, zero
+	// rejects requests over the limit right away.
+	MaxQueue int
+	// MaxQueueWait is the longest a request waits in the queue, zero waits
+	// until the request context is done.
+	MaxQueueWait time.Duration
+}
+
+// ConcurrencyLimiter limits the number of requests in flight to a limit that
+// adapts to the latency and errors of the upstream. Use it as a RetryClient
+// with NewConcurrencyLimitedClient. The current limit, the requests in flight
+// and the queue depth are published as gauges labelled with its Name.
+type ConcurrencyLimiter struct {
+	config ConcurrencyLimiterConfig
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	queue    []chan struct{}
+}
+
+// NewConcurrencyLimiter creates a ConcurrencyLimiter from config.
+func NewConcurrencyLimiter(config ConcurrencyLimiterConfig) *ConcurrencyLimiter {
+	if config.Name == "" {
+		config.Name = "default"
+	}
+	if config.Algorithm == nil {
+		config.Algorithm = &AIMDLimit{}
+	}
+	if config.MinLimit < 1 {
+		config.MinLimit = 1
+	}
+	if config.MaxLimit < 1 {
+		config.MaxLimit = 200
+	}
+	if config.MaxLimit < config.MinLimit {
+		config.MaxLimit = config.MinLimit
+	}
+	if config.InitialLimit < 1 {
+		config.InitialLimit = 20
+	}
+	l := &ConcurrencyLimiter{config: config}
+	l.limit = l.clamp(float64(config.InitialLimit))
+	l.publish()
+	return l
+}
+
+// Limit returns the current concurrency limit.
+func (l *ConcurrencyLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// QueueDepth returns the number of requests waiting for a slot.
+func (l *ConcurrencyLimiter) QueueDepth() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.queue)
+}
+
+// Acquire waits for a slot and returns the function that must be called with
+// the sample of the request once it completed.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) (func(LimitSample), error) {
+	l.mu.Lock()
+	if l.inFlight < int(l.limit) && len(l.queue) == 0 {
+		l.inFlight++
+		l.publish()
+		l.mu.Unlock()
+		return l.slot(), nil
+	}
+	if len(l.queue) >= l.config.MaxQueue {
+		err := &ConcurrencyLimitError{Limit: int(l.limit), Queued: len(l.queue)}
+		l.mu.Unlock()
+		metricsInstruments().concurrencyRejected.With("limiter", l.config.Name).Add(1.0)
+		return nil, err
+	}
+	ready := make(chan struct{})
+	l.queue = append(l.queue, ready)
+	l.publish()
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if l.config.MaxQueueWait > 0 {
+		timer := time.NewTimer(l.config.MaxQueueWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-ready:
+		return l.slot(), nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		metricsInstruments().concurrencyRejected.With("limiter", l.config.Name).Add(1.0)
+		err = &ConcurrencyLimitError{Limit: l.Limit(), Queued: l.QueueDepth()}
+	}
+	if !l.leaveQueue(ready) {
+		// the slot was granted while giving up, pass it on without a sample
+		l.mu.Lock()
+		l.inFlight--
+		l.grant()
+		l.publish()
+		l.mu.Unlock()
+	}
+	return nil, err
+}
+
+// slot returns the release function of a slot that was already counted in
+// l.inFlight.
+func (l *ConcurrencyLimiter) slot() func(LimitSample) {
+	l.mu.Lock()
+	inFlight := l.inFlight
+	l.mu.Unlock()
+
+	var once sync.Once
+	return func(sample LimitSample) {
+		once.Do(func() {
+			sample.InFlight = inFlight
+			l.release(sample)
+		})
+	}
+}
+
+// leaveQueue removes ready from the queue, it returns false if the request
+// was already granted a slot.
+func (l *ConcurrencyLimiter) leaveQueue(ready chan struct{}) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, waiter := range l.queue {
+		if waiter == ready {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			l.publish()
+			return true
+		}
+	}
+	return false
+}
+
+func (l *ConcurrencyLimiter) release(sample LimitSample) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.limit = l.clamp(l.config.Algorithm.Update(l.limit, sample))
+	l.grant()
+	l.publish()
+}
+
+// grant hands free slots to queued requests in order, it must be called with
+// l.mu held.
+func (l *ConcurrencyLimiter) grant() {
+	for len(l.queue) > 0 && l.inFlight < int(l.limit) {
+		ready := l.queue[0]
+		l.queue = l.queue[1:]
+		l.inFlight++
+		close(ready)
+	}
+}
+
+func (l *ConcurrencyLimiter) clamp(limit float64) float64 {
+	return math.Max(float64(l.config.MinLimit), math.Min(float64(l.config.MaxLimit), limit))
+}
+
+// publish updates the gauges, it must be called with l.mu held.
+func (l *ConcurrencyLimiter) publish() {
+	m := metricsInstruments()
+	m.concurrencyLimit.With("limiter", l.config.Name).Set(math.Floor(l.limit))
+	m.concurrencyInFlight.With("limiter", l.config.Name).Set(float64(l.inFlight))
+	m.concurrencyQueue.With("limiter", l.config.Name).Set(float64(len(l.queue)))
+}
+
+// NewConcurrencyLimitedClient wraps client so that the requests in flight
+// through it are limited by limiter. Place it inside the retry engine so
+// every attempt takes a slot and contributes a sample. A slot is held until
+// the response body is closed, so long or streamed bodies count as in flight
+// and their read time is part of the sample.
+//
+//	limiter := NewConcurrencyLimiter(ConcurrencyLimiterConfig{
+//	  Algorithm:    &AIMDLimit{LatencyThreshold: 500 * time.Millisecond},
+//	  MaxQueue:     100,
+//	  MaxQueueWait: time.Second,
+//	})
+//	retryClient := NewRetryClient(NewConcurrencyLimitedClient(&http.Client{}, limiter), RetryConfig{MaxAttempts: 3})
+func NewConcurrencyLimitedClient(client RetryClient, limiter *ConcurrencyLimiter) RetryClient {
+	return &concurrencyLimitedClient{client: client, limiter: limiter}
+}
+
+type concurrencyLimitedClient struct {
+	client  RetryClient
+	limiter *ConcurrencyLimiter
+}
+
+func (cc *concurrencyLimitedClient) Do(req *http.Request) (*http.Response, error) {
+	done, err := cc.limiter.Acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := cc.client.Do(req)
+	if err != nil || resp == nil || resp.Body == nil {
+		done(LimitSample{RTT: time.Since(start), Dropped: isDropped(resp, err)})
+		return resp, err
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, done: done, start: start, dropped: isDropped(resp, nil)}
+	return resp, nil
+}
+
+// limitedBody releases the slot of its request when it is closed.
+type limitedBody struct {
+	io.ReadCloser
+	done    func(LimitSample)
+	start   time.Time
+	dropped bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && isDropped(nil, err) {
+		b.dropped = true
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done(LimitSample{RTT: time.Since(b.start), Dropped: b.dropped})
+	return err
+}
+
+// isDropped reports whether a request timed out or was shed by the upstream.
+func isDropped(resp *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return true
+		}
+		var netErr net.Error
+		return errors.As(err, &netErr) && netErr.Timeout()
+	}
+	return resp != nil && resp.StatusCode == http.StatusServiceUnavailable
+}
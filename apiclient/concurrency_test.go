@@ -0,0 +1,186 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAIMDLimit(t *testing.T) {
+	aimd := &AIMDLimit{LatencyThreshold: 100 * time.Millisecond}
+	require.Equal(t, 11.0, aimd.Update(10, LimitSample{RTT: 10 * time.Millisecond, InFlight: 10}))
+	require.Equal(t, 10.0, aimd.Update(10, LimitSample{RTT: 10 * time.Millisecond, InFlight: 2}), "unused limit does not grow")
+	require.Equal(t, 10.0, aimd.Update(10, LimitSample{RTT: time.Second, InFlight: 10}), "slow requests do not grow the limit")
+	require.Equal(t, 9.0, aimd.Update(10, LimitSample{Dropped: true}))
+}
+
+func TestVegasLimit(t *testing.T) {
+	vegas := &VegasLimit{Alpha: 2, Beta: 4}
+	require.Equal(t, 11.0, vegas.Update(10, LimitSample{RTT: 10 * time.Millisecond}), "no queueing grows the limit")
+	require.Equal(t, 9.0, vegas.Update(10, LimitSample{RTT: 20 * time.Millisecond}), "latency doubled, estimated queue is 5")
+	require.Equal(t, 10.0, vegas.Update(10, LimitSample{RTT: 13 * time.Millisecond}), "estimated queue of 2.3 keeps the limit")
+	require.Equal(t, 9.0, vegas.Update(10, LimitSample{Dropped: true}))
+}
+
+func TestConcurrencyLimiter_queue(t *testing.T) {
+	limiter := NewConcurrencyLimiter(ConcurrencyLimiterConfig{
+		InitialLimit: 2,
+		MaxLimit:     2,
+		MaxQueue:     1,
+		MaxQueueWait: 50 * time.Millisecond,
+	})
+	ctx := context.Background()
+
+	done1, err := limiter.Acquire(ctx)
+	require.NoError(t, err)
+	done2, err := limiter.Acquire(ctx)
+	require.NoError(t, err)
+
+	// third request queues and gets the slot of the first one
+	granted := make(chan error)
+	go func() {
+		done, err := limiter.Acquire(ctx)
+		if done != nil {
+			defer done(LimitSample{})
+		}
+		granted <- err
+	}()
+	require.Eventually(t, func() bool { return limiter.QueueDepth() == 1 }, time.Second, time.Millisecond)
+
+	// queue is full
+	_, err = limiter.Acquire(ctx)
+	var limited *ConcurrencyLimitError
+	require.True(t, errors.As(err, &limited))
+	require.Equal(t, 2, limited.Limit)
+	require.Equal(t, 1, limited.Queued)
+
+	done1(LimitSample{RTT: time.Millisecond})
+	require.NoError(t, <-granted)
+	done2(LimitSample{RTT: time.Millisecond})
+
+	// queued requests give up after MaxQueueWait
+	done1, _ = limiter.Acquire(ctx)
+	done2, _ = limiter.Acquire(ctx)
+	start := time.Now()
+	_, err = limiter.Acquire(ctx)
+	require.True(t, errors.As(err, &limited))
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	require.Equal(t, 0, limiter.QueueDepth())
+
+	// and when their context is done
+	cancelled, cancel := context.WithCancel(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = limiter.Acquire(cancelled)
+	require.Equal(t, context.Canceled, err)
+	done1(LimitSample{})
+	done2(LimitSample{})
+}
+
+func TestConcurrencyLimitedClient_adapts(t *testing.T) {
+	var mu sync.Mutex
+	status := http.StatusOK
+	upstream := retryClientFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return &http.Response{StatusCode: status, Body: http.NoBody}, nil
+	})
+	limiter := NewConcurrencyLimiter(ConcurrencyLimiterConfig{Name: "adapts", InitialLimit: 1, MaxLimit: 5})
+	other := NewConcurrencyLimiter(ConcurrencyLimiterConfig{Name: "other", InitialLimit: 7})
+	client := NewConcurrencyLimitedClient(upstream, limiter)
+
+	for i := 0; i < 10; i++ {
+		resp, err := doGet(t, client, "http://a.example.com")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+	// sequential calls only use one slot, so the limit only grows while
+	// one slot is at least half of it
+	require.Equal(t, 3, limiter.Limit())
+
+	mu.Lock()
+	status = http.StatusServiceUnavailable
+	mu.Unlock()
+	for i := 0; i < 10; i++ {
+		resp, err := doGet(t, client, "http://a.example.com")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+	require.Equal(t, 1, limiter.Limit(), "503s shrink the limit down to MinLimit")
+	gauges := expvar.Get(expvarHTTPClientConcurrencyLimit).(*expvar.Map)
+	require.Equal(t, "1", gauges.Get("limiter=adapts").String())
+	require.Equal(t, "7", gauges.Get("limiter=other").String(), "limiters publish their own series")
+	require.Equal(t, 7, other.Limit())
+}
+
+type limitAlgorithmFunc func(limit float64, sample LimitSample) float64
+
+func (f limitAlgorithmFunc) Update(limit float64, sample LimitSample) float64 {
+	return f(limit, sample)
+}
+
+func TestConcurrencyLimitedClient_holdsSlotUntilBodyClosed(t *testing.T) {
+	var samples []LimitSample
+	algorithm := limitAlgorithmFunc(func(limit float64, sample LimitSample) float64 {
+		samples = append(samples, sample)
+		return limit
+	})
+	upstream := retryClientFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("slow body"))}, nil
+	})
+	limiter := NewConcurrencyLimiter(ConcurrencyLimiterConfig{Algorithm: algorithm, InitialLimit: 1, MaxLimit: 1})
+	client := NewConcurrencyLimitedClient(upstream, limiter)
+
+	resp, err := doGet(t, client, "http://a.example.com")
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond) // reading a slow body
+	_, err = doGet(t, client, "http://a.example.com")
+	var limited *ConcurrencyLimitError
+	require.True(t, errors.As(err, &limited), "the slot is held while the body is read")
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "slow body", string(body))
+	require.NoError(t, resp.Body.Close())
+	require.NoError(t, resp.Body.Close(), "closing twice releases once")
+	require.Len(t, samples, 1)
+	require.GreaterOrEqual(t, samples[0].RTT, 20*time.Millisecond, "the sample covers the body")
+
+	resp, err = doGet(t, client, "http://a.example.com")
+	require.NoError(t, err, "closing the body released the slot")
+	require.NoError(t, resp.Body.Close())
+}
+
+func TestConcurrencyLimiter_stopsRetries(t *testing.T) {
+	limiter := NewConcurrencyLimiter(ConcurrencyLimiterConfig{InitialLimit: 1, MaxLimit: 1})
+	done, err := limiter.Acquire(context.Background())
+	require.NoError(t, err)
+	defer done(LimitSample{})
+
+	calls := 0
+	upstream := retryClientFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	attempts := 0
+	counting := retryClientFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return NewConcurrencyLimitedClient(upstream, limiter).Do(req)
+	})
+	client := NewRetryClient(counting, RetryConfig{MaxAttempts: 5, Backoff: ConstantBackoff(0)})
+
+	req, err := http.NewRequest(http.MethodGet, "http://a.example.com", nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	var limited *ConcurrencyLimitError
+	require.True(t, errors.As(err, &limited))
+	require.Equal(t, 1, attempts, "a shed request is not retried")
+	require.Equal(t, 0, calls)
+}
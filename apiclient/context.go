@@ -0,0 +1,89 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	cLog "github.com/CodeNamor/custom_logging"
+	"github.com/CodeNamor/http/middleware"
+)
+
+// contextKey is the type of the context keys used by this package to carry
+// per-request settings through Do and the RetryClient chain.
+type contextKey int
+
+const (
+	maxBodySizeKey contextKey = iota
+	idempotencyKeyKey
+	idempotentKey
+	routeTemplateKey
+	clientKey
+	callStatsKey
+	wireDumpKey
+	retryConfigKey
+	bodyEncodingKey
+	spanURLKey
+)
+
+// WithRouteTemplate returns a context that names the route template of the
+// requests made with it, for example "/products/{id}". Rate limits, metrics
+// and logs use the template instead of the raw URL path so they stay bounded
+// no matter how many distinct ids are requested.
+//
+//	ctx = WithRouteTemplate(ctx, "/products/{id}")
+//	resp, err := client.Get(ctx, "/products/"+id, nil)
+func WithRouteTemplate(ctx context.Context, template string) context.Context {
+	return context.WithValue(ctx, routeTemplateKey, template)
+}
+
+// RouteTemplate returns the route template set with WithRouteTemplate, or an
+// empty string when there is none.
+func RouteTemplate(ctx context.Context) string {
+	template, _ := ctx.Value(routeTemplateKey).(string)
+	return template
+}
+
+// withClient returns a copy of ctx naming c as the Client of the call, so the
+// layers below it label metrics and log with the settings of c.
+func withClient(ctx context.Context, c *Client) context.Context {
+	return context.WithValue(ctx, clientKey, c)
+}
+
+func clientFromContext(ctx context.Context) *Client {
+	c, _ := ctx.Value(clientKey).(*Client)
+	return c
+}
+
+// clientName returns the name of the Client sending the request in ctx, it
+// labels the metrics recorded below the Client.
+func clientName(ctx context.Context) string {
+	if c := clientFromContext(ctx); c != nil {
+		return c.name()
+	}
+	return ""
+}
+
+// logFields returns the log fields for ctx: the custom_logging fields plus the
+// request ID and correlation headers stored by the middleware package.
+func logFields(ctx context.Context) LogFields {
+	fields := LogFields(cLog.FieldsFromCTX(ctx))
+	for name, values := range middleware.CorrelationHeaders(ctx) {
+		if name == middleware.RequestIDHeader {
+			fields[LogFieldRequestID] = values[0]
+			continue
+		}
+		fields[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	return fields
+}
+
+// setCorrelationHeaders forwards the request ID and correlation headers in ctx
+// on request, headers already set on the request are kept.
+func setCorrelationHeaders(ctx context.Context, request *http.Request) {
+	for name, values := range middleware.CorrelationHeaders(ctx) {
+		if request.Header.Get(name) == "" {
+			request.Header[name] = values
+		}
+	}
+}
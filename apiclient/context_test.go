@@ -0,0 +1,40 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CodeNamor/http/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiClient_forwardsCorrelationHeaders(t *testing.T) {
+	var seen http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+
+	ctx := middleware.WithRequestID(context.Background(), "req-1")
+	ctx = middleware.WithCorrelationHeaders(ctx, http.Header{"X-Tenant-Id": {"acme"}})
+	_, err = c.Get(ctx, "/", nil)
+	require.NoError(t, err)
+	require.Equal(t, "req-1", seen.Get(middleware.RequestIDHeader))
+	require.Equal(t, "acme", seen.Get("X-Tenant-ID"))
+
+	fields := logFields(ctx)
+	require.Equal(t, "req-1", fields["requestId"])
+	require.Equal(t, "acme", fields["x-tenant-id"])
+
+	// headers set explicitly on the request win
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("X-Tenant-ID", "explicit")
+	_, err = c.Do(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, "explicit", seen.Get("X-Tenant-ID"))
+}
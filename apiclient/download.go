@@ -0,0 +1,428 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultPartSize = 8 << 20
+
+// ErrResourceChanged is returned when the resource being downloaded changed
+// between two ranged requests, the parts already written can not be used.
+var ErrResourceChanged = errors.New("apiclient: resource changed during download")
+
+// ChecksumError is returned when the checksum of a downloaded resource does
+// not match the expected one.
+type ChecksumError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+// DownloadConfig configures Client.Download. Zero values are replaced by the
+// defaults noted on each field.
+type DownloadConfig struct {
+	// PartSize is the size of the ranged requests, defaults to 8MB. Every
+	// part is held in memory once while it is written.
+	PartSize int64
+	// Concurrency is the number of parts downloaded in parallel, defaults to 1.
+	Concurrency int
+	// MaxResumes is how many times a failed part is requested again, on top
+	// of the retries of the HTTPClient, defaults to 3.
+	MaxResumes int
+	// Offset is the number of bytes already in the destination from an
+	// earlier download, Validator the validator that download returned. The
+	// download resumes at Offset when the resource did not change since.
+	Offset    int64
+	Validator string
+	// Hash computes the checksum of the resource, Checksum is its expected
+	// hex encoded value. A Checksum is only verified when Hash is set.
+	// Concurrency above 1 or an Offset need a destination implementing
+	// io.ReaderAt, so the resource can be read back.
+	Hash     func() hash.Hash
+	Checksum string
+	// Progress is called after every write with the bytes written so far,
+	// including Offset, and the total size or -1 when it is not known.
+	Progress func(written, total int64)
+}
+
+// DownloadResult describes a finished or interrupted download.
+type DownloadResult struct {
+	// Size is the size of the resource, -1 when it is not known.
+	Size int64
+	// Written is the number of leading bytes of the resource in the
+	// destination, an interrupted download can be resumed from there.
+	Written int64
+	// Validator is the ETag or Last-Modified of the resource, pass it back
+	// with Offset to resume.
+	Validator string
+	// Ranged tells whether the upstream served byte ranges.
+	Ranged bool
+	// Checksum is the hex encoded checksum when DownloadConfig.Hash is set.
+	Checksum string
+}
+
+// DownloadFile downloads the resource at urlPath into the file at path, see
+// Download. The file is created if needed, it is not truncated so a
+// download can be resumed into it with Offset.
+func (c *Client) DownloadFile(ctx context.Context, urlPath, path string, config DownloadConfig, opts ...RequestOption) (*DownloadResult, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	result, err := c.Download(ctx, urlPath, file, config, opts...)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		return result, closeErr
+	}
+	if err == nil {
+		err = os.Truncate(path, result.Written)
+	}
+	return result, err
+}
+
+// Download downloads the resource at urlPath into dst with ranged GET
+// requests made with Do, so the authorization, retries and metrics of the
+// client apply to every part. A part that fails is requested again from
+// where it stopped, and If-Range makes sure every part comes from the same
+// version of the resource. Upstreams without range support are downloaded
+// with a single streamed request instead.
+//
+// On error the result tells how much was written, so the download can be
+// resumed later with DownloadConfig.Offset and Validator.
+//
+//	result, err := client.DownloadFile(ctx, "/exports/2024.csv", "/tmp/2024.csv", DownloadConfig{
+//	  Concurrency: 4,
+//	  Hash:        sha256.New,
+//	  Checksum:    expected,
+//	})
+func (c *Client) Download(ctx context.Context, urlPath string, dst io.WriterAt, config DownloadConfig, opts ...RequestOption) (*DownloadResult, error) {
+	if config.PartSize <= 0 {
+		config.PartSize = defaultPartSize
+	}
+	if config.Concurrency < 1 {
+		config.Concurrency = 1
+	}
+	if config.MaxResumes <= 0 {
+		config.MaxResumes = 3
+	}
+	_, canReadBack := dst.(io.ReaderAt)
+	if config.Hash != nil && (config.Concurrency > 1 || config.Offset > 0) && !canReadBack {
+		return nil, errors.New("apiclient: verifying a parallel or resumed download needs a destination implementing io.ReaderAt")
+	}
+
+	d := &download{client: c, dst: dst, config: config, options: newRequestOptions(opts)}
+	template, err := c.newRequest(http.MethodGet, urlPath, d.options)
+	if err != nil {
+		c.log(ctx, LogLevelError, "failed to create download request", LogFields{LogFieldError: err})
+		return nil, err
+	}
+	template.Body, template.GetBody, template.ContentLength = nil, nil, 0
+	d.template = template
+	head, err := c.execute(ctx, d.request(http.MethodHead), d.options)
+	if err != nil {
+		return nil, err
+	}
+	result := &DownloadResult{Size: -1, Validator: validator(head.Header)}
+	if head.StatusCode == http.StatusOK {
+		result.Size, _ = strconv.ParseInt(head.Header.Get("Content-Length"), 10, 64)
+		result.Ranged = strings.EqualFold(head.Header.Get("Accept-Ranges"), "bytes") && result.Size >= 0
+	}
+	if result.Ranged && config.Validator != "" && config.Validator != result.Validator {
+		return result, ErrResourceChanged
+	}
+	d.result = result
+
+	if result.Ranged {
+		err = d.ranges(ctx)
+	} else {
+		err = d.stream(ctx)
+	}
+	if err != nil {
+		return result, err
+	}
+	return result, d.verify()
+}
+
+type download struct {
+	client   *Client
+	template *http.Request
+	dst      io.WriterAt
+	config   DownloadConfig
+	options  *requestOptions
+	result   *DownloadResult
+
+	mu      sync.Mutex
+	written int64
+	hasher  hash.Hash
+}
+
+// request returns a fresh copy of the download request with method.
+func (d *download) request(method string) *http.Request {
+	request := d.template.Clone(context.Background())
+	request.Method = method
+	return request
+}
+
+// ranges downloads the parts from Offset to Size with Concurrency workers.
+func (d *download) ranges(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var starts []int64
+	for start := d.config.Offset; start < d.result.Size; start += d.config.PartSize {
+		starts = append(starts, start)
+	}
+	d.written = d.config.Offset
+	if d.config.Hash != nil && d.config.Concurrency == 1 && d.config.Offset == 0 {
+		d.hasher = d.config.Hash()
+	}
+	done := make([]bool, len(starts))
+	parts := make(chan int)
+	errs := make(chan error, d.config.Concurrency)
+	var wg sync.WaitGroup
+	for w := 0; w < d.config.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range parts {
+				end := starts[i] + d.config.PartSize
+				if end > d.result.Size {
+					end = d.result.Size
+				}
+				if err := d.part(ctx, starts[i], end); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+				d.mu.Lock()
+				done[i] = true
+				d.mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for i := range starts {
+		select {
+		case parts <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(parts)
+	wg.Wait()
+	close(errs)
+
+	// only the leading completed parts can be resumed from
+	d.result.Written = d.config.Offset
+	for i, start := range starts {
+		if !done[i] {
+			break
+		}
+		d.result.Written = start + d.config.PartSize
+		if d.result.Written > d.result.Size {
+			d.result.Written = d.result.Size
+		}
+	}
+	if err := <-errs; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// part downloads the bytes from start up to end, requesting the remainder
+// again when a request fails or returns less than asked for.
+func (d *download) part(ctx context.Context, start, end int64) error {
+	for resumes := 0; start < end; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		request := d.request(http.MethodGet)
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+		if d.result.Validator != "" {
+			request.Header.Set("If-Range", d.result.Validator)
+		}
+		resp, err := d.client.execute(ctx, request, d.options)
+		if err == nil {
+			switch resp.StatusCode {
+			case http.StatusPartialContent:
+				err = d.checkRange(resp, start)
+			case http.StatusOK:
+				return ErrResourceChanged // If-Range did not match
+			default:
+				err = d.client.unexpectedStatus(request, resp, []int{http.StatusPartialContent})
+			}
+		}
+		if err == nil && len(resp.Body) > 0 {
+			body := resp.Body
+			if int64(len(body)) > end-start {
+				body = body[:end-start]
+			}
+			if err = d.write(body, start); err != nil {
+				return err
+			}
+			start += int64(len(body))
+			continue
+		}
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, ErrResourceChanged) {
+			return err
+		}
+		resumes++
+		if resumes > d.config.MaxResumes {
+			return fmt.Errorf("downloading bytes %d-%d: %w", start, end-1, err)
+		}
+		d.client.log(ctx, LogLevelWarn, "resuming download part", LogFields{LogFieldURL: request.URL, LogFieldAttempt: resumes, LogFieldError: err})
+	}
+	return nil
+}
+
+// checkRange verifies that a partial response starts at start and belongs to
+// the expected version of the resource.
+func (d *download) checkRange(resp *Response, start int64) error {
+	first, _, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return err
+	}
+	if first != start {
+		return fmt.Errorf("requested range starting at %d, got %d", start, first)
+	}
+	if (size >= 0 && size != d.result.Size) || (d.result.Validator != "" && validator(resp.Header) != "" && validator(resp.Header) != d.result.Validator) {
+		return ErrResourceChanged
+	}
+	return nil
+}
+
+// stream downloads the whole resource with a single request.
+func (d *download) stream(ctx context.Context) error {
+	if d.config.Hash != nil {
+		d.hasher = d.config.Hash()
+	}
+	d.config.Offset = 0
+	request := d.request(http.MethodGet)
+	if d.options.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.options.timeout)
+		defer cancel()
+	}
+	stream, err := d.client.DoStream(ctx, request)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	if stream.StatusCode != http.StatusOK {
+		return d.client.unexpectedStatus(request, &Response{StatusCode: stream.StatusCode}, []int{http.StatusOK})
+	}
+	if d.result.Size < 0 && stream.ContentLength >= 0 {
+		d.result.Size = stream.ContentLength
+	}
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := stream.Body.Read(buf)
+		if n > 0 {
+			if writeErr := d.write(buf[:n], d.written); writeErr != nil {
+				return writeErr
+			}
+			d.result.Written = d.written
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// write writes p at offset and reports the progress.
+func (d *download) write(p []byte, offset int64) error {
+	if _, err := d.dst.WriteAt(p, offset); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.written += int64(len(p))
+	if d.hasher != nil {
+		_, _ = d.hasher.Write(p)
+	}
+	if d.config.Progress != nil {
+		d.config.Progress(d.written, d.result.Size)
+	}
+	return nil
+}
+
+// verify computes the checksum, reading the destination back when it could
+// not be computed while writing.
+func (d *download) verify() error {
+	if d.config.Hash == nil {
+		return nil
+	}
+	hasher := d.hasher
+	if hasher == nil {
+		hasher = d.config.Hash()
+		reader := io.NewSectionReader(d.dst.(io.ReaderAt), 0, d.result.Written)
+		if _, err := io.Copy(hasher, reader); err != nil {
+			return err
+		}
+	}
+	d.result.Checksum = hex.EncodeToString(hasher.Sum(nil))
+	if d.config.Checksum != "" && !strings.EqualFold(d.config.Checksum, d.result.Checksum) {
+		return &ChecksumError{Expected: d.config.Checksum, Actual: d.result.Checksum}
+	}
+	return nil
+}
+
+// validator returns the strong ETag of a response, or its Last-Modified.
+func validator(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return header.Get("Last-Modified")
+}
+
+// parseContentRange parses a Content-Range header such as
+// "bytes 0-499/1234", size is -1 when it is given as *.
+func parseContentRange(value string) (first, last, size int64, err error) {
+	invalid := fmt.Errorf("invalid Content-Range %q", value)
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "bytes ") {
+		return 0, 0, 0, invalid
+	}
+	span, total, ok := strings.Cut(value[len("bytes "):], "/")
+	if !ok {
+		return 0, 0, 0, invalid
+	}
+	from, to, ok := strings.Cut(span, "-")
+	if !ok {
+		return 0, 0, 0, invalid
+	}
+	if first, err = strconv.ParseInt(from, 10, 64); err != nil {
+		return 0, 0, 0, invalid
+	}
+	if last, err = strconv.ParseInt(to, 10, 64); err != nil || last < first {
+		return 0, 0, 0, invalid
+	}
+	size = -1
+	if total != "*" {
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, 0, invalid
+		}
+	}
+	return first, last, size, nil
+}
@@ -0,0 +1,195 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writerAt is an in-memory io.WriterAt without io.ReaderAt.
+type writerAt struct {
+	buf []byte
+}
+
+func (w *writerAt) WriteAt(p []byte, off int64) (int, error) {
+	return copy(w.buf[off:], p), nil
+}
+
+func TestParseContentRange(t *testing.T) {
+	first, last, size, err := parseContentRange("bytes 10-19/100")
+	require.NoError(t, err)
+	require.Equal(t, []int64{10, 19, 100}, []int64{first, last, size})
+	_, _, size, err = parseContentRange("bytes 0-9/*")
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), size)
+	_, _, _, err = parseContentRange("bytes */100")
+	require.Error(t, err)
+}
+
+func TestClient_Download_ranges(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 100))
+	sum := sha256.Sum256(content)
+	var dropped, ranged int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt32(&ranged, 1)
+			require.Equal(t, `"v1"`, r.Header.Get("If-Range"))
+		}
+		// cut the first ranged response short to exercise resuming
+		if r.Header.Get("Range") == "bytes=0-299" && atomic.CompareAndSwapInt32(&dropped, 0, 1) {
+			w.Header().Set("Content-Range", "bytes 0-299/1000")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(content[:120])
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+
+	var progress int64
+	dst := &writerAt{buf: make([]byte, len(content))}
+	result, err := c.Download(context.Background(), "/file", dst, DownloadConfig{
+		PartSize: 300,
+		Hash:     sha256.New,
+		Checksum: hex.EncodeToString(sum[:]),
+		Progress: func(written, total int64) {
+			require.Equal(t, int64(1000), total)
+			progress = written
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, content, dst.buf)
+	require.Equal(t, &DownloadResult{Size: 1000, Written: 1000, Validator: `"v1"`, Ranged: true, Checksum: hex.EncodeToString(sum[:])}, result)
+	require.Equal(t, int64(1000), progress)
+	require.Equal(t, int32(5), atomic.LoadInt32(&ranged), "4 parts and 1 resumed part")
+
+	// parallel parts into a file, verified by reading it back
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, bytes.Repeat([]byte("x"), 2000), 0o644))
+	result, err = c.DownloadFile(context.Background(), "/file", path, DownloadConfig{
+		PartSize:    64,
+		Concurrency: 4,
+		Hash:        sha256.New,
+		Checksum:    hex.EncodeToString(sum[:]),
+	})
+	require.NoError(t, err)
+	written, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, content, written)
+
+	// resuming a download checks the validator
+	_, err = c.Download(context.Background(), "/file", dst, DownloadConfig{Offset: 500, Validator: `"v0"`})
+	require.True(t, errors.Is(err, ErrResourceChanged))
+
+	_, err = c.Download(context.Background(), "/file", dst, DownloadConfig{Hash: sha256.New, Concurrency: 2})
+	require.Error(t, err, "a parallel checksum needs an io.ReaderAt")
+
+	_, err = c.Download(context.Background(), "/file", dst, DownloadConfig{Hash: sha256.New, Checksum: "00"})
+	var checksumErr *ChecksumError
+	require.True(t, errors.As(err, &checksumErr))
+}
+
+func TestClient_Download_cacheAndCoalescer(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 100))
+	var ranged, inFlight, maxInFlight int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt32(&ranged, 1)
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for max := atomic.LoadInt32(&maxInFlight); n > max && !atomic.CompareAndSwapInt32(&maxInFlight, max, n); {
+				max = atomic.LoadInt32(&maxInFlight)
+			}
+			time.Sleep(20 * time.Millisecond) // keep the parts in flight together
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	c.Cache = NewCache(NewMemoryCacheStore(1 << 20))
+	c.Coalescer = NewCoalescer()
+
+	for i := 0; i < 2; i++ {
+		dst := &writerAt{buf: make([]byte, len(content))}
+		result, err := c.Download(context.Background(), "/file", dst, DownloadConfig{PartSize: 300, Concurrency: 4})
+		require.NoError(t, err)
+		require.True(t, result.Ranged)
+		require.Equal(t, content, dst.buf)
+	}
+	require.Equal(t, int32(8), atomic.LoadInt32(&ranged), "every part is fetched once, it is not cached")
+	require.Greater(t, atomic.LoadInt32(&maxInFlight), int32(1), "parts are not coalesced")
+
+	resp, err := c.Get(context.Background(), "/file", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, content, resp.Body)
+}
+
+func TestClient_Download_resourceChanged(t *testing.T) {
+	var version int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"v1"`
+		if r.Header.Get("Range") != "" && r.Header.Get("Range") != "bytes=0-9" {
+			atomic.StoreInt32(&version, 2)
+		}
+		if atomic.LoadInt32(&version) == 2 {
+			etag = `"v2"`
+		}
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(strings.Repeat("a", 30)))
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+
+	result, err := c.Download(context.Background(), "/file", &writerAt{buf: make([]byte, 30)}, DownloadConfig{PartSize: 10})
+	require.True(t, errors.Is(err, ErrResourceChanged))
+	require.Equal(t, int64(10), result.Written)
+}
+
+func TestClient_Download_stream(t *testing.T) {
+	content := strings.Repeat("z", 100000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "file")
+	result, err := c.DownloadFile(context.Background(), "/file", path, DownloadConfig{Hash: sha256.New})
+	require.NoError(t, err)
+	require.False(t, result.Ranged)
+	require.Equal(t, int64(len(content)), result.Written)
+	sum := sha256.Sum256([]byte(content))
+	require.Equal(t, hex.EncodeToString(sum[:]), result.Checksum)
+	written, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, content, string(written))
+}
@@ -0,0 +1,210 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// GraphQLRequest is a GraphQL operation.
+type GraphQLRequest struct {
+	Query         string                 `json:"query,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// GraphQLLocation is a position in a GraphQL document.
+type GraphQLLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// GraphQLError is an entry of the errors of a GraphQL response.
+type GraphQLError struct {
+	Message   string            `json:"message"`
+	Locations []GraphQLLocation `json:"locations,omitempty"`
+	// Path is the response field the error belongs to, made of field names
+	// and list indexes.
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e GraphQLError) Error() string {
+	if len(e.Path) == 0 {
+		return e.Message
+	}
+	path := make([]string, len(e.Path))
+	for i, element := range e.Path {
+		path[i] = fmt.Sprint(element)
+	}
+	return fmt.Sprintf("%s: %s", strings.Join(path, "."), e.Message)
+}
+
+// Code returns the code of the error extensions, empty when there is none.
+func (e GraphQLError) Code() string {
+	code, _ := e.Extensions["code"].(string)
+	return code
+}
+
+// GraphQLErrors are returned by GraphQLClient.Do when the response has
+// errors, whatever its HTTP status. Data may still hold a partial result.
+type GraphQLErrors struct {
+	StatusCode int
+	Errors     []GraphQLError
+}
+
+func (e *GraphQLErrors) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return "graphql: " + strings.Join(messages, "; ")
+}
+
+// GraphQLConfig configures NewGraphQLClient.
+type GraphQLConfig struct {
+	// Path of the GraphQL endpoint relative to the BaseURL of the client,
+	// defaults to /graphql.
+	Path string
+	// PersistedQueries sends the SHA-256 hash of the query instead of the
+	// query, following the automatic persisted queries protocol. Queries
+	// unknown to the server are sent again in full, and a server without
+	// persisted query support makes the client stop using them.
+	PersistedQueries bool
+}
+
+// GraphQLClient sends GraphQL operations with an APIClient. It is safe for
+// concurrent use.
+type GraphQLClient struct {
+	client       APIClient
+	path         string
+	apq          bool
+	apqSupported int32
+}
+
+// NewGraphQLClient returns a GraphQLClient sending operations with client.
+//
+//	gql := NewGraphQLClient(client, GraphQLConfig{PersistedQueries: true})
+//	var data struct {
+//	  Order struct {
+//	    ID    string `json:"id"`
+//	    State string `json:"state"`
+//	  } `json:"order"`
+//	}
+//	err := gql.Do(ctx, GraphQLRequest{
+//	  Query:     `query Order($id: ID!) { order(id: $id) { id state } }`,
+//	  Variables: map[string]interface{}{"id": id},
+//	}, &data)
+func NewGraphQLClient(client APIClient, config GraphQLConfig) *GraphQLClient {
+	if config.Path == "" {
+		config.Path = "/graphql"
+	}
+	return &GraphQLClient{client: client, path: config.Path, apq: config.PersistedQueries, apqSupported: 1}
+}
+
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []GraphQLError  `json:"errors"`
+}
+
+// Do sends request and decodes the data of the response into data, which
+// may be nil. GraphQL errors are returned as *GraphQLErrors after decoding
+// whatever data there is. The opts apply to the HTTP request.
+func (g *GraphQLClient) Do(ctx context.Context, request GraphQLRequest, data interface{}, opts ...RequestOption) error {
+	if g.apq && atomic.LoadInt32(&g.apqSupported) == 1 && request.Query != "" {
+		persisted := request
+		persisted.Extensions = persistedQueryExtensions(request.Extensions, request.Query)
+		full := persisted.Query
+		persisted.Query = ""
+		resp, result, err := g.send(ctx, persisted, opts)
+		if err != nil {
+			return err
+		}
+		switch persistedQueryError(result.Errors) {
+		case "":
+			return g.decode(resp, result, data)
+		case "PERSISTED_QUERY_NOT_SUPPORTED":
+			atomic.StoreInt32(&g.apqSupported, 0)
+		default:
+			// register the query with the server along with its hash
+			persisted.Query = full
+			request = persisted
+		}
+	}
+	resp, result, err := g.send(ctx, request, opts)
+	if err != nil {
+		return err
+	}
+	return g.decode(resp, result, data)
+}
+
+func (g *GraphQLClient) send(ctx context.Context, request GraphQLRequest, opts []RequestOption) (*Response, *graphQLResponse, error) {
+	opts = append([]RequestOption{JSONBody(request), Header("Accept", "application/json")}, opts...)
+	resp, err := g.client.Request(ctx, http.MethodPost, g.path, opts...)
+	if err != nil {
+		return resp, nil, err
+	}
+	result := &graphQLResponse{}
+	if err := json.Unmarshal(resp.Body, result); err != nil || (result.Data == nil && result.Errors == nil) {
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return resp, nil, unexpectedStatusOf(g.client, http.MethodPost, g.path, resp, nil)
+		}
+		if err == nil {
+			err = fmt.Errorf("graphql response has neither data nor errors")
+		}
+		return resp, nil, fmt.Errorf("decoding graphql response: %w", err)
+	}
+	return resp, result, nil
+}
+
+func (g *GraphQLClient) decode(resp *Response, result *graphQLResponse, data interface{}) error {
+	if data != nil && len(result.Data) > 0 && !bytes.Equal(result.Data, []byte("null")) {
+		if err := json.Unmarshal(result.Data, data); err != nil {
+			return fmt.Errorf("decoding graphql data: %w", err)
+		}
+	}
+	if len(result.Errors) > 0 {
+		return &GraphQLErrors{StatusCode: resp.StatusCode, Errors: result.Errors}
+	}
+	return nil
+}
+
+// persistedQueryExtensions returns a copy of extensions with the
+// persistedQuery extension for query.
+func persistedQueryExtensions(extensions map[string]interface{}, query string) map[string]interface{} {
+	hash := sha256.Sum256([]byte(query))
+	copied := map[string]interface{}{
+		"persistedQuery": map[string]interface{}{
+			"version":    1,
+			"sha256Hash": hex.EncodeToString(hash[:]),
+		},
+	}
+	for name, value := range extensions {
+		if name != "persistedQuery" {
+			copied[name] = value
+		}
+	}
+	return copied
+}
+
+// persistedQueryError returns PERSISTED_QUERY_NOT_FOUND or
+// PERSISTED_QUERY_NOT_SUPPORTED when errors report one of them, recognizing
+// both the error codes and the messages servers use.
+func persistedQueryError(errors []GraphQLError) string {
+	for _, err := range errors {
+		switch {
+		case err.Code() == "PERSISTED_QUERY_NOT_FOUND" || err.Message == "PersistedQueryNotFound":
+			return "PERSISTED_QUERY_NOT_FOUND"
+		case err.Code() == "PERSISTED_QUERY_NOT_SUPPORTED" || err.Message == "PersistedQueryNotSupported":
+			return "PERSISTED_QUERY_NOT_SUPPORTED"
+		}
+	}
+	return ""
+}
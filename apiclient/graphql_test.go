@@ -0,0 +1,134 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// graphQLServer answers GraphQL operations and remembers persisted queries
+// when persisted is set.
+func graphQLServer(t *testing.T, persisted bool, received *[]GraphQLRequest) *httptest.Server {
+	var mu sync.Mutex
+	known := map[string]string{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/graphql" {
+			http.NotFound(w, r)
+			return
+		}
+		var request GraphQLRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		mu.Lock()
+		defer mu.Unlock()
+		*received = append(*received, request)
+		if ext, ok := request.Extensions["persistedQuery"].(map[string]interface{}); ok {
+			if !persisted {
+				_, _ = w.Write([]byte(`{"errors":[{"message":"PersistedQueryNotSupported"}]}`))
+				return
+			}
+			hash := ext["sha256Hash"].(string)
+			if request.Query == "" {
+				request.Query = known[hash]
+			}
+			if request.Query == "" {
+				_, _ = w.Write([]byte(`{"errors":[{"message":"PersistedQueryNotFound","extensions":{"code":"PERSISTED_QUERY_NOT_FOUND"}}]}`))
+				return
+			}
+			known[hash] = request.Query
+		}
+		switch request.OperationName {
+		case "Broken":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"errors":[{"message":"syntax error","locations":[{"line":1,"column":3}],"extensions":{"code":"GRAPHQL_PARSE_FAILED"}}]}`))
+		case "Partial":
+			_, _ = w.Write([]byte(`{"data":{"order":{"id":"1","lines":null}},"errors":[{"message":"lines unavailable","path":["order","lines",0]}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"data":{"order":{"id":"` + request.Variables["id"].(string) + `"}}}`))
+		}
+	}))
+}
+
+type orderData struct {
+	Order struct {
+		ID string `json:"id"`
+	} `json:"order"`
+}
+
+func TestGraphQLClient_Do(t *testing.T) {
+	var received []GraphQLRequest
+	ts := graphQLServer(t, false, &received)
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL+"/api", "test", false, "")
+	require.NoError(t, err)
+	gql := NewGraphQLClient(c, GraphQLConfig{})
+
+	var data orderData
+	err = gql.Do(context.Background(), GraphQLRequest{
+		Query:         `query Order($id: ID!) { order(id: $id) { id } }`,
+		Variables:     map[string]interface{}{"id": "42"},
+		OperationName: "Order",
+	}, &data)
+	require.NoError(t, err)
+	require.Equal(t, "42", data.Order.ID)
+	require.Equal(t, "Order", received[0].OperationName)
+
+	data = orderData{}
+	err = gql.Do(context.Background(), GraphQLRequest{Query: "{ order { id lines } }", OperationName: "Partial"}, &data)
+	var gqlErrs *GraphQLErrors
+	require.True(t, errors.As(err, &gqlErrs))
+	require.Equal(t, http.StatusOK, gqlErrs.StatusCode)
+	require.Equal(t, []interface{}{"order", "lines", float64(0)}, gqlErrs.Errors[0].Path)
+	require.Equal(t, "graphql: order.lines.0: lines unavailable", err.Error())
+	require.Equal(t, "1", data.Order.ID, "partial data is decoded")
+
+	err = gql.Do(context.Background(), GraphQLRequest{Query: "{ (", OperationName: "Broken"}, nil)
+	require.True(t, errors.As(err, &gqlErrs))
+	require.Equal(t, http.StatusBadRequest, gqlErrs.StatusCode)
+	require.Equal(t, "GRAPHQL_PARSE_FAILED", gqlErrs.Errors[0].Code())
+	require.Equal(t, []GraphQLLocation{{Line: 1, Column: 3}}, gqlErrs.Errors[0].Locations)
+
+	err = NewGraphQLClient(c, GraphQLConfig{Path: "/missing"}).Do(context.Background(), GraphQLRequest{Query: "{ a }"}, nil)
+	var unexpected *UnexpectedStatusError
+	require.True(t, errors.As(err, &unexpected))
+	require.Equal(t, http.StatusNotFound, unexpected.StatusCode)
+	require.Equal(t, ts.URL+"/api/missing", unexpected.URL, "the error carries the full URL like every UnexpectedStatusError")
+	require.Equal(t, "POST "+ts.URL+"/api/missing returned status 404, expected a 2xx status", err.Error())
+}
+
+func TestGraphQLClient_persistedQueries(t *testing.T) {
+	var received []GraphQLRequest
+	ts := graphQLServer(t, true, &received)
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL+"/api", "test", false, "")
+	require.NoError(t, err)
+	gql := NewGraphQLClient(c, GraphQLConfig{PersistedQueries: true})
+	request := GraphQLRequest{Query: `query Order($id: ID!) { order(id: $id) { id } }`, Variables: map[string]interface{}{"id": "7"}}
+
+	var data orderData
+	require.NoError(t, gql.Do(context.Background(), request, &data))
+	require.Equal(t, "7", data.Order.ID)
+	require.NoError(t, gql.Do(context.Background(), request, &data))
+	require.Len(t, received, 3)
+	require.Empty(t, received[0].Query, "the hash is tried first")
+	require.Equal(t, request.Query, received[1].Query, "then the full query with its hash")
+	require.NotNil(t, received[1].Extensions["persistedQuery"])
+	require.Empty(t, received[2].Query, "the hash alone is enough afterwards")
+
+	// servers without support make the client send full queries
+	received = nil
+	unsupported := graphQLServer(t, false, &received)
+	defer unsupported.Close()
+	c.BaseURL.Host = unsupported.Listener.Addr().String()
+	require.NoError(t, gql.Do(context.Background(), request, &data))
+	require.NoError(t, gql.Do(context.Background(), request, &data))
+	require.Len(t, received, 3)
+	require.Nil(t, received[2].Extensions)
+}
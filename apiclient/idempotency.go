@@ -0,0 +1,71 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyKeyHeader is the header carrying the idempotency key of a request.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// WithIdempotencyKey returns a context that makes Client use key as the
+// Idempotency-Key of POST and PATCH requests instead of generating one.
+// Pass the same key when repeating a logical call from a higher level, for
+// example after a crash, so the upstream can deduplicate it.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyKey, key)
+}
+
+// WithoutIdempotencyKey returns a context that stops Client from adding an
+// Idempotency-Key header. Without the header POST and PATCH requests are not
+// retried by the DefaultRetryPolicy.
+func WithoutIdempotencyKey(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotencyKeyKey, "")
+}
+
+// WithIdempotent returns a context that overrides whether requests made with
+// it are considered idempotent, and therefore retryable, regardless of their
+// method and headers. Use false to make sure a call is attempted only once.
+func WithIdempotent(ctx context.Context, idempotent bool) context.Context {
+	return context.WithValue(ctx, idempotentKey, idempotent)
+}
+
+// IsIdempotent reports whether req can safely be sent more than once. GET,
+// HEAD, OPTIONS, TRACE, PUT and DELETE are idempotent by definition, any
+// other method only when it carries an Idempotency-Key header. A value set
+// with WithIdempotent on the request context takes precedence.
+func IsIdempotent(req *http.Request) bool {
+	if idempotent, ok := req.Context().Value(idempotentKey).(bool); ok {
+		return idempotent
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+// setIdempotencyKey adds an Idempotency-Key header to POST and PATCH requests
+// which do not have one yet. The header is set once before the request enters
+// the RetryClient chain, so every attempt of the call carries the same key.
+func (c *Client) setIdempotencyKey(ctx context.Context, request *http.Request) {
+	if request.Method != http.MethodPost && request.Method != http.MethodPatch {
+		return
+	}
+	if request.Header.Get(IdempotencyKeyHeader) != "" {
+		return
+	}
+	key, ok := ctx.Value(idempotencyKeyKey).(string)
+	if !ok {
+		if c.DisableIdempotencyKey {
+			return
+		}
+		key = uuid.New().String()
+	}
+	if key != "" {
+		request.Header.Set(IdempotencyKeyHeader, key)
+	}
+}
@@ -0,0 +1,120 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsIdempotent(t *testing.T) {
+	testCases := []struct {
+		method string
+		key    string
+		ctx    func(context.Context) context.Context
+		want   bool
+	}{
+		{method: http.MethodGet, want: true},
+		{method: http.MethodHead, want: true},
+		{method: http.MethodOptions, want: true},
+		{method: http.MethodPut, want: true},
+		{method: http.MethodDelete, want: true},
+		{method: http.MethodPost, want: false},
+		{method: http.MethodPatch, want: false},
+		{method: http.MethodPost, key: "abc", want: true},
+		{method: http.MethodPatch, key: "abc", want: true},
+		{method: http.MethodGet, want: false, ctx: func(ctx context.Context) context.Context {
+			return WithIdempotent(ctx, false)
+		}},
+		{method: http.MethodPost, want: true, ctx: func(ctx context.Context) context.Context {
+			return WithIdempotent(ctx, true)
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.method+"/"+tc.key, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.ctx != nil {
+				ctx = tc.ctx(ctx)
+			}
+			req, err := http.NewRequestWithContext(ctx, tc.method, testURL, nil)
+			require.NoError(t, err)
+			if tc.key != "" {
+				req.Header.Set(IdempotencyKeyHeader, tc.key)
+			}
+			require.Equal(t, tc.want, IsIdempotent(req))
+		})
+	}
+}
+
+func TestApiClient_IdempotencyKey(t *testing.T) {
+	var keys []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(IdempotencyKeyHeader))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	rc := NewRetryClient(ts.Client(), RetryConfig{MaxAttempts: 3, Backoff: ConstantBackoff(0)})
+	c, err := InitClient(rc, ts.URL, "test", false, "")
+	require.NoError(t, err)
+
+	t.Run("generated key is stable across attempts", func(t *testing.T) {
+		keys = nil
+		_, err := c.Post(context.Background(), "/charge", strings.NewReader(`{}`))
+		require.NoError(t, err)
+		require.Len(t, keys, 3)
+		_, err = uuid.Parse(keys[0])
+		require.NoError(t, err)
+		require.Equal(t, keys[0], keys[1])
+		require.Equal(t, keys[0], keys[2])
+
+		first := keys[0]
+		keys = nil
+		_, err = c.Post(context.Background(), "/charge", strings.NewReader(`{}`))
+		require.NoError(t, err)
+		require.NotEqual(t, first, keys[0], "every logical call gets its own key")
+	})
+
+	t.Run("caller supplied key", func(t *testing.T) {
+		keys = nil
+		ctx := WithIdempotencyKey(context.Background(), "order-42")
+		_, err := c.Post(ctx, "/charge", strings.NewReader(`{}`))
+		require.NoError(t, err)
+		require.Equal(t, []string{"order-42", "order-42", "order-42"}, keys)
+	})
+
+	t.Run("without key POST is not retried", func(t *testing.T) {
+		keys = nil
+		ctx := WithoutIdempotencyKey(context.Background())
+		_, err := c.Post(ctx, "/charge", strings.NewReader(`{}`))
+		require.NoError(t, err)
+		require.Equal(t, []string{""}, keys)
+	})
+
+	t.Run("disabled on client", func(t *testing.T) {
+		keys = nil
+		c.DisableIdempotencyKey = true
+		defer func() { c.DisableIdempotencyKey = false }()
+		_, err := c.Post(context.Background(), "/charge", strings.NewReader(`{}`))
+		require.NoError(t, err)
+		require.Equal(t, []string{""}, keys)
+	})
+
+	t.Run("GET has no key and is retried", func(t *testing.T) {
+		keys = nil
+		_, err := c.Get(context.Background(), "/charge", nil)
+		require.NoError(t, err)
+		require.Equal(t, []string{"", "", ""}, keys)
+	})
+
+	t.Run("GET forced non idempotent", func(t *testing.T) {
+		keys = nil
+		_, err := c.Get(WithIdempotent(context.Background(), false), "/charge", nil)
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+	})
+}
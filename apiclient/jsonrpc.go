@@ -0,0 +1,199 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Error codes defined by the JSON-RPC 2.0 specification.
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+)
+
+// ErrJSONRPCNoResponse is the error of a batch call the server did not
+// answer.
+var ErrJSONRPCNoResponse = errors.New("apiclient: no JSON-RPC response for call")
+
+// JSONRPCError is a JSON-RPC error object returned by the server.
+type JSONRPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+// DecodeData decodes the data of the error into v.
+func (e *JSONRPCError) DecodeData(v interface{}) error {
+	if len(e.Data) == 0 {
+		return errors.New("jsonrpc error has no data")
+	}
+	return json.Unmarshal(e.Data, v)
+}
+
+// JSONRPCCall is one call of a batch. Err is set once the batch is sent.
+type JSONRPCCall struct {
+	Method string
+	Params interface{}
+	// Result receives the result of the call, it may be nil.
+	Result interface{}
+	// Notification calls get no response.
+	Notification bool
+	Err          error
+}
+
+// JSONRPCClient makes JSON-RPC 2.0 calls over HTTP POST with an APIClient,
+// so the authorization, retries and metrics of the client apply. It is safe
+// for concurrent use.
+type JSONRPCClient struct {
+	client APIClient
+	path   string
+	lastID uint64
+}
+
+// NewJSONRPCClient returns a JSONRPCClient posting to urlPath.
+//
+//	rpc := NewJSONRPCClient(client, "/rpc")
+//	var balance float64
+//	err := rpc.Call(ctx, "account.balance", map[string]string{"id": id}, &balance)
+//	var rpcErr *JSONRPCError
+//	if errors.As(err, &rpcErr) && rpcErr.Code == JSONRPCMethodNotFound {
+//	  ...
+//	}
+func NewJSONRPCClient(client APIClient, urlPath string) *JSONRPCClient {
+	return &JSONRPCClient{client: client, path: urlPath}
+}
+
+type jsonRPCRequest struct {
+	Version string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+	ID      *uint64     `json:"id,omitempty"`
+}
+
+type jsonRPCResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *JSONRPCError   `json:"error"`
+	ID     json.RawMessage `json:"id"`
+}
+
+// Call calls method with params, which must marshal to a JSON object or
+// array or be nil, and decodes the result into result, which may be nil.
+// Errors returned by the server are *JSONRPCError.
+func (j *JSONRPCClient) Call(ctx context.Context, method string, params interface{}, result interface{}, opts ...RequestOption) error {
+	call := &JSONRPCCall{Method: method, Params: params, Result: result}
+	if err := j.Batch(ctx, []*JSONRPCCall{call}, opts...); err != nil {
+		return err
+	}
+	return call.Err
+}
+
+// Notify sends a notification, which the server does not answer.
+func (j *JSONRPCClient) Notify(ctx context.Context, method string, params interface{}, opts ...RequestOption) error {
+	return j.Batch(ctx, []*JSONRPCCall{{Method: method, Params: params, Notification: true}}, opts...)
+}
+
+// Batch sends calls in a single request. A single call is sent on its own
+// rather than as a batch of one. Responses are matched to the calls by ID in
+// whatever order they come, and the outcome of every call is set in its
+// Err. The returned error is only set when the batch as a whole failed.
+func (j *JSONRPCClient) Batch(ctx context.Context, calls []*JSONRPCCall, opts ...RequestOption) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	requests := make([]jsonRPCRequest, len(calls))
+	pending := map[string]*JSONRPCCall{}
+	for i, call := range calls {
+		requests[i] = jsonRPCRequest{Version: "2.0", Method: call.Method, Params: call.Params}
+		call.Err = nil
+		if !call.Notification {
+			id := atomic.AddUint64(&j.lastID, 1)
+			requests[i].ID = &id
+			pending[strconv.FormatUint(id, 10)] = call
+		}
+	}
+	var body interface{} = requests
+	if len(requests) == 1 {
+		body = requests[0]
+	}
+
+	opts = append([]RequestOption{JSONBody(body), Header("Accept", "application/json")}, opts...)
+	resp, err := j.client.Request(ctx, http.MethodPost, j.path, opts...)
+	if err != nil {
+		return err
+	}
+	responses, err := decodeJSONRPCResponses(resp.Body)
+	if err != nil || (len(responses) == 0 && len(pending) > 0) {
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return unexpectedStatusOf(j.client, http.MethodPost, j.path, resp, nil)
+		}
+		if err == nil {
+			err = errors.New("empty response")
+		}
+		return fmt.Errorf("decoding jsonrpc response: %w", err)
+	}
+	if len(pending) == 0 {
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return unexpectedStatusOf(j.client, http.MethodPost, j.path, resp, nil)
+		}
+		return nil
+	}
+
+	for _, response := range responses {
+		id := strings.Trim(string(bytes.TrimSpace(response.ID)), `"`)
+		call, ok := pending[id]
+		if !ok {
+			// an error without id, such as a parse error, fails the batch
+			if response.Error != nil && (id == "" || id == "null") {
+				return response.Error
+			}
+			continue
+		}
+		delete(pending, id)
+		if response.Error != nil {
+			call.Err = response.Error
+			continue
+		}
+		if call.Result != nil && len(response.Result) > 0 {
+			if err := json.Unmarshal(response.Result, call.Result); err != nil {
+				call.Err = fmt.Errorf("decoding result of %s: %w", call.Method, err)
+			}
+		}
+	}
+	for _, call := range pending {
+		call.Err = ErrJSONRPCNoResponse
+	}
+	return nil
+}
+
+// decodeJSONRPCResponses decodes a single response or a batch of responses,
+// an empty body holds no responses.
+func decodeJSONRPCResponses(body []byte) ([]jsonRPCResponse, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, nil
+	}
+	if body[0] == '[' {
+		var responses []jsonRPCResponse
+		err := json.Unmarshal(body, &responses)
+		return responses, err
+	}
+	var response jsonRPCResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	return []jsonRPCResponse{response}, nil
+}
@@ -0,0 +1,175 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type rpcTestRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  []int           `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// jsonRPCServer answers add and fail calls, batches in reverse order.
+func jsonRPCServer(t *testing.T, received *[]rpcTestRequest) *httptest.Server {
+	var mu sync.Mutex
+	answer := func(request rpcTestRequest) map[string]interface{} {
+		response := map[string]interface{}{"jsonrpc": "2.0", "id": request.ID}
+		switch request.Method {
+		case "add":
+			sum := 0
+			for _, n := range request.Params {
+				sum += n
+			}
+			response["result"] = sum
+		case "fail":
+			response["error"] = map[string]interface{}{"code": -32000, "message": "account locked", "data": map[string]string{"reason": "fraud"}}
+		default:
+			response["error"] = map[string]interface{}{"code": JSONRPCMethodNotFound, "message": "Method not found"}
+		}
+		return response
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`))
+			return
+		}
+		var requests []rpcTestRequest
+		batch := bytes.HasPrefix(body, []byte("["))
+		if batch {
+			require.NoError(t, json.Unmarshal(body, &requests))
+		} else {
+			var request rpcTestRequest
+			require.NoError(t, json.Unmarshal(body, &request))
+			requests = append(requests, request)
+		}
+		mu.Lock()
+		*received = append(*received, requests...)
+		mu.Unlock()
+
+		var responses []map[string]interface{}
+		for i := len(requests) - 1; i >= 0; i-- {
+			if requests[i].ID != nil && requests[i].Method != "ignored" {
+				responses = append(responses, answer(requests[i]))
+			}
+		}
+		switch {
+		case len(responses) == 0:
+			w.WriteHeader(http.StatusNoContent)
+		case batch:
+			_ = json.NewEncoder(w).Encode(responses)
+		default:
+			_ = json.NewEncoder(w).Encode(responses[0])
+		}
+	}))
+}
+
+func TestJSONRPCClient_Call(t *testing.T) {
+	var received []rpcTestRequest
+	ts := jsonRPCServer(t, &received)
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	rpc := NewJSONRPCClient(c, "/rpc")
+
+	var sum int
+	require.NoError(t, rpc.Call(context.Background(), "add", []int{1, 2}, &sum))
+	require.Equal(t, 3, sum)
+	require.Equal(t, "2.0", received[0].Version)
+	require.Equal(t, "1", string(received[0].ID))
+
+	err = rpc.Call(context.Background(), "fail", nil, nil)
+	var rpcErr *JSONRPCError
+	require.True(t, errors.As(err, &rpcErr))
+	require.Equal(t, -32000, rpcErr.Code)
+	require.Equal(t, "jsonrpc error -32000: account locked", err.Error())
+	var data struct{ Reason string }
+	require.NoError(t, rpcErr.DecodeData(&data))
+	require.Equal(t, "fraud", data.Reason)
+
+	err = rpc.Call(context.Background(), "missing", nil, nil)
+	require.True(t, errors.As(err, &rpcErr))
+	require.Equal(t, JSONRPCMethodNotFound, rpcErr.Code)
+
+	require.NoError(t, rpc.Notify(context.Background(), "add", []int{4}))
+	require.Nil(t, received[len(received)-1].ID, "notifications have no id")
+}
+
+func TestJSONRPCClient_Batch(t *testing.T) {
+	var received []rpcTestRequest
+	ts := jsonRPCServer(t, &received)
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	rpc := NewJSONRPCClient(c, "/rpc")
+
+	var first, second int
+	calls := []*JSONRPCCall{
+		{Method: "add", Params: []int{1, 1}, Result: &first},
+		{Method: "log", Params: []int{0}, Notification: true},
+		{Method: "add", Params: []int{2, 3}, Result: &second},
+		{Method: "fail"},
+		{Method: "ignored"},
+	}
+	require.NoError(t, rpc.Batch(context.Background(), calls))
+	require.Len(t, received, 5)
+	require.Equal(t, 2, first, "responses are matched by id")
+	require.Equal(t, 5, second)
+	require.NoError(t, calls[0].Err)
+	require.NoError(t, calls[1].Err)
+	var rpcErr *JSONRPCError
+	require.True(t, errors.As(calls[3].Err, &rpcErr))
+	require.ErrorIs(t, calls[4].Err, ErrJSONRPCNoResponse)
+
+	// a batch of notifications gets no response
+	require.NoError(t, rpc.Batch(context.Background(), []*JSONRPCCall{
+		{Method: "log", Notification: true},
+		{Method: "log", Notification: true},
+	}))
+}
+
+func TestJSONRPCClient_errors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/parse":
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error"},"id":null}`))
+		case "/html":
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte(`<html>bad gateway</html>`))
+		default:
+			_, _ = w.Write([]byte(`not json`))
+		}
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+
+	err = NewJSONRPCClient(c, "/parse").Call(context.Background(), "add", nil, nil)
+	var rpcErr *JSONRPCError
+	require.True(t, errors.As(err, &rpcErr))
+	require.Equal(t, JSONRPCParseError, rpcErr.Code)
+
+	err = NewJSONRPCClient(c, "/html").Call(context.Background(), "add", nil, nil)
+	var unexpected *UnexpectedStatusError
+	require.True(t, errors.As(err, &unexpected))
+	require.Equal(t, http.StatusBadGateway, unexpected.StatusCode)
+	require.Equal(t, http.MethodPost, unexpected.Method)
+	require.Equal(t, ts.URL+"/html", unexpected.URL)
+
+	err = NewJSONRPCClient(c, "/garbage").Call(context.Background(), "add", nil, nil)
+	require.ErrorContains(t, err, "decoding jsonrpc response")
+}
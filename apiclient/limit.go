@@ -0,0 +1,70 @@
+package apiclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// BodyTooLargeError is returned by Do when a response body is larger than
+// the maximum body size of the client or request. When the size is known up
+// front from Content-Length the body is never read and Prefix is empty,
+// otherwise Prefix holds the first Limit bytes if the client has
+// KeepTruncatedBody set.
+type BodyTooLargeError struct {
+	URL           string
+	Limit         int64
+	ContentLength int64
+	Prefix        []byte
+}
+
+func (e *BodyTooLargeError) Error() string {
+	if e.ContentLength > 0 {
+		return fmt.Sprintf("response body from %s is %d bytes, exceeds limit of %d bytes", e.URL, e.ContentLength, e.Limit)
+	}
+	return fmt.Sprintf("response body from %s exceeds limit of %d bytes", e.URL, e.Limit)
+}
+
+// WithMaxBodySize returns a context that overrides the client's MaxBodySize
+// for requests made with it. A limit of zero or less disables the limit for
+// those requests.
+//
+//	ctx = WithMaxBodySize(ctx, 10<<20) // 10MB for this export only
+//	resp, err := client.Get(ctx, "/export", nil)
+func WithMaxBodySize(ctx context.Context, limit int64) context.Context {
+	return context.WithValue(ctx, maxBodySizeKey, limit)
+}
+
+// maxBodySize returns the body size limit for a request, the context value
+// wins over the client setting.
+func (c *Client) maxBodySize(ctx context.Context) int64 {
+	if limit, ok := ctx.Value(maxBodySizeKey).(int64); ok {
+		return limit
+	}
+	return c.MaxBodySize
+}
+
+// readBody reads the whole body while enforcing limit. A limit of zero or
+// less reads without limit.
+func readBody(body io.Reader, limit, contentLength int64, keepPrefix bool) ([]byte, error) {
+	if limit <= 0 {
+		return ioutil.ReadAll(body)
+	}
+	if contentLength > limit {
+		return nil, &BodyTooLargeError{Limit: limit, ContentLength: contentLength}
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		tooLarge := &BodyTooLargeError{Limit: limit}
+		if keepPrefix {
+			tooLarge.Prefix = data[:limit]
+		}
+		return nil, tooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,80 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiClient_Do_MaxBodySize(t *testing.T) {
+	payload := strings.Repeat("a", 100)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("chunked") == "true" {
+			w.(http.Flusher).Flush() // no Content-Length is sent
+		}
+		_, _ = w.Write([]byte(payload))
+	}))
+	defer ts.Close()
+
+	testCases := []struct {
+		name          string
+		clientLimit   int64
+		ctxLimit      *int64
+		chunked       bool
+		keepTruncated bool
+		wantErr       bool
+		wantCL        int64
+		wantPrefix    string
+	}{
+		{name: "no limit"},
+		{name: "within limit", clientLimit: 100},
+		{name: "content-length over limit", clientLimit: 10, wantErr: true, wantCL: 100},
+		{name: "chunked over limit", clientLimit: 10, chunked: true, wantErr: true},
+		{name: "chunked over limit keeps prefix", clientLimit: 10, chunked: true, keepTruncated: true, wantErr: true, wantPrefix: "aaaaaaaaaa"},
+		{name: "request raises limit", clientLimit: 10, ctxLimit: int64Ptr(200)},
+		{name: "request disables limit", clientLimit: 10, ctxLimit: int64Ptr(0)},
+		{name: "request lowers limit", ctxLimit: int64Ptr(50), wantErr: true, wantCL: 100},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := InitClient(newClient(), ts.URL, "test", false, "")
+			require.NoError(t, err)
+			c.MaxBodySize = tc.clientLimit
+			c.KeepTruncatedBody = tc.keepTruncated
+
+			ctx := context.Background()
+			if tc.ctxLimit != nil {
+				ctx = WithMaxBodySize(ctx, *tc.ctxLimit)
+			}
+			target := ts.URL
+			if tc.chunked {
+				target += "?chunked=true"
+			}
+			req, err := http.NewRequest(http.MethodGet, target, nil)
+			require.NoError(t, err)
+
+			resp, err := c.Do(ctx, req)
+			if !tc.wantErr {
+				require.NoError(t, err)
+				require.Equal(t, payload, string(resp.Body))
+				return
+			}
+			var tooLarge *BodyTooLargeError
+			require.True(t, errors.As(err, &tooLarge), "expected BodyTooLargeError, got %v", err)
+			require.Equal(t, tc.wantCL, tooLarge.ContentLength)
+			require.Equal(t, tc.wantPrefix, string(tooLarge.Prefix))
+			require.Equal(t, target, tooLarge.URL)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Nil(t, resp.Body)
+		})
+	}
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
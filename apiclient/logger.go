@@ -0,0 +1,180 @@
+package apiclient
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LogLevel is the severity of a log entry.
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+// LogFields are the structured fields of a log entry.
+type LogFields map[string]interface{}
+
+// Field names used consistently by every log entry of this package.
+const (
+	LogFieldClient    = "client"
+	LogFieldMethod    = "method"
+	LogFieldURL       = "url"
+	LogFieldRoute     = "route"
+	LogFieldStatus    = "status"
+	LogFieldDuration  = "duration"
+	LogFieldAttempt   = "attempt"
+	LogFieldRequestID = "requestId"
+	LogFieldError     = "error"
+	LogFieldBody      = "body"
+)
+
+// Logger receives the log entries of a Client. The fields are already
+// redacted when Log is called.
+type Logger interface {
+	Log(ctx context.Context, level LogLevel, msg string, fields LogFields)
+}
+
+// LevelEnabler may be implemented by a Logger to tell whether it would drop
+// the entries of level, so they are neither built nor redacted in vain. The
+// logrus and slog adapters implement it.
+type LevelEnabler interface {
+	Enabled(ctx context.Context, level LogLevel) bool
+}
+
+// NewLogrusLogger adapts a logrus logger or entry, nil uses the standard
+// logrus logger.
+func NewLogrusLogger(logger log.FieldLogger) Logger {
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+	return &logrusLogger{logger: logger}
+}
+
+type logrusLogger struct {
+	logger log.FieldLogger
+}
+
+// Enabled reports whether the logrus logger writes entries of level, loggers
+// other than a *logrus.Logger or *logrus.Entry are assumed to.
+func (l *logrusLogger) Enabled(ctx context.Context, level LogLevel) bool {
+	var logger *log.Logger
+	switch v := l.logger.(type) {
+	case *log.Logger:
+		logger = v
+	case *log.Entry:
+		logger = v.Logger
+	default:
+		return true
+	}
+	logrusLevel := log.ErrorLevel
+	switch level {
+	case LogLevelDebug:
+		logrusLevel = log.DebugLevel
+	case LogLevelInfo:
+		logrusLevel = log.InfoLevel
+	case LogLevelWarn:
+		logrusLevel = log.WarnLevel
+	}
+	return logger.IsLevelEnabled(logrusLevel)
+}
+
+func (l *logrusLogger) Log(ctx context.Context, level LogLevel, msg string, fields LogFields) {
+	entry := l.logger.WithFields(log.Fields(fields))
+	switch level {
+	case LogLevelDebug:
+		entry.Debug(msg)
+	case LogLevelInfo:
+		entry.Info(msg)
+	case LogLevelWarn:
+		entry.Warn(msg)
+	default:
+		entry.Error(msg)
+	}
+}
+
+// NewSlogLogger adapts a log/slog logger, nil uses slog.Default().
+func NewSlogLogger(logger *slog.Logger) Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &slogLogger{logger: logger}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func slogLevel(level LogLevel) slog.Level {
+	switch level {
+	case LogLevelDebug:
+		return slog.LevelDebug
+	case LogLevelInfo:
+		return slog.LevelInfo
+	case LogLevelWarn:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
+
+// Enabled reports whether the slog logger handles entries of level.
+func (l *slogLogger) Enabled(ctx context.Context, level LogLevel) bool {
+	return l.logger.Enabled(ctx, slogLevel(level))
+}
+
+func (l *slogLogger) Log(ctx context.Context, level LogLevel, msg string, fields LogFields) {
+	slogLevel := slogLevel(level)
+	if !l.logger.Enabled(ctx, slogLevel) {
+		return
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attrs := make([]slog.Attr, len(keys))
+	for i, key := range keys {
+		attrs[i] = slog.Any(key, fields[key])
+	}
+	l.logger.LogAttrs(ctx, slogLevel, msg, attrs...)
+}
+
+var defaultLogger = NewLogrusLogger(nil)
+
+// logAt writes a log entry for the call in ctx with the Logger of its Client,
+// adding the client, route and correlation fields and redacting the fields
+// with the Redactor of the Client. Nothing is built when the Logger is a
+// LevelEnabler dropping level.
+func logAt(ctx context.Context, level LogLevel, msg string, fields LogFields) {
+	logger, redactor := defaultLogger, defaultRedactor
+	c := clientFromContext(ctx)
+	if c != nil && c.Logger != nil {
+		logger = c.Logger
+	}
+	if enabler, ok := logger.(LevelEnabler); ok && !enabler.Enabled(ctx, level) {
+		return
+	}
+	entry := LogFields{}
+	for key, value := range logFields(ctx) {
+		entry[key] = value
+	}
+	if c != nil {
+		if c.Redactor != nil {
+			redactor = c.Redactor
+		}
+		entry[LogFieldClient] = c.name()
+	}
+	if route := RouteTemplate(ctx); route != "" {
+		entry[LogFieldRoute] = route
+	}
+	for key, value := range fields {
+		entry[key] = redactor.field(value)
+	}
+	logger.Log(ctx, level, redactor.message(msg, fields), entry)
+}
@@ -0,0 +1,135 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/CodeNamor/http/middleware"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+type logEntry struct {
+	level  LogLevel
+	msg    string
+	fields LogFields
+}
+
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *recordingLogger) Log(ctx context.Context, level LogLevel, msg string, fields LogFields) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, logEntry{level: level, msg: msg, fields: fields})
+}
+
+func TestClient_Logger_redactsAndAddsFields(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"bad","password":"hunter2"}`))
+	}))
+	defer ts.Close()
+
+	logger := &recordingLogger{}
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	c.Name = "orders"
+	c.Logger = logger
+
+	ctx := WithRouteTemplate(middleware.WithRequestID(context.Background(), "req-1"), "/orders")
+	_, err = c.Get(ctx, "/orders", &url.Values{"api_key": {"secret"}})
+	require.NoError(t, err)
+
+	var completed *logEntry
+	for i := range logger.entries {
+		if logger.entries[i].msg == "APIClient Do(): completed" {
+			completed = &logger.entries[i]
+		}
+		require.NotContains(t, fmt.Sprint(logger.entries[i]), "secret")
+	}
+	require.NotNil(t, completed)
+	require.Equal(t, LogLevelDebug, completed.level)
+	require.Equal(t, "orders", completed.fields[LogFieldClient])
+	require.Equal(t, "/orders", completed.fields[LogFieldRoute])
+	require.Equal(t, "req-1", completed.fields[LogFieldRequestID])
+	require.Equal(t, http.StatusBadRequest, completed.fields[LogFieldStatus])
+	require.Equal(t, ts.URL+"/orders?api_key=%5BREDACTED%5D", completed.fields[LogFieldURL])
+	require.JSONEq(t, `{"error":"bad","password":"[REDACTED]"}`, completed.fields[LogFieldBody].(string))
+	require.Contains(t, completed.fields, LogFieldDuration)
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewSlogLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+	logger.Log(context.Background(), LogLevelDebug, "hidden", nil)
+	logger.Log(context.Background(), LogLevelWarn, "retrying", LogFields{LogFieldAttempt: 2, LogFieldMethod: "GET"})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 1)
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	require.Equal(t, "WARN", entry["level"])
+	require.Equal(t, "retrying", entry["msg"])
+	require.Equal(t, float64(2), entry["attempt"])
+	require.Equal(t, "GET", entry["method"])
+}
+
+// levelledLogger records the entries from min up and counts the entries
+// which reached Log although Enabled said they would be dropped.
+type levelledLogger struct {
+	recordingLogger
+	min     LogLevel
+	dropped int
+}
+
+func (l *levelledLogger) Enabled(ctx context.Context, level LogLevel) bool {
+	return level >= l.min
+}
+
+func (l *levelledLogger) Log(ctx context.Context, level LogLevel, msg string, fields LogFields) {
+	if level < l.min {
+		l.dropped++
+		return
+	}
+	l.recordingLogger.Log(ctx, level, msg, fields)
+}
+
+func TestLevelEnabler(t *testing.T) {
+	logger := &levelledLogger{min: LogLevelWarn}
+	c, err := InitClient(newClient(), "http://example.com", "test", false, "")
+	require.NoError(t, err)
+	c.Logger = logger
+	ctx := withClient(context.Background(), c)
+
+	logAt(ctx, LogLevelDebug, "hidden", LogFields{LogFieldBody: []byte(`{"password":"x"}`)})
+	logAt(ctx, LogLevelError, "failed", LogFields{LogFieldBody: []byte(`{"password":"x"}`)})
+	require.Zero(t, logger.dropped, "disabled entries are not built")
+	require.Len(t, logger.entries, 1)
+	require.Equal(t, "failed", logger.entries[0].msg)
+
+	logrusLogger := log.New()
+	logrusLogger.SetLevel(log.WarnLevel)
+	for _, adapter := range []Logger{
+		NewLogrusLogger(logrusLogger),
+		NewLogrusLogger(logrusLogger.WithField("component", "orders")),
+		NewSlogLogger(slog.New(slog.NewJSONHandler(&bytes.Buffer{}, &slog.HandlerOptions{Level: slog.LevelWarn}))),
+	} {
+		enabler, ok := adapter.(LevelEnabler)
+		require.True(t, ok)
+		require.False(t, enabler.Enabled(context.Background(), LogLevelInfo))
+		require.True(t, enabler.Enabled(context.Background(), LogLevelWarn))
+	}
+}
@@ -6,52 +6,53 @@ package apiclient
 
 import (
 	context "context"
-	gomock "github.com/golang/mock/gomock"
 	io "io"
 	http "net/http"
 	url "net/url"
 	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
 )
 
-// MockAPIClient is a mock of APIClient interface
+// MockAPIClient is a mock of APIClient interface.
 type MockAPIClient struct {
 	ctrl     *gomock.Controller
 	recorder *MockAPIClientMockRecorder
 }
 
-// MockAPIClientMockRecorder is the mock recorder for MockAPIClient
+// MockAPIClientMockRecorder is the mock recorder for MockAPIClient.
 type MockAPIClientMockRecorder struct {
 	mock *MockAPIClient
 }
 
-// NewMockAPIClient creates a new mock instance
+// NewMockAPIClient creates a new mock instance.
 func NewMockAPIClient(ctrl *gomock.Controller) *MockAPIClient {
 	mock := &MockAPIClient{ctrl: ctrl}
 	mock.recorder = &MockAPIClientMockRecorder{mock}
 	return mock
 }
 
-// EXPECT returns an object that allows the caller to indicate expected use
+// EXPECT returns an object that allows the caller to indicate expected use.
 func (m *MockAPIClient) EXPECT() *MockAPIClientMockRecorder {
 	return m.recorder
 }
 
-// Get mocks base method
-func (m *MockAPIClient) Get(ctx context.Context, path string, queryParams *url.Values) (*Response, error) {
+// Delete mocks base method.
+func (m *MockAPIClient) Delete(ctx context.Context, path string, body io.Reader) (*Response, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Get", ctx, path, queryParams)
+	ret := m.ctrl.Call(m, "Delete", ctx, path, body)
 	ret0, _ := ret[0].(*Response)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Get indicates an expected call of Get
-func (mr *MockAPIClientMockRecorder) Get(ctx, path, queryParams interface{}) *gomock.Call {
+// Delete indicates an expected call of Delete.
+func (mr *MockAPIClientMockRecorder) Delete(ctx, path, body interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockAPIClient)(nil).Get), ctx, path, queryParams)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockAPIClient)(nil).Delete), ctx, path, body)
 }
 
-// Do mocks base method
+// Do mocks base method.
 func (m *MockAPIClient) Do(ctx context.Context, request *http.Request) (*Response, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Do", ctx, request)
@@ -60,13 +61,88 @@ func (m *MockAPIClient) Do(ctx context.Context, request *http.Request) (*Respons
 	return ret0, ret1
 }
 
-// Do indicates an expected call of Do
+// Do indicates an expected call of Do.
 func (mr *MockAPIClientMockRecorder) Do(ctx, request interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Do", reflect.TypeOf((*MockAPIClient)(nil).Do), ctx, request)
 }
 
-// Post mocks base method
+// Get mocks base method.
+func (m *MockAPIClient) Get(ctx context.Context, path string, queryParams *url.Values) (*Response, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, path, queryParams)
+	ret0, _ := ret[0].(*Response)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockAPIClientMockRecorder) Get(ctx, path, queryParams interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockAPIClient)(nil).Get), ctx, path, queryParams)
+}
+
+// Head mocks base method.
+func (m *MockAPIClient) Head(ctx context.Context, path string, opts ...RequestOption) (*Response, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, path}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Head", varargs...)
+	ret0, _ := ret[0].(*Response)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Head indicates an expected call of Head.
+func (mr *MockAPIClientMockRecorder) Head(ctx, path interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, path}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Head", reflect.TypeOf((*MockAPIClient)(nil).Head), varargs...)
+}
+
+// Options mocks base method.
+func (m *MockAPIClient) Options(ctx context.Context, path string, opts ...RequestOption) (*Response, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, path}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Options", varargs...)
+	ret0, _ := ret[0].(*Response)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Options indicates an expected call of Options.
+func (mr *MockAPIClientMockRecorder) Options(ctx, path interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, path}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Options", reflect.TypeOf((*MockAPIClient)(nil).Options), varargs...)
+}
+
+// Patch mocks base method.
+func (m *MockAPIClient) Patch(ctx context.Context, path string, opts ...RequestOption) (*Response, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, path}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Patch", varargs...)
+	ret0, _ := ret[0].(*Response)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Patch indicates an expected call of Patch.
+func (mr *MockAPIClientMockRecorder) Patch(ctx, path interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, path}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Patch", reflect.TypeOf((*MockAPIClient)(nil).Patch), varargs...)
+}
+
+// Post mocks base method.
 func (m *MockAPIClient) Post(ctx context.Context, path string, body io.Reader) (*Response, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Post", ctx, path, body)
@@ -75,13 +151,13 @@ func (m *MockAPIClient) Post(ctx context.Context, path string, body io.Reader) (
 	return ret0, ret1
 }
 
-// Post indicates an expected call of Post
+// Post indicates an expected call of Post.
 func (mr *MockAPIClientMockRecorder) Post(ctx, path, body interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Post", reflect.TypeOf((*MockAPIClient)(nil).Post), ctx, path, body)
 }
 
-// PostWithQueryParams mocks base method
+// PostWithQueryParams mocks base method.
 func (m *MockAPIClient) PostWithQueryParams(ctx context.Context, urlPath string, queryParams *url.Values, body io.Reader) (*Response, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "PostWithQueryParams", ctx, urlPath, queryParams, body)
@@ -90,13 +166,28 @@ func (m *MockAPIClient) PostWithQueryParams(ctx context.Context, urlPath string,
 	return ret0, ret1
 }
 
-// PostWithQueryParams indicates an expected call of PostWithQueryParams
+// PostWithQueryParams indicates an expected call of PostWithQueryParams.
 func (mr *MockAPIClientMockRecorder) PostWithQueryParams(ctx, urlPath, queryParams, body interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PostWithQueryParams", reflect.TypeOf((*MockAPIClient)(nil).PostWithQueryParams), ctx, urlPath, queryParams, body)
 }
 
-// Put mocks base method
+// PostXML mocks base method.
+func (m *MockAPIClient) PostXML(ctx context.Context, path string, body io.Reader, soapAction string) (*Response, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PostXML", ctx, path, body, soapAction)
+	ret0, _ := ret[0].(*Response)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PostXML indicates an expected call of PostXML.
+func (mr *MockAPIClientMockRecorder) PostXML(ctx, path, body, soapAction interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PostXML", reflect.TypeOf((*MockAPIClient)(nil).PostXML), ctx, path, body, soapAction)
+}
+
+// Put mocks base method.
 func (m *MockAPIClient) Put(ctx context.Context, path string, body io.Reader) (*Response, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Put", ctx, path, body)
@@ -105,23 +196,28 @@ func (m *MockAPIClient) Put(ctx context.Context, path string, body io.Reader) (*
 	return ret0, ret1
 }
 
-// Put indicates an expected call of Put
+// Put indicates an expected call of Put.
 func (mr *MockAPIClientMockRecorder) Put(ctx, path, body interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Put", reflect.TypeOf((*MockAPIClient)(nil).Put), ctx, path, body)
 }
 
-// Delete mocks base method
-func (m *MockAPIClient) Delete(ctx context.Context, path string, body io.Reader) (*Response, error) {
+// Request mocks base method.
+func (m *MockAPIClient) Request(ctx context.Context, method, path string, opts ...RequestOption) (*Response, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Delete", ctx, path, body)
+	varargs := []interface{}{ctx, method, path}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Request", varargs...)
 	ret0, _ := ret[0].(*Response)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// Delete indicates an expected call of Delete
-func (mr *MockAPIClientMockRecorder) Delete(ctx, path, body interface{}) *gomock.Call {
+// Request indicates an expected call of Request.
+func (mr *MockAPIClientMockRecorder) Request(ctx, method, path interface{}, opts ...interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockAPIClient)(nil).Delete), ctx, path, body)
+	varargs := append([]interface{}{ctx, method, path}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Request", reflect.TypeOf((*MockAPIClient)(nil).Request), varargs...)
 }
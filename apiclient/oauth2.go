@@ -0,0 +1,286 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token is an OAuth2 access token.
+type Token struct {
+	AccessToken  string
+	TokenType    string
+	RefreshToken string
+	// Expiry is when the token expires, zero means it never does.
+	Expiry time.Time
+}
+
+// expiresWithin reports whether the token expires within d from now.
+func (t *Token) expiresWithin(d time.Duration) bool {
+	return !t.Expiry.IsZero() && time.Now().Add(d).After(t.Expiry)
+}
+
+// authorization returns the value of the Authorization header for the token.
+func (t *Token) authorization() string {
+	tokenType := t.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+	return tokenType + " " + t.AccessToken
+}
+
+// TokenSource supplies the access token Client sends with each request.
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+// TokenInvalidator is implemented by token sources which cache tokens.
+// Client calls Invalidate with a token the upstream rejected with 401
+// Unauthorized before asking for a new one.
+type TokenInvalidator interface {
+	Invalidate(token *Token)
+}
+
+// TokenError is returned when the token endpoint rejects a token request.
+type TokenError struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *TokenError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("oauth2 token request failed with status %d: %s: %s", e.StatusCode, e.Code, e.Description)
+	}
+	return fmt.Sprintf("oauth2 token request failed with status %d: %s", e.StatusCode, e.Code)
+}
+
+// AuthStyle is how the client id and secret are sent to the token endpoint.
+type AuthStyle int
+
+const (
+	// AuthStyleHeader sends them with HTTP Basic authentication.
+	AuthStyleHeader AuthStyle = iota
+	// AuthStyleParams sends them as client_id and client_secret form values.
+	AuthStyleParams
+)
+
+// OAuth2Config holds the token endpoint settings shared by the OAuth2 token
+// sources.
+type OAuth2Config struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// EndpointParams are additional form values sent to the token endpoint,
+	// for example an audience.
+	EndpointParams url.Values
+	AuthStyle      AuthStyle
+	// RefreshAhead refreshes tokens this long before they expire, defaults
+	// to one minute.
+	RefreshAhead time.Duration
+	// RefreshBackoff is how long refreshing ahead of expiry is not attempted
+	// again after it failed, defaults to 10 seconds. An expired token is
+	// always refreshed.
+	RefreshBackoff time.Duration
+	// HTTPClient sends the token requests, defaults to a client with a 30
+	// second timeout.
+	HTTPClient RetryClient
+}
+
+// OAuth2TokenSource is a TokenSource which caches its token and refreshes it
+// ahead of expiry. Concurrent callers share a single in-flight refresh. Create
+// it with NewClientCredentialsTokenSource or NewRefreshTokenSource.
+type OAuth2TokenSource struct {
+	config OAuth2Config
+	grant  func() url.Values
+
+	mu       sync.Mutex
+	token    *Token
+	refresh  *tokenRefresh
+	rotating string    // current refresh token of the refresh token grant
+	failedAt time.Time // when the last refresh failed
+}
+
+type tokenRefresh struct {
+	done  chan struct{}
+	token *Token
+	err   error
+}
+
+// NewClientCredentialsTokenSource creates a token source for the OAuth2
+// client credentials grant.
+//
+//	client.TokenSource = NewClientCredentialsTokenSource(OAuth2Config{
+//	  TokenURL:     "https://auth.example.com/oauth2/token",
+//	  ClientID:     clientID,
+//	  ClientSecret: clientSecret,
+//	  Scopes:       []string{"orders:read"},
+//	})
+func NewClientCredentialsTokenSource(config OAuth2Config) *OAuth2TokenSource {
+	s := newOAuth2TokenSource(config)
+	s.grant = func() url.Values {
+		return url.Values{"grant_type": {"client_credentials"}}
+	}
+	return s
+}
+
+// NewRefreshTokenSource creates a token source for the OAuth2 refresh token
+// grant starting from refreshToken. When the token endpoint rotates the
+// refresh token the new one is used for the following refreshes.
+func NewRefreshTokenSource(config OAuth2Config, refreshToken string) *OAuth2TokenSource {
+	s := newOAuth2TokenSource(config)
+	s.rotating = refreshToken
+	s.grant = func() url.Values {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return url.Values{"grant_type": {"refresh_token"}, "refresh_token": {s.rotating}}
+	}
+	return s
+}
+
+func newOAuth2TokenSource(config OAuth2Config) *OAuth2TokenSource {
+	if config.RefreshAhead <= 0 {
+		config.RefreshAhead = time.Minute
+	}
+	if config.RefreshBackoff <= 0 {
+		config.RefreshBackoff = 10 * time.Second
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &OAuth2TokenSource{config: config}
+}
+
+// Token returns the cached token, or fetches a new one when there is none or
+// it is about to expire. If refreshing fails while the cached token is still
+// valid the cached token is returned, and is not refreshed again ahead of
+// expiry for RefreshBackoff.
+func (s *OAuth2TokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mu.Lock()
+	current := s.token
+	if current != nil && !current.expiresWithin(0) &&
+		(!current.expiresWithin(s.config.RefreshAhead) || time.Since(s.failedAt) < s.config.RefreshBackoff) {
+		s.mu.Unlock()
+		return current, nil
+	}
+	refresh := s.refresh
+	if refresh == nil {
+		refresh = &tokenRefresh{done: make(chan struct{})}
+		s.refresh = refresh
+		// the refresh is shared, so one caller giving up must not cancel it
+		go s.fetch(detachedContext{parent: ctx}, refresh)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-refresh.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if refresh.err != nil {
+		if current != nil && !current.expiresWithin(0) {
+			logAt(ctx, LogLevelWarn, "refreshing oauth2 token ahead of expiry failed, using current token", LogFields{LogFieldError: refresh.err})
+			return current, nil
+		}
+		return nil, refresh.err
+	}
+	return refresh.token, nil
+}
+
+// Invalidate drops token from the cache if it is still the cached one, so the
+// next call to Token fetches a new token.
+func (s *OAuth2TokenSource) Invalidate(token *Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == token {
+		s.token = nil
+	}
+}
+
+func (s *OAuth2TokenSource) fetch(ctx context.Context, refresh *tokenRefresh) {
+	refresh.token, refresh.err = s.requestToken(ctx)
+
+	s.mu.Lock()
+	if refresh.err != nil {
+		s.failedAt = time.Now()
+	} else {
+		s.failedAt = time.Time{}
+		s.token = refresh.token
+		if refresh.token.RefreshToken != "" && s.rotating != "" {
+			s.rotating = refresh.token.RefreshToken
+		}
+	}
+	s.refresh = nil
+	s.mu.Unlock()
+	close(refresh.done)
+}
+
+func (s *OAuth2TokenSource) requestToken(ctx context.Context) (*Token, error) {
+	form := s.grant()
+	if len(s.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.config.Scopes, " "))
+	}
+	for key, values := range s.config.EndpointParams {
+		form[key] = append([]string{}, values...)
+	}
+	if s.config.AuthStyle == AuthStyleParams {
+		form.Set("client_id", s.config.ClientID)
+		form.Set("client_secret", s.config.ClientSecret)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if s.config.AuthStyle == AuthStyleHeader {
+		request.SetBasicAuth(url.QueryEscape(s.config.ClientID), url.QueryEscape(s.config.ClientSecret))
+	}
+
+	response, err := s.config.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		tokenErr := &TokenError{StatusCode: response.StatusCode}
+		_ = json.Unmarshal(body, tokenErr)
+		return nil, tokenErr
+	}
+
+	var payload struct {
+		AccessToken  string      `json:"access_token"`
+		TokenType    string      `json:"token_type"`
+		RefreshToken string      `json:"refresh_token"`
+		ExpiresIn    json.Number `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("decoding oauth2 token response: %w", err)
+	}
+	if payload.AccessToken == "" {
+		return nil, &TokenError{StatusCode: response.StatusCode, Code: "invalid_response", Description: "no access_token in response"}
+	}
+
+	token := &Token{
+		AccessToken:  payload.AccessToken,
+		TokenType:    payload.TokenType,
+		RefreshToken: payload.RefreshToken,
+	}
+	if seconds, err := payload.ExpiresIn.Int64(); err == nil && seconds > 0 {
+		token.Expiry = time.Now().Add(time.Duration(seconds) * time.Second)
+	}
+	return token, nil
+}
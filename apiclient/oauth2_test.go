@@ -0,0 +1,220 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTokenServer starts a token endpoint which issues token-1, token-2, ...
+// valid for expiresIn seconds.
+func newTokenServer(t *testing.T, expiresIn int, check func(r *http.Request)) (*httptest.Server, *int32) {
+	var issued int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.NoError(t, r.ParseForm())
+		if check != nil {
+			check(r)
+		}
+		n := atomic.AddInt32(&issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":%d,"refresh_token":"refresh-%d"}`, n, expiresIn, n)
+	}))
+	return ts, &issued
+}
+
+func TestClientCredentialsTokenSource(t *testing.T) {
+	tokenServer, issued := newTokenServer(t, 3600, func(r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		require.True(t, ok)
+		require.Equal(t, "my-client", user)
+		require.Equal(t, "s3cret", pass)
+		require.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		require.Equal(t, "orders:read orders:write", r.PostForm.Get("scope"))
+		require.Equal(t, "api://orders", r.PostForm.Get("audience"))
+	})
+	defer tokenServer.Close()
+
+	var seen []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, r.Header.Get("Authorization"))
+	}))
+	defer api.Close()
+
+	c, err := InitClient(newClient(), api.URL, "test", false, "")
+	require.NoError(t, err)
+	c.TokenSource = NewClientCredentialsTokenSource(OAuth2Config{
+		TokenURL:       tokenServer.URL,
+		ClientID:       "my-client",
+		ClientSecret:   "s3cret",
+		Scopes:         []string{"orders:read", "orders:write"},
+		EndpointParams: map[string][]string{"audience": {"api://orders"}},
+	})
+
+	for i := 0; i < 3; i++ {
+		_, err = c.Get(context.Background(), "/orders", nil)
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{"Bearer token-1", "Bearer token-1", "Bearer token-1"}, seen)
+	require.Equal(t, int32(1), atomic.LoadInt32(issued))
+}
+
+func TestOAuth2TokenSource_refreshAheadOfExpiry(t *testing.T) {
+	tokenServer, issued := newTokenServer(t, 30, nil)
+	defer tokenServer.Close()
+
+	source := NewClientCredentialsTokenSource(OAuth2Config{TokenURL: tokenServer.URL, RefreshAhead: time.Minute, RefreshBackoff: 50 * time.Millisecond})
+	ctx := context.Background()
+	first, err := source.Token(ctx)
+	require.NoError(t, err)
+	second, err := source.Token(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "token-1", first.AccessToken)
+	require.Equal(t, "token-2", second.AccessToken, "a token expiring within RefreshAhead is refreshed")
+
+	// when the early refresh fails the still valid token is used
+	var failed int32
+	tokenServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failed, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	for i := 0; i < 3; i++ {
+		third, err := source.Token(ctx)
+		require.NoError(t, err)
+		require.Equal(t, "token-2", third.AccessToken)
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(issued))
+	require.Equal(t, int32(1), atomic.LoadInt32(&failed), "early refreshes back off after a failure")
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = source.Token(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&failed), "and are attempted again after RefreshBackoff")
+
+	// an expired token is refreshed right away
+	source.mu.Lock()
+	source.token.Expiry = time.Now().Add(-time.Second)
+	source.mu.Unlock()
+	_, err = source.Token(ctx)
+	var tokenErr *TokenError
+	require.True(t, errors.As(err, &tokenErr))
+	require.Equal(t, int32(3), atomic.LoadInt32(&failed))
+}
+
+func TestOAuth2TokenSource_sharesInFlightRefresh(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		fmt.Fprint(w, `{"access_token":"shared","expires_in":3600}`)
+	}))
+	defer tokenServer.Close()
+
+	source := NewClientCredentialsTokenSource(OAuth2Config{TokenURL: tokenServer.URL})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := source.Token(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, "shared", token.AccessToken)
+		}()
+	}
+
+	// a caller giving up does not fail the shared refresh
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := source.Token(ctx)
+	require.Equal(t, context.Canceled, err)
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestApiClient_TokenSource_retriesOnceOn401(t *testing.T) {
+	tokenServer, issued := newTokenServer(t, 3600, nil)
+	defer tokenServer.Close()
+
+	var calls int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer api.Close()
+
+	c, err := InitClient(newClient(), api.URL, "test", false, "")
+	require.NoError(t, err)
+	c.TokenSource = NewClientCredentialsTokenSource(OAuth2Config{TokenURL: tokenServer.URL})
+
+	resp, err := c.Get(context.Background(), "/", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "ok", string(resp.Body))
+	require.Equal(t, int32(2), atomic.LoadInt32(issued))
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// a token that keeps being rejected is only retried once
+	api.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	resp, err = c.Get(context.Background(), "/", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+func TestRefreshTokenSource_rotatesRefreshToken(t *testing.T) {
+	var refreshTokens []string
+	tokenServer, _ := newTokenServer(t, 0, func(r *http.Request) {
+		require.Equal(t, "refresh_token", r.PostForm.Get("grant_type"))
+		require.Equal(t, "my-client", r.PostForm.Get("client_id"))
+		refreshTokens = append(refreshTokens, r.PostForm.Get("refresh_token"))
+	})
+	defer tokenServer.Close()
+
+	source := NewRefreshTokenSource(OAuth2Config{
+		TokenURL:  tokenServer.URL,
+		ClientID:  "my-client",
+		AuthStyle: AuthStyleParams,
+	}, "initial")
+	token, err := source.Token(context.Background())
+	require.NoError(t, err)
+	require.True(t, token.Expiry.IsZero())
+
+	source.Invalidate(token)
+	_, err = source.Token(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"initial", "refresh-1"}, refreshTokens)
+}
+
+func TestOAuth2TokenSource_tokenError(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":"invalid_client","error_description":"unknown client"}`)
+	}))
+	defer tokenServer.Close()
+
+	_, err := NewClientCredentialsTokenSource(OAuth2Config{TokenURL: tokenServer.URL}).Token(context.Background())
+	var tokenErr *TokenError
+	require.True(t, errors.As(err, &tokenErr))
+	require.Equal(t, http.StatusBadRequest, tokenErr.StatusCode)
+	require.Equal(t, "invalid_client", tokenErr.Code)
+	require.Equal(t, "unknown client", tokenErr.Description)
+}
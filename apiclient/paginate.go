@@ -0,0 +1,462 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Page is one page of a paginated listing.
+type Page struct {
+	// Number is the position of the page, starting at 1.
+	Number   int
+	URL      *url.URL
+	Response *Response
+	// Items are the raw JSON items of the page found at the ItemsPath of the
+	// PagerConfig.
+	Items []json.RawMessage
+}
+
+// PageStrategy finds the next page of a listing.
+type PageStrategy interface {
+	// Next returns the URL of the page following page, or nil when page is
+	// the last one.
+	Next(page *Page) (*url.URL, error)
+}
+
+// PageStrategyFunc adapts a function to a PageStrategy.
+type PageStrategyFunc func(page *Page) (*url.URL, error)
+
+// Next calls f(page).
+func (f PageStrategyFunc) Next(page *Page) (*url.URL, error) {
+	return f(page)
+}
+
+// LinkPagination follows the rel="next" link of the RFC 8288 Link header,
+// the listing ends with the first page without one.
+func LinkPagination() PageStrategy {
+	return PageStrategyFunc(func(page *Page) (*url.URL, error) {
+		next := nextLink(page.Response.Header.Values("Link"))
+		if next == "" {
+			return nil, nil
+		}
+		u, err := page.URL.Parse(next)
+		if err != nil {
+			return nil, fmt.Errorf("parsing next link %q: %w", next, err)
+		}
+		return u, nil
+	})
+}
+
+// nextLink returns the target of the first link with the next relation.
+func nextLink(values []string) string {
+	for _, value := range values {
+		for {
+			start := strings.IndexByte(value, '<')
+			end := strings.IndexByte(value, '>')
+			if start < 0 || end < start {
+				break
+			}
+			target := value[start+1 : end]
+			value = value[end+1:]
+			params := value
+			if next := strings.IndexByte(value, '<'); next >= 0 {
+				params = value[:next]
+			}
+			if hasRelation(params, "next") {
+				return target
+			}
+		}
+	}
+	return ""
+}
+
+// hasRelation reports whether the link parameters in params have a rel
+// parameter listing relation.
+func hasRelation(params, relation string) bool {
+	for _, param := range strings.Split(params, ";") {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "rel") {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(strings.TrimRight(strings.TrimSpace(value), ",")), `"`)
+		for _, candidate := range strings.Fields(value) {
+			if strings.EqualFold(candidate, relation) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CursorPagination reads the cursor of the next page from the JSON body at
+// Path, for example "meta.next_cursor", and sends it in the Param query
+// parameter. The listing ends when the cursor is missing, null or empty.
+type CursorPagination struct {
+	Path  string
+	Param string
+}
+
+// Next implements PageStrategy.
+func (p CursorPagination) Next(page *Page) (*url.URL, error) {
+	raw, err := jsonPath(page.Response.Body, p.Path)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := jsonScalar(raw)
+	if err != nil {
+		return nil, fmt.Errorf("reading cursor at %q: %w", p.Path, err)
+	}
+	if cursor == "" {
+		return nil, nil
+	}
+	return withQuery(page.URL, p.Param, cursor), nil
+}
+
+// OffsetPagination pages with an offset and a limit query parameter, such as
+// ?offset=200&limit=100. The limit is sent with every page, the first one
+// included. The listing ends with the first page holding fewer than Limit
+// items, or no items when Limit is zero.
+type OffsetPagination struct {
+	// OffsetParam defaults to "offset".
+	OffsetParam string
+	// LimitParam defaults to "limit", it is only sent when Limit is set.
+	LimitParam string
+	Limit      int
+}
+
+// Next implements PageStrategy.
+func (p OffsetPagination) Next(page *Page) (*url.URL, error) {
+	if len(page.Items) == 0 || len(page.Items) < p.Limit {
+		return nil, nil
+	}
+	param := defaultString(p.OffsetParam, "offset")
+	offset, err := queryInt(page.URL, param, 0)
+	if err != nil {
+		return nil, err
+	}
+	return p.firstURL(withQuery(page.URL, param, strconv.Itoa(offset+len(page.Items)))), nil
+}
+
+// firstURL sets the limit, so the server never uses a smaller default one
+// which would end the listing after the first page.
+func (p OffsetPagination) firstURL(u *url.URL) *url.URL {
+	if p.Limit > 0 {
+		return withQuery(u, defaultString(p.LimitParam, "limit"), strconv.Itoa(p.Limit))
+	}
+	return u
+}
+
+// PageNumberPagination pages with a page number query parameter, such as
+// ?page=3&per_page=100. The size is sent with every page, the first one
+// included. The listing ends with the first page holding fewer than Size
+// items, or no items when Size is zero.
+type PageNumberPagination struct {
+	// PageParam defaults to "page".
+	PageParam string
+	// SizeParam defaults to "per_page", it is only sent when Size is set.
+	SizeParam string
+	Size      int
+	// First is the number of the first page, defaults to 1.
+	First int
+}
+
+// Next implements PageStrategy.
+func (p PageNumberPagination) Next(page *Page) (*url.URL, error) {
+	if len(page.Items) == 0 || len(page.Items) < p.Size {
+		return nil, nil
+	}
+	first := p.First
+	if first == 0 {
+		first = 1
+	}
+	param := defaultString(p.PageParam, "page")
+	number, err := queryInt(page.URL, param, first)
+	if err != nil {
+		return nil, err
+	}
+	return p.firstURL(withQuery(page.URL, param, strconv.Itoa(number+1))), nil
+}
+
+// firstURL sets the page size, so the server never uses a smaller default
+// one which would end the listing after the first page.
+func (p PageNumberPagination) firstURL(u *url.URL) *url.URL {
+	if p.Size > 0 {
+		return withQuery(u, defaultString(p.SizeParam, "per_page"), strconv.Itoa(p.Size))
+	}
+	return u
+}
+
+// firstPager is implemented by the strategies which add a query parameter,
+// such as the page size, to the first page too.
+type firstPager interface {
+	firstURL(u *url.URL) *url.URL
+}
+
+// PagerConfig configures Client.Paginate.
+type PagerConfig struct {
+	// Strategy finds the next page, defaults to LinkPagination().
+	Strategy PageStrategy
+	// ItemsPath is the dot separated path of the items array in the JSON
+	// body, for example "data.items". Empty means the body is the array.
+	ItemsPath string
+	// MaxPages and MaxItems cap the listing, zero means no cap.
+	MaxPages int
+	MaxItems int
+	// Prefetch fetches the next page in the background while the items of
+	// the current one are decoded.
+	Prefetch bool
+}
+
+// Pager is a pull-style iterator over the items of a paginated listing, see
+// Client.Paginate. A Pager is not safe for concurrent use.
+type Pager struct {
+	client  *Client
+	ctx     context.Context
+	cancel  context.CancelFunc
+	config  PagerConfig
+	options *requestOptions
+	first   *http.Request
+
+	page    *Page
+	index   int
+	items   int
+	nextURL *url.URL
+	pending chan pageResult
+	fetched int
+	err     error
+}
+
+type pageResult struct {
+	page *Page
+	err  error
+}
+
+// Paginate returns a Pager over the items of the listing at urlPath. Pages
+// are fetched with GET and opts, which apply to every page, on demand as
+// the items are decoded. Any status other than 2xx ends the listing with an
+// *UnexpectedStatusError unless ExpectStatus says otherwise.
+//
+//	pager := client.Paginate(ctx, "/orders", PagerConfig{
+//	  Strategy:  CursorPagination{Path: "meta.next", Param: "cursor"},
+//	  ItemsPath: "data",
+//	}, QueryParam("status", "open"))
+//	defer pager.Close()
+//	for {
+//	  var order Order
+//	  err := pager.Decode(&order)
+//	  if err == io.EOF {
+//	    break
+//	  }
+//	  if err != nil {
+//	    return err
+//	  }
+//	  process(order)
+//	}
+func (c *Client) Paginate(ctx context.Context, urlPath string, config PagerConfig, opts ...RequestOption) *Pager {
+	if config.Strategy == nil {
+		config.Strategy = LinkPagination()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p := &Pager{client: c, ctx: ctx, cancel: cancel, config: config, options: newRequestOptions(opts)}
+	p.first, p.err = c.newRequest(http.MethodGet, urlPath, p.options)
+	if p.err != nil {
+		c.log(ctx, LogLevelError, "failed to create paginated GET request", LogFields{LogFieldError: p.err})
+		return p
+	}
+	p.nextURL = p.first.URL
+	if strategy, ok := config.Strategy.(firstPager); ok {
+		p.nextURL = strategy.firstURL(p.nextURL)
+	}
+	return p
+}
+
+// Decode decodes the next item of the listing into v, fetching the next page
+// when the current one is exhausted. It returns io.EOF once the listing or
+// its cap is exhausted, any other error is sticky and returned from every
+// following call. A done context ends the listing with its error.
+func (p *Pager) Decode(v interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	if err := p.ctx.Err(); err != nil {
+		return p.fail(err)
+	}
+	if p.config.MaxItems > 0 && p.items >= p.config.MaxItems {
+		return p.fail(io.EOF)
+	}
+	for p.page == nil || p.index >= len(p.page.Items) {
+		if err := p.advance(); err != nil {
+			return err
+		}
+	}
+	item := p.page.Items[p.index]
+	p.index++
+	p.items++
+	if err := json.Unmarshal(item, v); err != nil {
+		return p.fail(fmt.Errorf("decoding item %d of page %d: %w", p.index, p.page.Number, err))
+	}
+	return nil
+}
+
+// Page returns the page of the last decoded item, nil before the first one.
+func (p *Pager) Page() *Page {
+	return p.page
+}
+
+// Close stops the Pager and any prefetch in flight. It is safe to call more
+// than once.
+func (p *Pager) Close() {
+	p.cancel()
+	if p.err == nil {
+		p.err = errPagerClosed
+	}
+}
+
+var errPagerClosed = errors.New("apiclient: decode on closed pager")
+
+// advance moves to the next page, it returns io.EOF when there is none.
+func (p *Pager) advance() error {
+	var result pageResult
+	switch {
+	case p.pending != nil:
+		result = <-p.pending
+		p.pending = nil
+	case p.nextURL != nil:
+		p.fetched++
+		result = p.fetch(p.nextURL, p.fetched)
+	default:
+		return p.fail(io.EOF)
+	}
+	if result.err != nil {
+		return p.fail(result.err)
+	}
+	p.page, p.index = result.page, 0
+	p.nextURL = nil
+	if p.config.MaxPages > 0 && p.fetched >= p.config.MaxPages {
+		return nil
+	}
+	next, err := p.config.Strategy.Next(p.page)
+	if err != nil {
+		return p.fail(err)
+	}
+	if next == nil || next.String() == p.page.URL.String() {
+		return nil // a next page equal to this one would never end
+	}
+	p.nextURL = next
+	if p.config.Prefetch && (p.config.MaxItems == 0 || p.items+len(p.page.Items) < p.config.MaxItems) {
+		p.fetched++
+		pending, number := make(chan pageResult, 1), p.fetched
+		p.pending = pending
+		go func() {
+			pending <- p.fetch(next, number)
+		}()
+	}
+	return nil
+}
+
+// fetch gets page number at u. It only reads fields which do not change
+// after Paginate, so it can run in the background while items are decoded.
+func (p *Pager) fetch(u *url.URL, number int) pageResult {
+	request := p.first.Clone(p.ctx)
+	request.URL = u
+	request.Host = ""
+	resp, err := p.client.execute(p.ctx, request, p.options)
+	if err == nil && len(p.options.expected) == 0 && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		err = p.client.unexpectedStatus(request, resp, nil)
+	}
+	if err != nil {
+		return pageResult{err: err}
+	}
+	page := &Page{Number: number, URL: u, Response: resp}
+	raw, err := jsonPath(resp.Body, p.config.ItemsPath)
+	if err == nil && len(raw) > 0 && !bytes.Equal(raw, []byte("null")) {
+		err = json.Unmarshal(raw, &page.Items)
+	}
+	if err != nil {
+		return pageResult{err: fmt.Errorf("reading items of page %d: %w", number, err)}
+	}
+	return pageResult{page: page}
+}
+
+func (p *Pager) fail(err error) error {
+	p.err = err
+	p.cancel()
+	return err
+}
+
+// jsonPath returns the raw JSON value at the dot separated path of body, nil
+// when a field of the path is missing.
+func jsonPath(body []byte, path string) (json.RawMessage, error) {
+	raw := json.RawMessage(body)
+	if path == "" {
+		return raw, nil
+	}
+	for _, field := range strings.Split(path, ".") {
+		if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+			return nil, nil
+		}
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return nil, fmt.Errorf("reading %q of %q: %w", field, path, err)
+		}
+		raw = object[field]
+	}
+	return raw, nil
+}
+
+// jsonScalar returns a JSON string or number as a string, and null or a
+// missing value as an empty string.
+func jsonScalar(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return "", nil
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("expected a string or number, got %s", raw)
+}
+
+// withQuery returns a copy of u with the query parameter name set to value.
+func withQuery(u *url.URL, name, value string) *url.URL {
+	next := *u
+	query := next.Query()
+	query.Set(name, value)
+	next.RawQuery = query.Encode()
+	return &next
+}
+
+func queryInt(u *url.URL, name string, fallback int) (int, error) {
+	value := u.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("reading %s query parameter: %w", name, err)
+	}
+	return number, nil
+}
+
+func defaultString(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
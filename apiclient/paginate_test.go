@@ -0,0 +1,188 @@
+package apiclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// decodeAll decodes the ints of pager until it ends.
+func decodeAll(pager *Pager) ([]int, error) {
+	var items []int
+	for {
+		var item int
+		err := pager.Decode(&item)
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return items, err
+		}
+		items = append(items, item)
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	require.Equal(t, "/p3", nextLink([]string{`</p1>; rel="first", </p3>; rel="next last"`}))
+	require.Equal(t, "https://x/p2?a=1,2", nextLink([]string{`<https://x/p2?a=1,2>; title="a, b"; REL=next`}))
+	require.Equal(t, "/p2", nextLink([]string{`</p0>; rel=prev`, `</p2>; rel=next`}))
+	require.Equal(t, "", nextLink([]string{`</p0>; rel="prev"`}))
+}
+
+func TestClient_Paginate_link(t *testing.T) {
+	var requests int32
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		require.Equal(t, "open", r.URL.Query().Get("status"))
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 2 {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/items?status=open&page=%d>; rel="next"`, ts.URL, page+1))
+		}
+		_, _ = fmt.Fprintf(w, `[%d,%d]`, page*2, page*2+1)
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+
+	for _, prefetch := range []bool{false, true} {
+		atomic.StoreInt32(&requests, 0)
+		pager := c.Paginate(context.Background(), "/items", PagerConfig{Prefetch: prefetch}, QueryParam("status", "open"))
+		items, err := decodeAll(pager)
+		require.NoError(t, err)
+		require.Equal(t, []int{0, 1, 2, 3, 4, 5}, items)
+		require.Equal(t, 3, pager.Page().Number)
+		require.Equal(t, io.EOF, pager.Decode(new(int)))
+		pager.Close()
+		require.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	}
+
+	// caps stop the listing early, also with prefetching
+	pager := c.Paginate(context.Background(), "/items", PagerConfig{MaxItems: 3, Prefetch: true}, QueryParam("status", "open"))
+	items, err := decodeAll(pager)
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 1, 2}, items)
+
+	pager = c.Paginate(context.Background(), "/items", PagerConfig{MaxPages: 2}, QueryParam("status", "open"))
+	items, err = decodeAll(pager)
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 1, 2, 3}, items)
+}
+
+func TestClient_Paginate_cursorOffsetAndPageNumber(t *testing.T) {
+	// listing returns up to limit of the items 0 to 4 from offset
+	listing := func(offset, limit int) []int {
+		items := []int{}
+		for i := offset; i < offset+limit && i < 5; i++ {
+			items = append(items, i)
+		}
+		return items
+	}
+	var mu sync.Mutex
+	var pages []int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch r.URL.Path {
+		case "/cursor":
+			next := map[string]string{"": `"b"`, "b": `"c"`, "c": `null`}[query.Get("cursor")]
+			_, _ = fmt.Fprintf(w, `{"data":{"items":[%d]},"meta":{"next":%s}}`, len(query.Get("cursor")), next)
+		case "/offset":
+			offset, _ := strconv.Atoi(query.Get("offset"))
+			// like most servers, a missing limit means a small default one
+			limit, err := queryInt(r.URL, "limit", 1)
+			require.NoError(t, err)
+			_ = json.NewEncoder(w).Encode(map[string][]int{"results": listing(offset, limit)})
+		case "/pages":
+			page, err := queryInt(r.URL, "page", 1)
+			require.NoError(t, err)
+			size, err := queryInt(r.URL, "per_page", 1)
+			require.NoError(t, err)
+			mu.Lock()
+			pages = append(pages, page)
+			mu.Unlock()
+			_ = json.NewEncoder(w).Encode(listing((page-1)*size, size))
+		}
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+
+	items, err := decodeAll(c.Paginate(context.Background(), "/cursor", PagerConfig{
+		Strategy:  CursorPagination{Path: "meta.next", Param: "cursor"},
+		ItemsPath: "data.items",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 1, 1}, items)
+
+	items, err = decodeAll(c.Paginate(context.Background(), "/offset", PagerConfig{
+		Strategy:  OffsetPagination{Limit: 2},
+		ItemsPath: "results",
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 1, 2, 3, 4}, items)
+
+	items, err = decodeAll(c.Paginate(context.Background(), "/pages", PagerConfig{
+		Strategy: PageNumberPagination{Size: 2},
+		Prefetch: true,
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 1, 2, 3, 4}, items)
+	require.Equal(t, []int{1, 2, 3}, pages)
+
+	// without a size the listing ends with the first empty page
+	pages = nil
+	items, err = decodeAll(c.Paginate(context.Background(), "/pages", PagerConfig{
+		Strategy: PageNumberPagination{},
+	}))
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 1, 2, 3, 4}, items)
+	require.Equal(t, []int{1, 2, 3, 4, 5, 6}, pages)
+}
+
+func TestClient_Paginate_errors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`[1]`))
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+
+	pager := c.Paginate(context.Background(), "/", PagerConfig{Strategy: PageNumberPagination{}})
+	items, err := decodeAll(pager)
+	var unexpected *UnexpectedStatusError
+	require.True(t, errors.As(err, &unexpected))
+	require.Equal(t, http.StatusBadRequest, unexpected.StatusCode)
+	require.Equal(t, []int{1}, items)
+	require.Equal(t, err, pager.Decode(new(int)), "errors are sticky")
+
+	// items of the wrong type stop the listing
+	pager = c.Paginate(context.Background(), "/", PagerConfig{Strategy: PageNumberPagination{}})
+	require.Error(t, pager.Decode(new(string)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pager = c.Paginate(ctx, "/", PagerConfig{Strategy: PageNumberPagination{}, Prefetch: true})
+	require.NoError(t, pager.Decode(new(int)))
+	cancel()
+	require.True(t, errors.Is(pager.Decode(new(int)), context.Canceled))
+
+	pager = c.Paginate(context.Background(), "/", PagerConfig{Strategy: PageNumberPagination{}})
+	pager.Close()
+	require.Error(t, pager.Decode(new(int)))
+}
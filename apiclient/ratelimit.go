@@ -0,0 +1,290 @@
+package apiclient
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit is the configuration of a token bucket. Rate is the number of
+// requests per second and Burst the number of requests that can be made at
+// once after the bucket filled up. A Rate of zero or less does not limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimiterConfig configures NewRateLimiter.
+type RateLimiterConfig struct {
+	// Client is the limit shared by all requests of the client.
+	Client RateLimit
+	// Routes holds additional limits per route template, see
+	// WithRouteTemplate. A request has to get a token from both the client
+	// and its route bucket.
+	Routes map[string]RateLimit
+	// Wait makes requests wait for a token within their context deadline.
+	// Without it a request that can not get a token right away fails.
+	Wait bool
+	// Adaptive lowers the limits from the X-RateLimit-Remaining,
+	// X-RateLimit-Reset and Retry-After response headers of the upstream.
+	Adaptive bool
+}
+
+// RateLimitError is returned when a request could not get a token in time.
+// Wait is how long the request would have had to wait.
+type RateLimitError struct {
+	Route string
+	Wait  time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.Route != "" {
+		return fmt.Sprintf("client rate limit exceeded for route %s, next token in %v", e.Route, e.Wait)
+	}
+	return fmt.Sprintf("client rate limit exceeded, next token in %v", e.Wait)
+}
+
+// RateLimiter is a client side token bucket rate limiter with a bucket for
+// the whole client and optional buckets per route template. Set it on
+// Client.RateLimiter, every call made through Do takes a token before the
+// request is sent.
+//
+//	client.RateLimiter = NewRateLimiter(RateLimiterConfig{
+//	  Client: RateLimit{Rate: 50, Burst: 10},
+//	  Routes: map[string]RateLimit{"/search": {Rate: 5, Burst: 1}},
+//	  Wait:   true,
+//	})
+type RateLimiter struct {
+	config RateLimiterConfig
+	client *tokenBucket
+
+	mu     sync.Mutex
+	routes map[string]*tokenBucket
+}
+
+// NewRateLimiter creates a RateLimiter from config.
+func NewRateLimiter(config RateLimiterConfig) *RateLimiter {
+	l := &RateLimiter{
+		config: config,
+		client: newTokenBucket(config.Client),
+		routes: map[string]*tokenBucket{},
+	}
+	for route, limit := range config.Routes {
+		l.routes[route] = newTokenBucket(limit)
+	}
+	return l
+}
+
+// Wait takes a token for a request to route, route may be empty. It blocks
+// until a token is available if the limiter is configured to wait, or fails
+// with a *RateLimitError if the token is not available in time.
+func (l *RateLimiter) Wait(ctx context.Context, route string) error {
+	var maxWait time.Duration
+	if l.config.Wait {
+		maxWait = time.Duration(math.MaxInt64)
+		if deadline, ok := ctx.Deadline(); ok {
+			maxWait = time.Until(deadline)
+		}
+	}
+
+	buckets := []*tokenBucket{l.client}
+	if bucket := l.route(route); bucket != nil {
+		buckets = append(buckets, bucket)
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	var reserved []*tokenBucket
+	for _, bucket := range buckets {
+		delay, ok := bucket.reserve(now, maxWait)
+		if !ok {
+			for _, r := range reserved {
+				r.cancel()
+			}
+			return &RateLimitError{Route: route, Wait: delay}
+		}
+		reserved = append(reserved, bucket)
+		if delay > wait {
+			wait = delay
+		}
+	}
+	if wait <= 0 {
+		return nil
+	}
+
+	logAt(ctx, LogLevelDebug, "rate limiter delaying request", LogFields{LogFieldRoute: route, "wait": wait})
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		for _, r := range reserved {
+			r.cancel()
+		}
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Observe adapts the limiter to the rate limit headers of resp, it does
+// nothing unless the limiter is Adaptive. The route bucket is adapted if the
+// route has one, otherwise the client bucket.
+func (l *RateLimiter) Observe(route string, resp *http.Response) {
+	if !l.config.Adaptive || resp == nil {
+		return
+	}
+	bucket := l.route(route)
+	if bucket == nil {
+		bucket = l.client
+	}
+
+	now := time.Now()
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if retryAfter, ok := parseRetryAfter(resp); ok {
+			bucket.block(now.Add(retryAfter))
+			return
+		}
+	}
+
+	remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, hasReset := parseRateLimitReset(resp.Header.Get("X-RateLimit-Reset"), now)
+	if remaining <= 0 {
+		if !hasReset {
+			reset = now.Add(time.Second)
+		}
+		bucket.block(reset)
+		return
+	}
+	if hasReset && reset.After(now) {
+		bucket.throttle(float64(remaining)/reset.Sub(now).Seconds(), reset)
+	}
+}
+
+func (l *RateLimiter) route(route string) *tokenBucket {
+	if route == "" {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.routes[route]
+}
+
+// parseRateLimitReset reads X-RateLimit-Reset, which upstreams send either
+// as seconds until the reset or as a unix timestamp.
+func parseRateLimitReset(value string, now time.Time) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 {
+		return time.Time{}, false
+	}
+	if seconds > 1e9 { // unix timestamp
+		return time.Unix(0, int64(seconds*float64(time.Second))), true
+	}
+	return now.Add(time.Duration(seconds * float64(time.Second))), true
+}
+
+// tokenBucket hands out reservations for tokens. Tokens go negative while
+// reservations are outstanding so waiting requests are served in order.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	// set by the adaptive mode
+	blockedUntil   time.Time
+	throttledRate  float64
+	throttledUntil time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   limit.Rate,
+		burst:  burst,
+		tokens: burst,
+	}
+}
+
+// reserve takes a token and returns how long the caller has to wait for it.
+// If that is longer than maxWait nothing is taken and false is returned.
+func (b *tokenBucket) reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var wait time.Duration
+	if b.blockedUntil.After(now) {
+		wait = b.blockedUntil.Sub(now)
+	}
+
+	rate := b.currentRate(now)
+	if rate > 0 {
+		if !b.last.IsZero() {
+			b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+		}
+		b.last = now
+		if b.tokens < 1 {
+			tokenWait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+			if tokenWait > wait {
+				wait = tokenWait
+			}
+		}
+	}
+
+	if wait > maxWait {
+		return wait, false
+	}
+	if rate > 0 {
+		b.tokens--
+	}
+	return wait, true
+}
+
+// cancel returns the token of a reservation that was not used.
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate > 0 || b.throttledRate > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+1)
+	}
+}
+
+func (b *tokenBucket) currentRate(now time.Time) float64 {
+	if b.throttledRate > 0 && now.Before(b.throttledUntil) {
+		if b.rate <= 0 || b.throttledRate < b.rate {
+			return b.throttledRate
+		}
+	}
+	return b.rate
+}
+
+func (b *tokenBucket) block(until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if until.After(b.blockedUntil) {
+		b.blockedUntil = until
+	}
+}
+
+func (b *tokenBucket) throttle(rate float64, until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.throttledRate = rate
+	b.throttledUntil = until
+	if b.rate <= 0 && b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
@@ -0,0 +1,128 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter_failFast(t *testing.T) {
+	limiter := NewRateLimiter(RateLimiterConfig{Client: RateLimit{Rate: 1, Burst: 2}})
+	ctx := context.Background()
+	require.NoError(t, limiter.Wait(ctx, ""))
+	require.NoError(t, limiter.Wait(ctx, ""))
+
+	err := limiter.Wait(ctx, "")
+	var rateLimited *RateLimitError
+	require.True(t, errors.As(err, &rateLimited))
+	require.InDelta(t, float64(time.Second), float64(rateLimited.Wait), float64(50*time.Millisecond))
+}
+
+func TestRateLimiter_wait(t *testing.T) {
+	limiter := NewRateLimiter(RateLimiterConfig{Client: RateLimit{Rate: 20, Burst: 1}, Wait: true})
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, limiter.Wait(ctx, ""))
+	}
+	// first token is free, the next four are 50ms apart
+	require.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+
+	// a token that is not available within the deadline fails right away
+	slow := NewRateLimiter(RateLimiterConfig{Client: RateLimit{Rate: 0.1, Burst: 1}, Wait: true})
+	require.NoError(t, slow.Wait(ctx, ""))
+	deadlineCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	err := slow.Wait(deadlineCtx, "")
+	var rateLimited *RateLimitError
+	require.True(t, errors.As(err, &rateLimited))
+	require.Less(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestRateLimiter_routes(t *testing.T) {
+	limiter := NewRateLimiter(RateLimiterConfig{
+		Client: RateLimit{Rate: 100, Burst: 100},
+		Routes: map[string]RateLimit{"/search": {Rate: 1, Burst: 1}},
+	})
+	ctx := context.Background()
+	require.NoError(t, limiter.Wait(ctx, "/search"))
+	err := limiter.Wait(ctx, "/search")
+	var rateLimited *RateLimitError
+	require.True(t, errors.As(err, &rateLimited))
+	require.Equal(t, "/search", rateLimited.Route)
+
+	// other routes only use the client bucket
+	require.NoError(t, limiter.Wait(ctx, "/products/{id}"))
+	require.NoError(t, limiter.Wait(ctx, ""))
+}
+
+func TestRateLimiter_adaptive(t *testing.T) {
+	t.Run("Retry-After blocks", func(t *testing.T) {
+		limiter := NewRateLimiter(RateLimiterConfig{Adaptive: true})
+		resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"30"}}}
+		limiter.Observe("", resp)
+		err := limiter.Wait(context.Background(), "")
+		var rateLimited *RateLimitError
+		require.True(t, errors.As(err, &rateLimited))
+		require.InDelta(t, float64(30*time.Second), float64(rateLimited.Wait), float64(time.Second))
+	})
+
+	t.Run("exhausted quota blocks until reset", func(t *testing.T) {
+		limiter := NewRateLimiter(RateLimiterConfig{Client: RateLimit{Rate: 100, Burst: 10}, Adaptive: true})
+		reset := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+		resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{
+			"X-Ratelimit-Remaining": []string{"0"},
+			"X-Ratelimit-Reset":     []string{reset},
+		}}
+		limiter.Observe("", resp)
+		err := limiter.Wait(context.Background(), "")
+		var rateLimited *RateLimitError
+		require.True(t, errors.As(err, &rateLimited))
+	})
+
+	t.Run("remaining quota lowers the rate", func(t *testing.T) {
+		limiter := NewRateLimiter(RateLimiterConfig{Adaptive: true})
+		resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{
+			"X-Ratelimit-Remaining": []string{"1"},
+			"X-Ratelimit-Reset":     []string{"10"},
+		}}
+		limiter.Observe("", resp)
+		require.NoError(t, limiter.Wait(context.Background(), ""))
+		err := limiter.Wait(context.Background(), "")
+		var rateLimited *RateLimitError
+		require.True(t, errors.As(err, &rateLimited), "expected 0.1 rps after adapting, got %v", err)
+	})
+
+	t.Run("not adaptive ignores headers", func(t *testing.T) {
+		limiter := NewRateLimiter(RateLimiterConfig{})
+		limiter.Observe("", &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"30"}}})
+		require.NoError(t, limiter.Wait(context.Background(), ""))
+	})
+}
+
+func TestApiClient_RateLimiter(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	c.RateLimiter = NewRateLimiter(RateLimiterConfig{Routes: map[string]RateLimit{"/items/{id}": {Rate: 1, Burst: 1}}})
+
+	ctx := WithRouteTemplate(context.Background(), "/items/{id}")
+	_, err = c.Get(ctx, "/items/1", nil)
+	require.NoError(t, err)
+	_, err = c.Get(ctx, "/items/2", nil)
+	var rateLimited *RateLimitError
+	require.True(t, errors.As(err, &rateLimited))
+	require.Equal(t, 1, calls, "a rate limited request is never sent")
+}
@@ -0,0 +1,193 @@
+package apiclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Redactor removes secrets from what a Client logs: header values, query
+// parameters and fields of JSON bodies. Names are matched case-insensitively.
+type Redactor struct {
+	Headers     []string
+	QueryParams []string
+	JSONFields  []string
+	// Replacement replaces the redacted values, defaults to [REDACTED].
+	Replacement string
+}
+
+// DefaultRedactor returns a Redactor for the usual credential headers,
+// query parameters and JSON fields. Append to its lists to redact more.
+func DefaultRedactor() *Redactor {
+	return &Redactor{
+		Headers: []string{
+			"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie",
+			"X-Api-Key", "X-Auth-Token",
+		},
+		QueryParams: []string{
+			"api_key", "apikey", "key", "token", "access_token", "refresh_token",
+			"client_secret", "password", "signature", "sig",
+		},
+		JSONFields: []string{
+			"password", "secret", "token", "access_token", "refresh_token",
+			"id_token", "client_secret", "api_key", "apikey",
+		},
+	}
+}
+
+var defaultRedactor = DefaultRedactor()
+
+// maxLoggedBody is the number of body bytes included in log entries.
+const maxLoggedBody = 1024
+
+func (r *Redactor) replacement() string {
+	if r.Replacement == "" {
+		return "[REDACTED]"
+	}
+	return r.Replacement
+}
+
+func matches(names []string, name string) bool {
+	for _, candidate := range names {
+		if strings.EqualFold(candidate, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// URL returns u as a string with the password and the sensitive query
+// parameters redacted.
+func (r *Redactor) URL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	redacted := *u
+	if redacted.User != nil {
+		if _, hasPassword := redacted.User.Password(); hasPassword {
+			redacted.User = url.UserPassword(redacted.User.Username(), r.replacement())
+		}
+	}
+	if redacted.RawQuery != "" {
+		query := redacted.Query()
+		changed := false
+		for name, values := range query {
+			if matches(r.QueryParams, name) {
+				for i := range values {
+					values[i] = r.replacement()
+				}
+				changed = true
+			}
+		}
+		if changed {
+			redacted.RawQuery = query.Encode()
+		}
+	}
+	return redacted.String()
+}
+
+// Header returns a copy of header with the values of sensitive headers
+// redacted.
+func (r *Redactor) Header(header http.Header) http.Header {
+	redacted := header.Clone()
+	for name, values := range redacted {
+		if matches(r.Headers, name) {
+			replaced := make([]string, len(values))
+			for i := range replaced {
+				replaced[i] = r.replacement()
+			}
+			redacted[name] = replaced
+		}
+	}
+	return redacted
+}
+
+// JSON returns body with the values of sensitive fields redacted at any
+// depth. Bodies that are not JSON are returned unchanged.
+func (r *Redactor) JSON(body []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return body
+	}
+	redacted, err := json.Marshal(r.jsonValue(value))
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+func (r *Redactor) jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if matches(r.JSONFields, key) {
+				v[key] = r.replacement()
+			} else {
+				v[key] = r.jsonValue(field)
+			}
+		}
+	case []interface{}:
+		for i, element := range v {
+			v[i] = r.jsonValue(element)
+		}
+	}
+	return value
+}
+
+// field redacts a log field value according to its type.
+func (r *Redactor) field(value interface{}) interface{} {
+	switch v := value.(type) {
+	case *url.URL:
+		return r.URL(v)
+	case http.Header:
+		return r.Header(v)
+	case []byte:
+		// redact before truncating so cut JSON can not leak secrets
+		body := r.JSON(v)
+		if len(body) > maxLoggedBody {
+			return string(body[:maxLoggedBody]) + "..."
+		}
+		return string(body)
+	case error:
+		return r.text(v.Error())
+	case string:
+		return r.text(v)
+	}
+	return value
+}
+
+// message redacts the URLs of fields which appear in msg.
+func (r *Redactor) message(msg string, fields LogFields) string {
+	for _, value := range fields {
+		if u, ok := value.(*url.URL); ok && u != nil {
+			msg = strings.ReplaceAll(msg, u.String(), r.URL(u))
+		}
+	}
+	return r.text(msg)
+}
+
+// text redacts the passwords and sensitive query parameters of any URL in s,
+// such as the one net/http puts in its errors.
+func (r *Redactor) text(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	words := strings.FieldsFunc(s, func(c rune) bool { return c == ' ' || c == '"' })
+	for _, word := range words {
+		if !strings.Contains(word, "://") || !strings.ContainsAny(word, "?@") {
+			continue
+		}
+		u, err := url.Parse(strings.TrimRight(word, ":,"))
+		if err != nil {
+			continue
+		}
+		if redacted := r.URL(u); redacted != u.String() {
+			s = strings.ReplaceAll(s, u.String(), redacted)
+		}
+	}
+	return s
+}
@@ -0,0 +1,296 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// RequestOption configures a single request made with Client.Request and the
+// method helpers built on it.
+type RequestOption func(*requestOptions)
+
+type requestOptions struct {
+	query       url.Values
+	header      http.Header
+	timeout     time.Duration
+	retry       *RetryConfig
+	expected    []int
+	body        io.Reader
+	value       interface{}
+	encoder     BodyEncoder
+	contentType string
+}
+
+// BodyEncoder encodes a request body value and returns the encoded body and
+// its Content-Type.
+type BodyEncoder func(v interface{}) (body []byte, contentType string, err error)
+
+// JSONEncoder encodes request bodies as JSON.
+func JSONEncoder(v interface{}) ([]byte, string, error) {
+	body, err := json.Marshal(v)
+	return body, "application/json", err
+}
+
+// XMLEncoder encodes request bodies as XML.
+func XMLEncoder(v interface{}) ([]byte, string, error) {
+	body, err := xml.Marshal(v)
+	return body, "application/xml", err
+}
+
+// FormEncoder encodes url.Values and map[string]string request bodies as
+// application/x-www-form-urlencoded.
+func FormEncoder(v interface{}) ([]byte, string, error) {
+	var values url.Values
+	switch form := v.(type) {
+	case url.Values:
+		values = form
+	case map[string]string:
+		values = url.Values{}
+		for name, value := range form {
+			values.Set(name, value)
+		}
+	default:
+		return nil, "", fmt.Errorf("form encoder can not encode %T", v)
+	}
+	return []byte(values.Encode()), "application/x-www-form-urlencoded", nil
+}
+
+// UnexpectedStatusError is returned with the response when a request made
+// with ExpectStatus receives a status that is not expected. An empty
+// Expected means any 2xx status was expected.
+type UnexpectedStatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Expected   []int
+}
+
+func (e *UnexpectedStatusError) Error() string {
+	if len(e.Expected) == 0 {
+		return fmt.Sprintf("%s %s returned status %d, expected a 2xx status", e.Method, e.URL, e.StatusCode)
+	}
+	return fmt.Sprintf("%s %s returned status %d, expected %v", e.Method, e.URL, e.StatusCode, e.Expected)
+}
+
+// Query adds query parameters to the request, on top of those of BaseURL.
+func Query(values url.Values) RequestOption {
+	return func(o *requestOptions) {
+		for name, value := range values {
+			o.query[name] = append(o.query[name], value...)
+		}
+	}
+}
+
+// QueryParam adds a single query parameter to the request.
+func QueryParam(name, value string) RequestOption {
+	return func(o *requestOptions) {
+		o.query.Add(name, value)
+	}
+}
+
+// Header sets a request header, it overrides the headers set by the client
+// such as Content-Type.
+func Header(name, value string) RequestOption {
+	return func(o *requestOptions) {
+		o.header.Set(name, value)
+	}
+}
+
+// Headers sets the request headers in header.
+func Headers(header http.Header) RequestOption {
+	return func(o *requestOptions) {
+		for name, values := range header {
+			o.header[http.CanonicalHeaderKey(name)] = append([]string{}, values...)
+		}
+	}
+}
+
+// Timeout bounds the whole call, including retries and reading the body.
+func Timeout(timeout time.Duration) RequestOption {
+	return func(o *requestOptions) {
+		o.timeout = timeout
+	}
+}
+
+// Retry overrides the retry settings of the HTTPClient for the request, see
+// WithRetryConfig.
+func Retry(config RetryConfig) RequestOption {
+	return func(o *requestOptions) {
+		o.retry = &config
+	}
+}
+
+// NoRetry makes sure the request is attempted only once.
+func NoRetry() RequestOption {
+	return Retry(RetryConfig{MaxAttempts: 1})
+}
+
+// ExpectStatus makes the request fail with an *UnexpectedStatusError when
+// the response status is not one of statuses. The response is returned with
+// the error.
+func ExpectStatus(statuses ...int) RequestOption {
+	return func(o *requestOptions) {
+		o.expected = append(o.expected, statuses...)
+	}
+}
+
+// Body sends v encoded with encoder as the request body. The encoded body
+// can be rewound, so the request stays retryable.
+func Body(v interface{}, encoder BodyEncoder) RequestOption {
+	return func(o *requestOptions) {
+		o.value, o.encoder, o.body = v, encoder, nil
+	}
+}
+
+// JSONBody sends v encoded as JSON.
+func JSONBody(v interface{}) RequestOption {
+	return Body(v, JSONEncoder)
+}
+
+// BodyReader sends body as is with the given Content-Type. Only
+// *bytes.Buffer, *bytes.Reader and *strings.Reader bodies can be rewound for
+// retries.
+func BodyReader(body io.Reader, contentType string) RequestOption {
+	return func(o *requestOptions) {
+		o.body, o.contentType, o.value, o.encoder = body, contentType, nil, nil
+	}
+}
+
+// Request builds a request for method and urlPath relative to BaseURL,
+// applies opts and executes it with Do.
+//
+//	resp, err := client.Request(ctx, http.MethodPatch, "/orders/"+id,
+//	  JSONBody(patch),
+//	  Header("If-Match", etag),
+//	  Timeout(5*time.Second),
+//	  ExpectStatus(http.StatusOK, http.StatusNoContent),
+//	)
+func (c *Client) Request(ctx context.Context, method, urlPath string, opts ...RequestOption) (*Response, error) {
+	options := newRequestOptions(opts)
+	request, err := c.newRequest(method, urlPath, options)
+	if err != nil {
+		c.log(ctx, LogLevelError, "failed to create "+method+" request", LogFields{LogFieldError: err})
+		return nil, err
+	}
+	return c.execute(ctx, request, options)
+}
+
+func newRequestOptions(opts []RequestOption) *requestOptions {
+	options := &requestOptions{query: url.Values{}, header: http.Header{}}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// execute calls Do with the retry, timeout and expected status options.
+func (c *Client) execute(ctx context.Context, request *http.Request, options *requestOptions) (*Response, error) {
+	if options.retry != nil {
+		ctx = WithRetryConfig(ctx, *options.retry)
+	}
+	if options.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.timeout)
+		defer cancel()
+	}
+	resp, err := c.Do(ctx, request)
+	if err == nil && len(options.expected) > 0 && !expectedStatus(options.expected, resp.StatusCode) {
+		err = c.unexpectedStatus(request, resp, options.expected)
+	}
+	return resp, err
+}
+
+func (c *Client) unexpectedStatus(request *http.Request, resp *Response, expected []int) error {
+	return &UnexpectedStatusError{
+		Method:     request.Method,
+		URL:        c.redactor().URL(request.URL),
+		StatusCode: resp.StatusCode,
+		Expected:   expected,
+	}
+}
+
+// unexpectedStatusOf returns the UnexpectedStatusError of resp for helpers
+// which only hold an APIClient. It is built like Client.unexpectedStatus from
+// the request that was sent, falling back to method and urlPath when resp
+// does not carry it.
+func unexpectedStatusOf(client APIClient, method, urlPath string, resp *Response, expected []int) error {
+	request := resp.OriginalRequest
+	if request == nil {
+		request = &http.Request{Method: method, URL: &url.URL{Path: urlPath}}
+	}
+	redactor := defaultRedactor
+	if c, ok := client.(*Client); ok {
+		redactor = c.redactor()
+	}
+	return &UnexpectedStatusError{
+		Method:     request.Method,
+		URL:        redactor.URL(request.URL),
+		StatusCode: resp.StatusCode,
+		Expected:   expected,
+	}
+}
+
+// newRequest builds the request described by options.
+func (c *Client) newRequest(method, urlPath string, options *requestOptions) (*http.Request, error) {
+	u := *c.BaseURL
+	u.Path = path.Join(u.Path, urlPath)
+	if len(options.query) > 0 {
+		q := c.BaseURL.Query()
+		for key, value := range options.query {
+			q[key] = append([]string{}, value...)
+		}
+		u.RawQuery = q.Encode()
+	}
+	body, contentType := options.body, options.contentType
+	if options.encoder != nil {
+		encoded, encodedType, err := options.encoder(options.value)
+		if err != nil {
+			return nil, err
+		}
+		body, contentType = bytes.NewReader(encoded), encodedType
+	}
+	request, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	request.Header.Set("User-Agent", c.UserAgent)
+	for name, values := range options.header {
+		request.Header[name] = values
+	}
+	return request, nil
+}
+
+func expectedStatus(expected []int, status int) bool {
+	for _, code := range expected {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Patch creates a PATCH request with opts and calls Do.
+func (c *Client) Patch(ctx context.Context, urlPath string, opts ...RequestOption) (*Response, error) {
+	return c.Request(ctx, http.MethodPatch, urlPath, opts...)
+}
+
+// Head creates a HEAD request with opts and calls Do.
+func (c *Client) Head(ctx context.Context, urlPath string, opts ...RequestOption) (*Response, error) {
+	return c.Request(ctx, http.MethodHead, urlPath, opts...)
+}
+
+// Options creates an OPTIONS request with opts and calls Do.
+func (c *Client) Options(ctx context.Context, urlPath string, opts ...RequestOption) (*Response, error) {
+	return c.Request(ctx, http.MethodOptions, urlPath, opts...)
+}
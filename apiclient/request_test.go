@@ -0,0 +1,117 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Request_options(t *testing.T) {
+	var got *http.Request
+	var gotBody string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ := ioutil.ReadAll(r.Body)
+		gotBody = string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL+"/v2?tenant=a", "test", false, "")
+	require.NoError(t, err)
+
+	resp, err := c.Patch(context.Background(), "/orders/1",
+		JSONBody(map[string]string{"state": "shipped"}),
+		Query(url.Values{"expand": {"lines", "totals"}}),
+		QueryParam("dry_run", "true"),
+		Header("If-Match", `"v1"`),
+		Headers(http.Header{"x-tenant": {"a"}}),
+		ExpectStatus(http.StatusOK, http.StatusNoContent),
+	)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.Equal(t, http.MethodPatch, got.Method)
+	require.Equal(t, "/v2/orders/1", got.URL.Path)
+	require.Equal(t, "dry_run=true&expand=lines&expand=totals&tenant=a", got.URL.RawQuery)
+	require.Equal(t, `{"state":"shipped"}`, gotBody)
+	require.Equal(t, "application/json", got.Header.Get("Content-Type"))
+	require.Equal(t, `"v1"`, got.Header.Get("If-Match"))
+	require.Equal(t, "a", got.Header.Get("X-Tenant"))
+	require.Equal(t, "test", got.Header.Get("User-Agent"))
+	require.NotEmpty(t, got.Header.Get(IdempotencyKeyHeader))
+
+	_, err = c.Request(context.Background(), http.MethodPost, "/login",
+		Body(url.Values{"user": {"a"}}, FormEncoder),
+		Header("Content-Type", "application/x-www-form-urlencoded; charset=utf-8"),
+	)
+	require.NoError(t, err)
+	require.Equal(t, "user=a", gotBody)
+	require.Equal(t, "application/x-www-form-urlencoded; charset=utf-8", got.Header.Get("Content-Type"))
+
+	_, err = c.Head(context.Background(), "/orders/1")
+	require.NoError(t, err)
+	require.Equal(t, http.MethodHead, got.Method)
+
+	_, err = c.Options(context.Background(), "/orders")
+	require.NoError(t, err)
+	require.Equal(t, http.MethodOptions, got.Method)
+}
+
+func TestClient_Request_expectStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		_, _ = w.Write([]byte(`{"error":"conflict"}`))
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+
+	resp, err := c.Request(context.Background(), http.MethodPut, "/orders/1", ExpectStatus(http.StatusOK))
+	var unexpected *UnexpectedStatusError
+	require.True(t, errors.As(err, &unexpected))
+	require.Equal(t, http.StatusConflict, unexpected.StatusCode)
+	require.Equal(t, `{"error":"conflict"}`, string(resp.Body))
+
+	_, err = c.Request(context.Background(), http.MethodPost, "/orders", Body(make(chan int), JSONEncoder))
+	require.Error(t, err)
+}
+
+func TestClient_Request_retryAndTimeout(t *testing.T) {
+	var attempts int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	retry := NewRetryClient(&http.Client{}, RetryConfig{MaxAttempts: 3, Backoff: ConstantBackoff(0)})
+	c, err := InitClient(retry, ts.URL, "test", false, "")
+	require.NoError(t, err)
+
+	_, err = c.Get(context.Background(), "/", nil)
+	require.NoError(t, err)
+	require.Equal(t, int32(3), atomic.SwapInt32(&attempts, 0))
+
+	_, err = c.Request(context.Background(), http.MethodGet, "/", NoRetry())
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.SwapInt32(&attempts, 0))
+
+	_, err = c.Request(context.Background(), http.MethodGet, "/", Retry(RetryConfig{MaxAttempts: 2}))
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.SwapInt32(&attempts, 0))
+
+	_, err = c.Request(context.Background(), http.MethodGet, "/slow", Timeout(50*time.Millisecond))
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+}
@@ -1,11 +1,14 @@
 package apiclient
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
-
-	"github.com/pkg/errors"
-	"github.com/sethgrid/pester"
+	"strconv"
+	"time"
 )
 
 // RetryClient is an interface for http.RetryClient
@@ -13,50 +16,275 @@ type RetryClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
+// RetryPolicy decides whether an attempt should be retried. It is called
+// with the original request and the response and error of the attempt.
+type RetryPolicy func(req *http.Request, resp *http.Response, err error) bool
+
+// RetryConfig configures the retry engine returned by NewRetryClient.
+type RetryConfig struct {
+	// MaxAttempts is the total number of attempts including the first one,
+	// values below 1 mean a single attempt.
+	MaxAttempts int
+	// Backoff computes the delay between attempts, defaults to
+	// DecorrelatedJitterBackoff(250ms, 10s).
+	Backoff BackoffStrategy
+	// Policy decides which attempts are retried, defaults to DefaultRetryPolicy.
+	Policy RetryPolicy
+	// MaxRetryAfter caps how long a Retry-After header can make the client
+	// wait. A longer Retry-After ends the retries and returns the response.
+	// Zero means only the context deadline limits it.
+	MaxRetryAfter time.Duration
+}
+
+const (
+	defaultBackoffBase = 250 * time.Millisecond
+	defaultBackoffMax  = 10 * time.Second
+
+	// maxDrainBytes is how much of a discarded response body is read so the
+	// connection can be reused for the next attempt.
+	maxDrainBytes = 64 << 10
+)
+
 // NewExtendedHTTPClient wraps an existing *http.RetryClient with retry logic
 // The maximum number of attempts is configured in Config.MaxRetries.
-// The retry logic uses an exponential backoff with jitter strategy.
-// Retry attempts are logged to the warning level of the default logrus logger
+// The retry logic uses a decorrelated jitter backoff strategy and the
+// DefaultRetryPolicy.
+// Retry attempts are logged at the warning level with the Logger of the
+// Client making the call, or the default logrus logger outside of a Client.
 func NewExtendedHTTPClient(maxRetries int, hc *http.Client) RetryClient {
-	rc := pester.NewExtendedClient(hc)
-	rc.MaxRetries = maxRetries
-	rc.Backoff = pester.ExponentialJitterBackoff
-	rc.KeepLog = false // must be false so LogHook can be used
-	rc.LogHook = createLogHook(maxRetries)
+	return NewRetryClient(hc, RetryConfig{MaxAttempts: maxRetries})
+}
 
-	InstrumentedClient := &InstrumentedHttpClient{
-		client: rc,
+// NewRetryClient wraps client with the retry engine configured by config.
+// Every attempt is instrumented, so connection metrics are recorded per
+// attempt. Retries honor the Retry-After header, rewind the request body
+// with GetBody and never sleep past the deadline of the request context.
+// Requests with a body that can not be rewound are only attempted once.
+//
+//	retryClient := NewRetryClient(&http.Client{Timeout: 30 * time.Second}, RetryConfig{
+//	  MaxAttempts: 3,
+//	  Backoff:     ExponentialBackoff(100*time.Millisecond, 2*time.Second),
+//	})
+func NewRetryClient(client RetryClient, config RetryConfig) RetryClient {
+	if config.MaxAttempts < 1 {
+		config.MaxAttempts = 1
+	}
+	if config.Backoff == nil {
+		config.Backoff = DecorrelatedJitterBackoff(defaultBackoffBase, defaultBackoffMax)
+	}
+	if config.Policy == nil {
+		config.Policy = DefaultRetryPolicy
+	}
+	return &retryClient{
+		client: &InstrumentedHttpClient{client: client},
+		config: config,
+	}
+}
+
+// DefaultRetryPolicy retries transport errors and the 429 Too Many Requests,
+// 502 Bad Gateway, 503 Service Unavailable and 504 Gateway Timeout statuses
+// of idempotent requests, see IsIdempotent.
+// Errors caused by the request context being done and calls rejected by an
+// open circuit breaker or a concurrency limiter are never retried, retrying
+// them would only add load.
+func DefaultRetryPolicy(req *http.Request, resp *http.Response, err error) bool {
+	if !IsIdempotent(req) {
+		return false
+	}
+	if err != nil {
+		var circuitOpen *CircuitOpenError
+		var limited *ConcurrencyLimitError
+		if errors.As(err, &circuitOpen) || errors.As(err, &limited) {
+			return false
+		}
+		return req.Context().Err() == nil && !errors.Is(err, context.Canceled)
+	}
+	if resp == nil {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// WithRetryConfig returns a context that overrides the retry settings of the
+// retry clients for requests made with it. Zero fields keep the setting of
+// the retry client, so RetryConfig{MaxAttempts: 1} only disables retries.
+func WithRetryConfig(ctx context.Context, config RetryConfig) context.Context {
+	return context.WithValue(ctx, retryConfigKey, config)
+}
+
+// configFor returns the retry settings for req, merging the override set with
+// WithRetryConfig into the config of rc.
+func (rc *retryClient) configFor(req *http.Request) RetryConfig {
+	config := rc.config
+	override, ok := req.Context().Value(retryConfigKey).(RetryConfig)
+	if !ok {
+		return config
+	}
+	if override.MaxAttempts > 0 {
+		config.MaxAttempts = override.MaxAttempts
+	}
+	if override.Backoff != nil {
+		config.Backoff = override.Backoff
+	}
+	if override.Policy != nil {
+		config.Policy = override.Policy
+	}
+	if override.MaxRetryAfter > 0 {
+		config.MaxRetryAfter = override.MaxRetryAfter
+	}
+	return config
+}
+
+type retryClient struct {
+	client RetryClient
+	config RetryConfig
+}
+
+func (rc *retryClient) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	config := rc.configFor(req)
+	attemptReq := req
+	var previous time.Duration
+
+	for attempt := 1; ; attempt++ {
+		resp, err := rc.client.Do(attemptReq)
+		if attempt >= config.MaxAttempts || !config.Policy(req, resp, err) {
+			return resp, err
+		}
+		if !canRewind(req) {
+			logAt(ctx, LogLevelDebug, fmt.Sprintf("attempt:%d not retrying: request body can not be rewound", attempt), LogFields{
+				LogFieldAttempt: attempt,
+				LogFieldMethod:  req.Method,
+				LogFieldURL:     req.URL,
+			})
+			return resp, err
+		}
+
+		delay := config.Backoff(attempt, previous)
+		if retryAfter, ok := parseRetryAfter(resp); ok {
+			if config.MaxRetryAfter > 0 && retryAfter > config.MaxRetryAfter {
+				return resp, err
+			}
+			delay = retryAfter
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return resp, err // the next attempt could not finish in time
+		}
+
+		logRetry(ctx, req, attempt, resp, err)
+		metricsInstruments().retries.Add(1.0)
+		drainAndClose(resp)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		attemptReq, err = rewindRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		previous = delay
 	}
-	return InstrumentedClient
 }
 
 // InstrumentedHttpClient instruments the request, so we can determine the
-// timings and whether a keep-alive client was used
+// timings and whether a keep-alive client was used. Attempts of traced calls
+// get their own span.
 type InstrumentedHttpClient struct {
 	client RetryClient
 }
 
 func (ihc InstrumentedHttpClient) Do(req *http.Request) (*http.Response, error) {
+	req, span := startAttemptSpan(req)
 	req, requestDone := InstrumentHTTPRequest(req)
 	// we could call requestDone after downloading the content to get that timing
 	// as well but that would require passing this down, so this is simpler
 	defer requestDone()
-	return ihc.client.Do(req)
-}
-
-// createLogHook creates a LogHook function which only logs the intermediate
-// errors since the final one is returned and logged already
-func createLogHook(maxRetries int) func(errEntry pester.ErrEntry) {
-	return func(errEntry pester.ErrEntry) {
-		// only log intermediate retries, last retry if error is already returned and logged
-		if errEntry.Attempt < maxRetries {
-			var origErr error
-			if errEntry.Err != nil {
-				origErr = errEntry.Err
-			} else {
-				origErr = fmt.Errorf("unknown error") // err was nil
-			}
-			_ = errors.Wrapf(origErr, "attempt:%d retrying", errEntry.Attempt)
+	countAttempt(req.Context())
+	start := time.Now()
+	resp, err := ihc.client.Do(req)
+	metricsInstruments().attemptDuration.With(
+		"client", clientName(req.Context()),
+		"method", req.Method,
+		"route", RouteTemplate(req.Context()),
+		"status_class", statusClass(resp, err),
+	).Observe(time.Since(start).Seconds())
+	endSpan(span, resp, err)
+	return resp, err
+}
+
+// logRetry logs intermediate attempts only, the final error is returned and
+// logged by the caller.
+func logRetry(ctx context.Context, req *http.Request, attempt int, resp *http.Response, err error) {
+	fields := LogFields{
+		LogFieldAttempt: attempt,
+		LogFieldMethod:  req.Method,
+		LogFieldURL:     req.URL,
+	}
+	if err == nil {
+		err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+		fields[LogFieldStatus] = resp.StatusCode
+	}
+	logAt(ctx, LogLevelWarn, fmt.Sprintf("attempt:%d retrying: %v", attempt, err), fields)
+}
+
+func canRewind(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// rewindRequest returns a copy of req with a fresh body for the next attempt.
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	next := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		next.Body = body
+	}
+	return next, nil
+}
+
+// parseRetryAfter reads the Retry-After header as delay-seconds or HTTP-date.
+func parseRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
 		}
+		return delay, true
+	}
+	return 0, false
+}
+
+func drainAndClose(resp *http.Response) {
+	if resp == nil || resp.Body == nil {
+		return
 	}
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainBytes))
+	_ = resp.Body.Close()
 }
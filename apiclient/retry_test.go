@@ -2,16 +2,19 @@ package apiclient
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/sethgrid/pester"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 )
@@ -22,10 +25,10 @@ func Test_NewExtendedHTTPClient(t *testing.T) {
 		statusCode int
 		body       string
 	}{ // for maxRetries, expected statusCode and body
-		{maxRetries: -1, statusCode: 500},
-		{maxRetries: 0, statusCode: 500},
-		{maxRetries: 1, statusCode: 500},
-		{maxRetries: 2, statusCode: 500},
+		{maxRetries: -1, statusCode: 503},
+		{maxRetries: 0, statusCode: 503},
+		{maxRetries: 1, statusCode: 503},
+		{maxRetries: 2, statusCode: 503},
 		{maxRetries: 3, statusCode: 200, body: "hello"},
 		{maxRetries: 4, statusCode: 200, body: "hello"},
 	}
@@ -36,7 +39,7 @@ func Test_NewExtendedHTTPClient(t *testing.T) {
 			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				requestCount++
 				if requestCount <= 2 { // fails on first two attempts
-					w.WriteHeader(500) // unexpected error
+					w.WriteHeader(503) // retryable error
 					return
 				}
 				_, i := fmt.Fprint(w, "hello")
@@ -49,6 +52,7 @@ func Test_NewExtendedHTTPClient(t *testing.T) {
 			require.NotNil(t, client)
 			req, err := http.NewRequest("POST", ts.URL, nil)
 			require.NoError(t, err)
+			req.Header.Set(IdempotencyKeyHeader, "key") // POST is only retried with a key
 			resp, err := client.Do(req)
 			require.NoError(t, err)
 			defer resp.Body.Close()
@@ -61,39 +65,223 @@ func Test_NewExtendedHTTPClient(t *testing.T) {
 
 }
 
-func Test_createLogHook(t *testing.T) {
+func Test_retryClient_logsIntermediateAttempts(t *testing.T) {
 	testCases := []struct {
-		maxRetries int
-		expected   string
-	}{ // for maxRetries, expect logContains1 logContains2, otherwise empty
-		{maxRetries: 0},
-		{maxRetries: 1},
-		{maxRetries: 2, expected: "level=warning msg=\"attempt:1 retrying: myerror\"\n"},
-		{maxRetries: 3, expected: "level=warning msg=\"attempt:2 retrying: myerror\"\n"},
+		maxAttempts int
+		expected    string
+		notExpected string
+	}{ // for maxAttempts, expect the last intermediate attempt and never the final one
+		{maxAttempts: 0, notExpected: "retrying"},
+		{maxAttempts: 1, notExpected: "retrying"},
+		{maxAttempts: 2, expected: "level=warning msg=\"attempt:1 retrying: myerror\" attempt=1 method=GET url=\"http://example.com\"\n", notExpected: "attempt:2"},
+		{maxAttempts: 3, expected: "level=warning msg=\"attempt:2 retrying: myerror\" attempt=2 method=GET url=\"http://example.com\"\n", notExpected: "attempt:3"},
 	}
 
 	for _, tc := range testCases {
-		subTestName := fmt.Sprintf("maxRetries:%d", tc.maxRetries)
+		subTestName := fmt.Sprintf("maxAttempts:%d", tc.maxAttempts)
 		t.Run(subTestName, func(t *testing.T) {
 			log.SetLevel(log.WarnLevel)
-			log.SetFormatter(&log.TextFormatter{})
-
+			log.SetFormatter(&log.TextFormatter{DisableTimestamp: true})
 			buffer := &bytes.Buffer{}
 			log.SetOutput(buffer)
-			f := func(buffer *bytes.Buffer) {
+			defer log.SetOutput(os.Stderr)
 
-			}
-			defer f(buffer)
-			logHook := createLogHook(tc.maxRetries)
-			for i := 0; i < 5; i++ { // simulate error calls to logHook
-				logHook(pester.ErrEntry{
-					Attempt: i + 1,
-					Err:     fmt.Errorf("myerror"),
-				})
+			attempts := 0
+			failing := retryClientFunc(func(*http.Request) (*http.Response, error) {
+				attempts++
+				return nil, fmt.Errorf("myerror")
+			})
+			client := NewRetryClient(failing, RetryConfig{MaxAttempts: tc.maxAttempts, Backoff: ConstantBackoff(0)})
+			req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+			require.NoError(t, err)
+			_, err = client.Do(req)
+			require.EqualError(t, err, "myerror")
+
+			if tc.maxAttempts > 1 {
+				require.Equal(t, tc.maxAttempts, attempts)
+			} else {
+				require.Equal(t, 1, attempts)
 			}
 			require.Contains(t, buffer.String(), tc.expected)
+			require.NotContains(t, buffer.String(), tc.notExpected)
+		})
+	}
+}
+
+func Test_retryClient_policyAndStatuses(t *testing.T) {
+	testCases := []struct {
+		status       int
+		wantAttempts int
+	}{
+		{status: http.StatusOK, wantAttempts: 1},
+		{status: http.StatusBadRequest, wantAttempts: 1},
+		{status: http.StatusInternalServerError, wantAttempts: 1},
+		{status: http.StatusNotImplemented, wantAttempts: 1},
+		{status: http.StatusTooManyRequests, wantAttempts: 3},
+		{status: http.StatusBadGateway, wantAttempts: 3},
+		{status: http.StatusServiceUnavailable, wantAttempts: 3},
+		{status: http.StatusGatewayTimeout, wantAttempts: 3},
+	}
+	for _, tc := range testCases {
+		t.Run(strconv.Itoa(tc.status), func(t *testing.T) {
+			attempts := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.WriteHeader(tc.status)
+			}))
+			defer ts.Close()
+
+			client := NewRetryClient(ts.Client(), RetryConfig{MaxAttempts: 3, Backoff: ConstantBackoff(time.Millisecond)})
+			req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+			require.NoError(t, err)
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, tc.status, resp.StatusCode)
+			require.Equal(t, tc.wantAttempts, attempts)
 		})
 	}
+
+	t.Run("custom policy", func(t *testing.T) {
+		attempts := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			w.WriteHeader(http.StatusConflict)
+		}))
+		defer ts.Close()
+
+		policy := func(req *http.Request, resp *http.Response, err error) bool {
+			return resp != nil && resp.StatusCode == http.StatusConflict
+		}
+		client := NewRetryClient(ts.Client(), RetryConfig{MaxAttempts: 4, Backoff: ConstantBackoff(0), Policy: policy})
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, 4, attempts)
+	})
+}
+
+func Test_retryClient_rewindsBody(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if len(bodies) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	client := NewRetryClient(ts.Client(), RetryConfig{MaxAttempts: 3, Backoff: ConstantBackoff(0)})
+
+	req, err := http.NewRequest(http.MethodPut, ts.URL, strings.NewReader("payload"))
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, []string{"payload", "payload", "payload"}, bodies)
+
+	// a body without GetBody can not be rewound, so it is only sent once
+	bodies = nil
+	req, err = http.NewRequest(http.MethodPut, ts.URL, ioutil.NopCloser(strings.NewReader("once")))
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, []string{"once"}, bodies)
+}
+
+func Test_retryClient_retryAfterAndDeadline(t *testing.T) {
+	attempts := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("Retry-After", r.URL.Query().Get("after"))
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	t.Run("honors Retry-After", func(t *testing.T) {
+		attempts = 0
+		client := NewRetryClient(ts.Client(), RetryConfig{MaxAttempts: 2, Backoff: ConstantBackoff(0)})
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"?after=1", nil)
+		require.NoError(t, err)
+		start := time.Now()
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, 2, attempts)
+		require.GreaterOrEqual(t, time.Since(start), time.Second)
+	})
+
+	t.Run("never sleeps past the deadline", func(t *testing.T) {
+		attempts = 0
+		client := NewRetryClient(ts.Client(), RetryConfig{MaxAttempts: 5, Backoff: ConstantBackoff(0)})
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"?after=30", nil)
+		require.NoError(t, err)
+		start := time.Now()
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		require.Equal(t, 1, attempts)
+		require.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+
+	t.Run("MaxRetryAfter ends retries", func(t *testing.T) {
+		attempts = 0
+		client := NewRetryClient(ts.Client(), RetryConfig{MaxAttempts: 5, MaxRetryAfter: time.Second})
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"?after=120", nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, 1, attempts)
+	})
+
+	t.Run("cancel while waiting", func(t *testing.T) {
+		attempts = 0
+		client := NewRetryClient(ts.Client(), RetryConfig{MaxAttempts: 5, Backoff: ConstantBackoff(time.Minute)})
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		_, err = client.Do(req)
+		require.Equal(t, context.Canceled, err)
+		require.Equal(t, 1, attempts)
+	})
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	header := func(value string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": []string{value}}}
+	}
+	d, ok := parseRetryAfter(header("3"))
+	require.True(t, ok)
+	require.Equal(t, 3*time.Second, d)
+
+	d, ok = parseRetryAfter(header(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)))
+	require.True(t, ok)
+	require.InDelta(t, float64(time.Hour), float64(d), float64(2*time.Second))
+
+	_, ok = parseRetryAfter(header("soon"))
+	require.False(t, ok)
+	_, ok = parseRetryAfter(header("-1"))
+	require.False(t, ok)
+	_, ok = parseRetryAfter(nil)
+	require.False(t, ok)
+}
+
+// retryClientFunc adapts a function to the RetryClient interface.
+type retryClientFunc func(*http.Request) (*http.Response, error)
+
+func (f retryClientFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
 }
 
 func ExampleNewExtendedHTTPClient() {
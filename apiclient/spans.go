@@ -0,0 +1,69 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/CodeNamor/http/tracing"
+)
+
+// startSpan starts the client span of a call when the client has a
+// SpanRecorder or ctx is part of a trace, and injects it into request. It
+// returns a nil span otherwise.
+func (c *Client) startSpan(ctx context.Context, request *http.Request) (context.Context, *tracing.Span) {
+	if _, traced := tracing.SpanContextFromContext(ctx); !traced && c.SpanRecorder == nil {
+		return ctx, nil
+	}
+	ctx, span := tracing.StartSpan(ctx, spanName(ctx, request), tracing.SpanKindClient, c.SpanRecorder)
+	// the URL is redacted the way logs and wire dumps are, it may carry the
+	// credentials of the authenticator by now
+	redactedURL := c.redactor().URL(request.URL)
+	ctx = context.WithValue(ctx, spanURLKey, redactedURL)
+	setSpanRequestAttributes(span, request.Method, redactedURL)
+	tracing.Inject(span.SpanContext(), request.Header)
+	return ctx, span
+}
+
+// startAttemptSpan starts a child span for one attempt of a traced call and
+// injects it into a copy of req, so the upstream sees the attempt as parent.
+func startAttemptSpan(req *http.Request) (*http.Request, *tracing.Span) {
+	parent := tracing.SpanFromContext(req.Context())
+	if parent == nil {
+		return req, nil
+	}
+	ctx, span := tracing.StartSpan(req.Context(), spanName(req.Context(), req)+" attempt", tracing.SpanKindClient, nil)
+	redactedURL, ok := req.Context().Value(spanURLKey).(string)
+	if !ok {
+		redactedURL = defaultRedactor.URL(req.URL)
+	}
+	setSpanRequestAttributes(span, req.Method, redactedURL)
+	req = req.WithContext(ctx)
+	req.Header = req.Header.Clone()
+	tracing.Inject(span.SpanContext(), req.Header)
+	return req, span
+}
+
+// endSpan records the outcome of a call on span and ends it.
+func endSpan(span *tracing.Span, resp *http.Response, err error) {
+	if span == nil {
+		return
+	}
+	if resp != nil {
+		span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+	}
+	span.SetError(err)
+	span.End()
+}
+
+func spanName(ctx context.Context, request *http.Request) string {
+	if route := RouteTemplate(ctx); route != "" {
+		return request.Method + " " + route
+	}
+	return request.Method + " " + request.URL.Host
+}
+
+func setSpanRequestAttributes(span *tracing.Span, method, redactedURL string) {
+	span.SetAttribute("http.method", method)
+	span.SetAttribute("http.url", redactedURL)
+}
@@ -0,0 +1,88 @@
+package apiclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/CodeNamor/http/tracing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiClient_tracesCallsAndAttempts(t *testing.T) {
+	var calls int32
+	var traceparents []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents = append(traceparents, r.Header.Get(tracing.TraceparentHeader))
+		require.Equal(t, "congo=t61rcWkgMzE", r.Header.Get(tracing.TracestateHeader))
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	recorder := tracing.NewInMemoryRecorder()
+	c, err := InitClient(NewRetryClient(&http.Client{}, RetryConfig{MaxAttempts: 2, Backoff: ConstantBackoff(0)}), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	c.SpanRecorder = recorder
+
+	remote, err := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	remote.TraceState = "congo=t61rcWkgMzE"
+	ctx := WithRouteTemplate(tracing.ContextWithRemoteSpanContext(context.Background(), remote), "/orders")
+
+	resp, err := c.Get(ctx, "/orders", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	spans := recorder.Spans()
+	require.Len(t, spans, 3)
+	first, second, call := spans[0], spans[1], spans[2]
+	require.Equal(t, "GET /orders", call.Name)
+	require.Equal(t, remote.SpanID, call.ParentSpanID)
+	require.Equal(t, "200", call.Attributes["http.status_code"])
+	for i, attempt := range []tracing.SpanData{first, second} {
+		require.Equal(t, "GET /orders attempt", attempt.Name)
+		require.Equal(t, remote.TraceID, attempt.TraceID)
+		require.Equal(t, call.SpanID, attempt.ParentSpanID)
+		require.Equal(t, "00-"+attempt.TraceID.String()+"-"+attempt.SpanID.String()+"-01", traceparents[i])
+	}
+	require.Equal(t, "503", first.Attributes["http.status_code"])
+}
+
+func TestApiClient_spansRedactURL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "s3cr3t", r.URL.Query().Get("api_key"))
+	}))
+	defer ts.Close()
+
+	recorder := tracing.NewInMemoryRecorder()
+	c, err := InitClient(NewRetryClient(&http.Client{}, RetryConfig{MaxAttempts: 1}), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	c.SpanRecorder = recorder
+	c.Authenticator = APIKeyQuery("api_key", StaticSecret("s3cr3t"))
+
+	_, err = c.Get(context.Background(), "/orders", nil)
+	require.NoError(t, err)
+	spans := recorder.Spans()
+	require.Len(t, spans, 2)
+	for _, span := range spans {
+		require.Equal(t, ts.URL+"/orders?api_key=%5BREDACTED%5D", span.Attributes["http.url"], span.Name)
+	}
+}
+
+func TestApiClient_untracedCallsSendNoTraceparent(t *testing.T) {
+	var traceparent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get(tracing.TraceparentHeader)
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	_, err = c.Get(context.Background(), "/", nil)
+	require.NoError(t, err)
+	require.Empty(t, traceparent)
+}
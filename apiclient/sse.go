@@ -0,0 +1,299 @@
+package apiclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultEventRetry = 3 * time.Second
+
+// Event is a message received from a text/event-stream.
+type Event struct {
+	// ID is the last event ID of the stream when the event was dispatched.
+	ID string
+	// Type is the event field, it defaults to "message".
+	Type string
+	Data string
+}
+
+// EventHandler handles the events of Client.Subscribe, an error stops the
+// subscription and is returned by Subscribe.
+type EventHandler func(Event) error
+
+// EventStreamConfig configures Client.Subscribe and Client.Events.
+type EventStreamConfig struct {
+	// LastEventID is sent in the Last-Event-ID header of the first request,
+	// to resume a stream consumed before.
+	LastEventID string
+	// RetryDelay is the delay before reconnecting until the server sets one
+	// with a retry field, defaults to 3s.
+	RetryDelay time.Duration
+	// MaxReconnects is the number of reconnects in a row without receiving
+	// an event before giving up, zero means no limit and -1 no reconnects.
+	MaxReconnects int
+}
+
+// EventStreamError is returned when a server answers a subscription with
+// something else than an event stream, no reconnect is attempted then.
+type EventStreamError struct {
+	StatusCode  int
+	ContentType string
+}
+
+func (e *EventStreamError) Error() string {
+	return fmt.Sprintf("expected a text/event-stream, got status %d with content type %q", e.StatusCode, e.ContentType)
+}
+
+// Subscribe consumes the Server-Sent Events at urlPath and calls handler for
+// every event until ctx is done, handler returns an error or the server
+// ends the stream with 204 No Content. Requests are made with DoStream, so
+// the authorization and transport of the client apply. A dropped connection
+// is reopened after the retry delay with the Last-Event-ID of the last
+// event, as the server expects.
+//
+// Streams are long lived: the http.Client of the HTTPClient must not have a
+// Timeout, use a deadline on ctx instead.
+//
+//	err := client.Subscribe(ctx, "/orders/events", EventStreamConfig{}, func(event Event) error {
+//	  if event.Type == "order-updated" {
+//	    return handleUpdate(event.Data)
+//	  }
+//	  return nil
+//	})
+func (c *Client) Subscribe(ctx context.Context, urlPath string, config EventStreamConfig, handler EventHandler, opts ...RequestOption) error {
+	options := newRequestOptions(opts)
+	parser := &eventParser{lastEventID: config.LastEventID, retry: config.RetryDelay}
+	if parser.retry <= 0 {
+		parser.retry = defaultEventRetry
+	}
+	for failures := 0; ; failures++ {
+		received, err := c.subscribeOnce(ctx, urlPath, options, parser, handler)
+		if received {
+			failures = 0
+		}
+		if err == errStreamEnded {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if config.MaxReconnects < 0 || (config.MaxReconnects > 0 && failures >= config.MaxReconnects) {
+			return fmt.Errorf("event stream at %s ended after %d reconnects: %w", urlPath, failures, io.ErrUnexpectedEOF)
+		}
+		timer := time.NewTimer(parser.retry)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		c.log(ctx, LogLevelDebug, "reconnecting to event stream", LogFields{LogFieldURL: urlPath, LogFieldAttempt: failures + 1})
+	}
+}
+
+// errStreamEnded is returned by subscribeOnce when the server ended the
+// subscription with 204 No Content.
+var errStreamEnded = errors.New("event stream ended by the server")
+
+// subscribeOnce opens the stream once and dispatches its events. A nil
+// error means the connection was lost and should be reopened.
+func (c *Client) subscribeOnce(ctx context.Context, urlPath string, options *requestOptions, parser *eventParser, handler EventHandler) (bool, error) {
+	request, err := c.newRequest(http.MethodGet, urlPath, options)
+	if err != nil {
+		c.log(ctx, LogLevelError, "failed to create event stream request", LogFields{LogFieldError: err})
+		return false, err
+	}
+	request.Header.Set("Accept", "text/event-stream")
+	request.Header.Set("Cache-Control", "no-cache")
+	if parser.lastEventID != "" {
+		request.Header.Set("Last-Event-ID", parser.lastEventID)
+	}
+	stream, err := c.DoStream(ctx, request)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		c.log(ctx, LogLevelWarn, "event stream connection failed", LogFields{LogFieldURL: request.URL, LogFieldError: err})
+		return false, nil
+	}
+	defer stream.Close()
+	if stream.StatusCode == http.StatusNoContent {
+		return false, errStreamEnded
+	}
+	mediaType, _, _ := mime.ParseMediaType(stream.Header.Get("Content-Type"))
+	if stream.StatusCode != http.StatusOK || mediaType != "text/event-stream" {
+		return false, &EventStreamError{StatusCode: stream.StatusCode, ContentType: stream.Header.Get("Content-Type")}
+	}
+
+	received := false
+	err = parser.parse(stream.Body, func(event Event) error {
+		received = true
+		return handler(event)
+	})
+	if err == errHandler {
+		return received, parser.handlerErr
+	}
+	return received, nil
+}
+
+// Events consumes the Server-Sent Events at urlPath like Subscribe and
+// delivers them on the channel of the returned EventStream.
+//
+//	stream := client.Events(ctx, "/orders/events", EventStreamConfig{})
+//	defer stream.Close()
+//	for event := range stream.C {
+//	  process(event)
+//	}
+//	if err := stream.Err(); err != nil {
+//	  return err
+//	}
+func (c *Client) Events(ctx context.Context, urlPath string, config EventStreamConfig, opts ...RequestOption) *EventStream {
+	ctx, cancel := context.WithCancel(ctx)
+	events := make(chan Event)
+	s := &EventStream{C: events, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		defer close(events)
+		s.err = c.Subscribe(ctx, urlPath, config, func(event Event) error {
+			select {
+			case events <- event:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}, opts...)
+	}()
+	return s
+}
+
+// EventStream delivers the events of Client.Events on C, which is closed
+// when the subscription ends.
+type EventStream struct {
+	C      <-chan Event
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+	once   sync.Once
+}
+
+// Err returns why the subscription ended once C is closed: nil when the
+// server ended it or it was closed, the error otherwise.
+func (s *EventStream) Err() error {
+	<-s.done
+	if s.err == context.Canceled {
+		return nil
+	}
+	return s.err
+}
+
+// Close ends the subscription and waits for it to stop.
+func (s *EventStream) Close() {
+	s.once.Do(s.cancel)
+	for range s.C {
+		// drain so the subscription can stop
+	}
+	<-s.done
+}
+
+// errHandler stops parsing when the handler fails.
+var errHandler = errors.New("event handler failed")
+
+// eventParser parses a text/event-stream as specified by the HTML Living
+// Standard. The last event ID and retry delay persist across connections.
+type eventParser struct {
+	lastEventID string
+	retry       time.Duration
+	handlerErr  error
+}
+
+func (p *eventParser) parse(r io.Reader, dispatch func(Event) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), 1<<20)
+	scanner.Split(scanEventLines)
+	var eventType string
+	var data strings.Builder
+	hasData := false
+	first := true
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if first {
+			line = bytes.TrimPrefix(line, []byte("\xEF\xBB\xBF"))
+			first = false
+		}
+		if len(line) == 0 {
+			if hasData {
+				event := Event{ID: p.lastEventID, Type: eventType, Data: strings.TrimSuffix(data.String(), "\n")}
+				if event.Type == "" {
+					event.Type = "message"
+				}
+				if err := dispatch(event); err != nil {
+					p.handlerErr = err
+					return errHandler
+				}
+			}
+			eventType, hasData = "", false
+			data.Reset()
+			continue
+		}
+		if line[0] == ':' {
+			continue // comment
+		}
+		field, value := line, []byte(nil)
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], bytes.TrimPrefix(line[i+1:], []byte(" "))
+		}
+		switch string(field) {
+		case "event":
+			eventType = string(value)
+		case "data":
+			data.Write(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				p.lastEventID = string(value)
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(string(value), 10, 63); err == nil && len(value) > 0 && value[0] != '+' {
+				p.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// scanEventLines splits lines ending in CRLF, LF or CR.
+func scanEventLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil // a CR at the end of data may be followed by LF
+	}
+	return 0, nil, nil // at EOF the incomplete line is discarded with its event
+}
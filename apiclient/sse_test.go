@@ -0,0 +1,116 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventParser(t *testing.T) {
+	stream := "\xEF\xBB\xBF: comment\r\n" +
+		"data: first\r\n\r\n" +
+		"event: update\rid: 7\rdata:a\rdata: b\r\r" +
+		"retry: 1500\n\n" +
+		"retry: x\nid\ndata\n\n" +
+		"data: incomplete"
+	parser := &eventParser{retry: time.Second}
+	var events []Event
+	require.NoError(t, parser.parse(strings.NewReader(stream), func(event Event) error {
+		events = append(events, event)
+		return nil
+	}))
+	require.Equal(t, []Event{
+		{Type: "message", Data: "first"},
+		{ID: "7", Type: "update", Data: "a\nb"},
+		{Type: "message", Data: ""},
+	}, events)
+	require.Equal(t, 1500*time.Millisecond, parser.retry)
+	require.Equal(t, "", parser.lastEventID)
+}
+
+func TestClient_Subscribe_reconnects(t *testing.T) {
+	var connections int32
+	var lastEventIDs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "text/event-stream", r.Header.Get("Accept"))
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		switch atomic.AddInt32(&connections, 1) {
+		case 1:
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte("retry: 10\nid: 1\ndata: one\n\nid: 2\ndata: two\n\n"))
+		case 2:
+			w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+			_, _ = w.Write([]byte("id: 3\ndata: three\n\n"))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+
+	var data []string
+	start := time.Now()
+	err = c.Subscribe(context.Background(), "/events", EventStreamConfig{LastEventID: "0"}, func(event Event) error {
+		data = append(data, event.ID+":"+event.Data)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), time.Second, "the server retry replaces the default delay")
+	require.Equal(t, []string{"1:one", "2:two", "3:three"}, data)
+	require.Equal(t, []string{"0", "2", "3"}, lastEventIDs)
+
+	// handler errors end the subscription
+	atomic.StoreInt32(&connections, 0)
+	failure := errors.New("stop")
+	err = c.Subscribe(context.Background(), "/events", EventStreamConfig{}, func(event Event) error {
+		return failure
+	})
+	require.Equal(t, failure, err)
+}
+
+func TestClient_Events(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/json" {
+			w.Header().Set("Content-Type", "application/json")
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: tick\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+
+	stream := c.Events(context.Background(), "/events", EventStreamConfig{})
+	event := <-stream.C
+	require.Equal(t, "tick", event.Data)
+	stream.Close()
+	require.NoError(t, stream.Err())
+
+	stream = c.Events(context.Background(), "/json", EventStreamConfig{})
+	for range stream.C {
+	}
+	var streamErr *EventStreamError
+	require.True(t, errors.As(stream.Err(), &streamErr))
+
+	// without events the reconnects are limited
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	c, err = InitClient(newClient(), closed.URL, "test", false, "")
+	require.NoError(t, err)
+	err = c.Subscribe(context.Background(), "/events", EventStreamConfig{RetryDelay: time.Millisecond, MaxReconnects: 2}, nil)
+	require.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+}
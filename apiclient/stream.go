@@ -0,0 +1,264 @@
+package apiclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrStreamClosed is returned when reading from a StreamResponse body after it was closed.
+var ErrStreamClosed = errors.New("apiclient: read on closed response stream")
+
+// StreamResponse is the response from DoStream. Unlike Response the body is
+// not buffered, it is read directly from the connection. The caller must
+// always Close the Body once done with it, otherwise the connection leaks.
+type StreamResponse struct {
+	Body            io.ReadCloser
+	StatusCode      int
+	Header          http.Header
+	ContentLength   int64
+	OriginalRequest *http.Request
+	// ContentEncoding is the coding the body is received in before it is
+	// decoded, such as gzip, empty when it is not encoded.
+	ContentEncoding string
+}
+
+// Close closes the response body. It is safe to call more than once.
+func (s *StreamResponse) Close() error {
+	if s == nil || s.Body == nil {
+		return nil
+	}
+	return s.Body.Close()
+}
+
+// DoStream executes a HTTP request like Do, but hands back the live response
+// body instead of reading it into memory. Use it for large downloads and for
+// responses that are processed incrementally such as NDJSON. Authorization,
+// logging and the metrics of the HTTPClient chain are the same as for Do, the
+// call metrics and completion log are recorded when the body is closed, with
+// the number of bytes read as response size.
+//
+// The body is bound to ctx and to the Timeout of the underlying http.Client,
+// so long running streams need a client without an overall timeout.
+//
+//	stream, err := client.DoStream(ctx, request)
+//	if err != nil {
+//	  return err
+//	}
+//	defer stream.Close()
+//	_, err = io.Copy(file, stream.Body)
+func (c *Client) DoStream(ctx context.Context, request *http.Request) (*StreamResponse, error) {
+	if request == nil {
+		_, _, err := c.send(ctx, request, "DoStream")
+		return nil, err
+	}
+	ctx, finish := c.beginCall(ctx, request, "DoStream")
+	start := time.Now()
+	response, request, err := c.send(ctx, request, "DoStream")
+	if err != nil {
+		finish(nil, 0, err)
+		return &StreamResponse{StatusCode: http.StatusInternalServerError}, err
+	}
+
+	encoding, _ := responseEncoding(request, response)
+	body := response.Body
+	if body == nil {
+		body = http.NoBody
+	}
+	stream := &StreamResponse{
+		StatusCode:      response.StatusCode,
+		Header:          response.Header,
+		ContentLength:   response.ContentLength,
+		OriginalRequest: request,
+		ContentEncoding: encoding,
+	}
+	stream.Body = &streamBody{
+		ctx:     ctx,
+		client:  c,
+		body:    body,
+		request: request,
+		start:   start,
+		finish: func(read int64, err error) {
+			// the call is complete once the body has been consumed
+			finish(&Response{StatusCode: stream.StatusCode, Header: stream.Header, OriginalRequest: request, ContentEncoding: encoding}, read, err)
+		},
+	}
+	return stream, nil
+}
+
+// streamBody wraps a live response body, it tracks the number of bytes read
+// and records the outcome of the stream once it is closed.
+type streamBody struct {
+	ctx     context.Context
+	client  *Client
+	body    io.ReadCloser
+	request *http.Request
+	start   time.Time
+	finish  func(read int64, err error)
+
+	mu     sync.Mutex
+	read   int64
+	closed bool
+	err    error
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return 0, ErrStreamClosed
+	}
+	b.mu.Unlock()
+
+	n, err := b.body.Read(p)
+
+	b.mu.Lock()
+	b.read += int64(n)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	b.mu.Unlock()
+	return n, err
+}
+
+func (b *streamBody) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	read, readErr := b.read, b.err
+	b.mu.Unlock()
+
+	err := b.body.Close()
+	if readErr != nil {
+		b.client.log(b.ctx, LogLevelError, "Error reading HTTP response stream", LogFields{
+			LogFieldMethod:   b.request.Method,
+			LogFieldURL:      b.request.URL,
+			LogFieldDuration: time.Since(b.start),
+			LogFieldError:    readErr,
+			"bytes":          read,
+		})
+	}
+	b.finish(read, readErr)
+	return err
+}
+
+// JSONStreamDecoder decodes a stream of JSON values one element at a time.
+// It accepts both newline delimited JSON (NDJSON) and a top level JSON array,
+// the format is detected from the first byte of the stream. Values are only
+// read from the underlying reader when Decode is called, so a slow consumer
+// applies backpressure all the way to the connection.
+//
+//	dec := NewJSONStreamDecoder(stream.Body)
+//	for {
+//	  var item Item
+//	  err := dec.Decode(&item)
+//	  if err == io.EOF {
+//	    break
+//	  }
+//	  if err != nil {
+//	    return err
+//	  }
+//	  process(item)
+//	}
+type JSONStreamDecoder struct {
+	reader  *bufio.Reader
+	dec     *json.Decoder
+	started bool
+	array   bool
+	done    bool
+	err     error
+}
+
+// NewJSONStreamDecoder returns a JSONStreamDecoder reading from r.
+func NewJSONStreamDecoder(r io.Reader) *JSONStreamDecoder {
+	br := bufio.NewReader(r)
+	return &JSONStreamDecoder{
+		reader: br,
+		dec:    json.NewDecoder(br),
+	}
+}
+
+// Decode decodes the next element of the stream into v. It returns io.EOF
+// once the stream is exhausted, any other error is sticky and returned from
+// every following call.
+func (d *JSONStreamDecoder) Decode(v interface{}) error {
+	if d.err != nil {
+		return d.err
+	}
+	if d.done {
+		return io.EOF
+	}
+	if !d.started {
+		if err := d.start(); err != nil {
+			return d.fail(err)
+		}
+		if d.done {
+			return io.EOF
+		}
+	}
+
+	if d.array {
+		if !d.dec.More() {
+			tok, err := d.dec.Token()
+			if err != nil {
+				return d.fail(fmt.Errorf("reading end of JSON array: %w", err))
+			}
+			if delim, ok := tok.(json.Delim); !ok || delim != ']' {
+				return d.fail(fmt.Errorf("expected end of JSON array, got %v", tok))
+			}
+			d.done = true
+			return io.EOF
+		}
+	}
+
+	if err := d.dec.Decode(v); err != nil {
+		if err == io.EOF && !d.array {
+			d.done = true
+			return io.EOF
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return d.fail(err)
+	}
+	return nil
+}
+
+// start detects whether the stream is a JSON array or NDJSON.
+func (d *JSONStreamDecoder) start() error {
+	d.started = true
+	for {
+		b, err := d.reader.Peek(1)
+		if err == io.EOF {
+			d.done = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = d.reader.ReadByte()
+			continue
+		case '[':
+			d.array = true
+			_, err = d.dec.Token() // consume the opening bracket
+			return err
+		}
+		return nil
+	}
+}
+
+func (d *JSONStreamDecoder) fail(err error) error {
+	d.err = err
+	return err
+}
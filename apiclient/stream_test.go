@@ -0,0 +1,90 @@
+package apiclient
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiClient_DoStream(t *testing.T) {
+	payload := strings.Repeat("0123456789", 10000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "secret", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = io.WriteString(w, payload)
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", true, "secret")
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+
+	stream, err := c.DoStream(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+	require.Equal(t, "application/octet-stream", stream.Header.Get("Content-Type"))
+
+	body, err := ioutil.ReadAll(stream.Body)
+	require.NoError(t, err)
+	require.Equal(t, payload, string(body))
+
+	require.NoError(t, stream.Close())
+	require.NoError(t, stream.Close(), "second close must be a no-op")
+	_, err = stream.Body.Read(make([]byte, 1))
+	require.Equal(t, ErrStreamClosed, err)
+}
+
+func TestApiClient_DoStream_nilRequest(t *testing.T) {
+	c, _ := InitClient(newClient(), testURL, "test", false, "")
+	stream, err := c.DoStream(context.Background(), nil)
+	require.Error(t, err)
+	require.Nil(t, stream)
+}
+
+func TestJSONStreamDecoder(t *testing.T) {
+	type item struct {
+		ID int `json:"id"`
+	}
+	testCases := []struct {
+		name    string
+		input   string
+		want    []int
+		wantErr bool
+	}{
+		{name: "ndjson", input: "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n", want: []int{1, 2, 3}},
+		{name: "array", input: " [ {\"id\":1}, {\"id\":2} ] ", want: []int{1, 2}},
+		{name: "empty array", input: "[]", want: nil},
+		{name: "empty body", input: "", want: nil},
+		{name: "truncated array", input: "[{\"id\":1},", want: []int{1}, wantErr: true},
+		{name: "malformed ndjson", input: "{\"id\":1}\n{\"id\":", want: []int{1}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dec := NewJSONStreamDecoder(strings.NewReader(tc.input))
+			var got []int
+			var err error
+			for {
+				var it item
+				err = dec.Decode(&it)
+				if err != nil {
+					break
+				}
+				got = append(got, it.ID)
+			}
+			require.Equal(t, tc.want, got)
+			if tc.wantErr {
+				require.NotEqual(t, io.EOF, err)
+				require.Equal(t, err, dec.Decode(&item{}), "errors are sticky")
+			} else {
+				require.Equal(t, io.EOF, err)
+			}
+		})
+	}
+}
@@ -1,52 +1,276 @@
 package apiclient
 
 import (
-	"context"
+	"crypto/tls"
 	"expvar"
-	metrics "github.com/go-kit/kit/metrics/expvar"
+	"github.com/CodeNamor/http/metrics"
 	"github.com/gorilla/mux"
 	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
 	expvarHTTPClientNewConns    = "HTTPClientNewConnections"
 	expvarHTTPClientReusedConns = "HTTPClientReusedConnections"
 	expvarHTTPClientConnPrep    = "HTTPClientConnectionPreparation"
+	expvarHTTPClientRetries     = "HTTPClientRetries"
+
+	expvarHTTPClientCircuitOpened     = "HTTPClientCircuitOpened"
+	expvarHTTPClientCircuitHalfOpened = "HTTPClientCircuitHalfOpened"
+	expvarHTTPClientCircuitClosed     = "HTTPClientCircuitClosed"
+	expvarHTTPClientCircuitRejected   = "HTTPClientCircuitRejected"
+	expvarHTTPClientCircuitStates     = "HTTPClientCircuitStates"
+
+	expvarHTTPClientConcurrencyLimit    = "HTTPClientConcurrencyLimit"
+	expvarHTTPClientConcurrencyInFlight = "HTTPClientConcurrencyInFlight"
+	expvarHTTPClientConcurrencyQueue    = "HTTPClientConcurrencyQueueDepth"
+	expvarHTTPClientConcurrencyRejected = "HTTPClientConcurrencyRejected"
+
+	expvarHTTPClientCoalesced = "HTTPClientCoalescedRequests"
+
+	expvarHTTPClientAttemptDuration = "HTTPClientAttemptDuration"
+	expvarHTTPClientCalls           = "HTTPClientCalls"
+	expvarHTTPClientCallDuration    = "HTTPClientCallDuration"
+	expvarHTTPClientCallRetries     = "HTTPClientCallRetries"
+	expvarHTTPClientResponseSize    = "HTTPClientResponseSize"
+
+	expvarHTTPClientCompressedResponseSize = "HTTPClientCompressedResponseSize"
+
+	expvarHTTPClientBatches       = "HTTPClientBatches"
+	expvarHTTPClientBatchCalls    = "HTTPClientBatchCalls"
+	expvarHTTPClientBatchDuration = "HTTPClientBatchDuration"
 )
 
-var httpClientNewConnCounter *metrics.Counter
-var httpClientReusedConnCounter *metrics.Counter
-var httpClientConnPrepHistogram *metrics.Histogram
+// instruments are the metrics recorded by this package, they are replaced
+// as a whole by ConfigureMetrics.
+type instruments struct {
+	newConns            metrics.Counter
+	reusedConns         metrics.Counter
+	connPrep            metrics.Histogram
+	retries             metrics.Counter
+	circuitOpened       metrics.Counter
+	circuitHalfOpened   metrics.Counter
+	circuitClosed       metrics.Counter
+	circuitRejected     metrics.Counter
+	concurrencyLimit    metrics.Gauge
+	concurrencyInFlight metrics.Gauge
+	concurrencyQueue    metrics.Gauge
+	concurrencyRejected metrics.Counter
+	coalesced           metrics.Counter
+	attemptDuration     metrics.Histogram
+	calls               metrics.Counter
+	callDuration        metrics.Histogram
+	callRetries         metrics.Counter
+	responseSize        metrics.Histogram
+	compressedSize      metrics.Histogram
+	batches             metrics.Counter
+	batchCalls          metrics.Counter
+	batchDuration       metrics.Histogram
+}
+
+var currentInstruments atomic.Pointer[instruments]
+
+// httpClientCircuitStates publishes the state of every circuit by key, it
+// holds strings so it stays an expvar map whatever the metrics provider.
+var httpClientCircuitStates *expvar.Map
 
 func init() {
-	httpClientNewConnCounter = metrics.NewCounter(expvarHTTPClientNewConns)
-	httpClientReusedConnCounter = metrics.NewCounter(expvarHTTPClientReusedConns)
-	httpClientConnPrepHistogram = metrics.NewHistogram(expvarHTTPClientConnPrep, 50)
+	httpClientCircuitStates = expvar.NewMap(expvarHTTPClientCircuitStates)
+	ConfigureMetrics(MetricsConfig{})
+}
+
+// MetricsConfig configures the metrics recorded by this package.
+type MetricsConfig struct {
+	// Provider records the metrics, defaults to metrics.NewExpvarProvider()
+	// which publishes them under the same expvar names as before.
+	Provider metrics.Provider
+	// LatencyBuckets are the bucket bounds in seconds of the latency
+	// histograms, defaults to metrics.DefaultBuckets.
+	LatencyBuckets []float64
+}
+
+// ConfigureMetrics replaces the provider of all metrics recorded by this
+// package. Call it once at startup, metrics recorded before are not carried
+// over.
+//
+//	registry := metrics.NewPrometheusRegistry()
+//	apiclient.ConfigureMetrics(apiclient.MetricsConfig{
+//	  Provider:       metrics.NewMultiProvider(metrics.NewExpvarProvider(), registry),
+//	  LatencyBuckets: []float64{.05, .1, .25, .5, 1, 2.5},
+//	})
+//	AddPrometheusHandlerToRouter(router, "/metrics", registry)
+func ConfigureMetrics(config MetricsConfig) {
+	p := config.Provider
+	if p == nil {
+		p = metrics.NewExpvarProvider()
+	}
+	counter := func(name, help string, labels ...string) metrics.Counter {
+		return p.NewCounter(metrics.Desc{Name: name, Help: help, Labels: labels})
+	}
+	gauge := func(name, help string, labels ...string) metrics.Gauge {
+		return p.NewGauge(metrics.Desc{Name: name, Help: help, Labels: labels})
+	}
+	currentInstruments.Store(&instruments{
+		newConns:    counter(expvarHTTPClientNewConns, "New connections opened by the HTTP client."),
+		reusedConns: counter(expvarHTTPClientReusedConns, "Kept alive connections reused by the HTTP client."),
+		connPrep: p.NewHistogram(metrics.Desc{
+			Name:    expvarHTTPClientConnPrep,
+			Help:    "Time to prepare a connection (dns, tcp and tls) in milliseconds.",
+			Buckets: []float64{0, 1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500},
+		}),
+		retries:             counter(expvarHTTPClientRetries, "Requests retried by the retry client."),
+		circuitOpened:       counter(expvarHTTPClientCircuitOpened, "Circuits opened."),
+		circuitHalfOpened:   counter(expvarHTTPClientCircuitHalfOpened, "Circuits half opened."),
+		circuitClosed:       counter(expvarHTTPClientCircuitClosed, "Circuits closed."),
+		circuitRejected:     counter(expvarHTTPClientCircuitRejected, "Requests rejected by an open circuit."),
+		concurrencyLimit:    gauge(expvarHTTPClientConcurrencyLimit, "Current adaptive concurrency limit.", "limiter"),
+		concurrencyInFlight: gauge(expvarHTTPClientConcurrencyInFlight, "Requests in flight through the concurrency limiter.", "limiter"),
+		concurrencyQueue:    gauge(expvarHTTPClientConcurrencyQueue, "Requests waiting for a concurrency limiter slot.", "limiter"),
+		concurrencyRejected: counter(expvarHTTPClientConcurrencyRejected, "Requests rejected by the concurrency limiter.", "limiter"),
+		coalesced:           counter(expvarHTTPClientCoalesced, "Requests served by a coalesced upstream call."),
+		attemptDuration: p.NewHistogram(metrics.Desc{
+			Name:    expvarHTTPClientAttemptDuration,
+			Help:    "Duration of every attempt sent by the HTTP client in seconds.",
+			Labels:  []string{"client", "method", "route", "status_class"},
+			Buckets: config.LatencyBuckets,
+		}),
+		calls: p.NewCounter(metrics.Desc{
+			Name:   expvarHTTPClientCalls,
+			Help:   "Calls made with Client.Do by outcome.",
+			Labels: []string{"client", "method", "route", "outcome"},
+		}),
+		callDuration: p.NewHistogram(metrics.Desc{
+			Name:    expvarHTTPClientCallDuration,
+			Help:    "Duration of Client.Do calls including retries in seconds.",
+			Labels:  []string{"client", "method", "route", "outcome"},
+			Buckets: config.LatencyBuckets,
+		}),
+		callRetries: p.NewCounter(metrics.Desc{
+			Name:   expvarHTTPClientCallRetries,
+			Help:   "Retries made by Client.Do calls.",
+			Labels: []string{"client", "method", "route"},
+		}),
+		responseSize: p.NewHistogram(metrics.Desc{
+			Name:    expvarHTTPClientResponseSize,
+			Help:    "Size of the response bodies read by Client.Do in bytes.",
+			Labels:  []string{"client", "method", "route"},
+			Buckets: []float64{100, 1000, 10000, 100000, 1000000, 10000000},
+		}),
+		compressedSize: p.NewHistogram(metrics.Desc{
+			Name:    expvarHTTPClientCompressedResponseSize,
+			Help:    "Encoded size of the compressed response bodies read by Client.Do in bytes.",
+			Labels:  []string{"client", "method", "route", "encoding"},
+			Buckets: []float64{100, 1000, 10000, 100000, 1000000, 10000000},
+		}),
+		batches: counter(expvarHTTPClientBatches, "Batches run by BatchExecutor.", "client"),
+		batchCalls: p.NewCounter(metrics.Desc{
+			Name:   expvarHTTPClientBatchCalls,
+			Help:   "Calls of the batches run by BatchExecutor by result.",
+			Labels: []string{"client", "result"},
+		}),
+		batchDuration: p.NewHistogram(metrics.Desc{
+			Name:    expvarHTTPClientBatchDuration,
+			Help:    "Duration of the batches run by BatchExecutor in seconds.",
+			Labels:  []string{"client"},
+			Buckets: config.LatencyBuckets,
+		}),
+	})
+}
+
+// metricsInstruments returns the current instruments.
+func metricsInstruments() *instruments {
+	return currentInstruments.Load()
+}
+
+// statusClass returns the label value of a response status, such as 2xx, or
+// error when there is no response.
+func statusClass(resp *http.Response, err error) string {
+	if err != nil || resp == nil {
+		return "error"
+	}
+	return strconv.Itoa(resp.StatusCode/100) + "xx"
 }
 
 // InstrumentHTTPRequest adds the instrumentation hooks to the http.Request
-// to track the timings associated with making a request.
-// The info is logged via logrus with the default fields from the context/ei_logging and expvar
-// Call the returned done function after response is created
-// or after request finishes downloading if you want full
-// timing information. This adds to counters tracking new and
-// reused httpClient connections and a histogram tracking
-// the time to prepare a connection (dns+tcp+tls) in ms.
-// The prep time would be 0 when a connection is reused.
-// Takes a logEntry to structured logging with the request.
+// to track the timings associated with making a request. The returned
+// request keeps the context of req, so its deadline, cancellation and values
+// still apply. Call the returned done function, which is never nil, after the
+// response is created or after the request finishes downloading. It adds to
+// the counters tracking new and reused httpClient connections and to the
+// histogram of the time to prepare a connection (dns+tcp+tls) in ms, which is
+// 0 when a connection is reused. Nothing is recorded when the request never
+// got a connection.
 //
 //	req, err := http.NewRequest(http.MethodGet, url, nil /* body */)
 //	if err != nil {
 //	  return err
 //	}
-//	logEntry := logging.WithField("mykey", "myvalue")
-//	req, requestDone := InstrumentHTTPRequestWithLogEntry(req)
+//	req, requestDone := InstrumentHTTPRequest(req)
 //	res, err := httpClient.Do(req)
 //	defer requestDone()
 func InstrumentHTTPRequest(req *http.Request) (*http.Request, func()) {
-	httpClientReusedConnCounter.Add(1.0)
-	httpClientConnPrepHistogram.Observe(0.0) // reused conn, 0 preparation time
-	return req.WithContext(context.Background()), nil
+	var mu sync.Mutex
+	var prepStart, prepEnd time.Time
+	reused := false
+	gotConn := false
+
+	markStart := func() {
+		mu.Lock()
+		if prepStart.IsZero() {
+			prepStart = time.Now()
+		}
+		mu.Unlock()
+	}
+	markEnd := func() {
+		mu.Lock()
+		prepEnd = time.Now()
+		mu.Unlock()
+	}
+
+	trace := &httptrace.ClientTrace{
+		DNSStart:     func(httptrace.DNSStartInfo) { markStart() },
+		ConnectStart: func(string, string) { markStart() },
+		ConnectDone:  func(string, string, error) { markEnd() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			markEnd()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			gotConn = true
+			reused = info.Reused
+			mu.Unlock()
+		},
+	}
+
+	var once sync.Once
+	done := func() {
+		once.Do(func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if !gotConn {
+				return // request never reached a connection
+			}
+			m := metricsInstruments()
+			if reused {
+				m.reusedConns.Add(1.0)
+				m.connPrep.Observe(0.0) // reused conn, 0 preparation time
+				return
+			}
+			m.newConns.Add(1.0)
+			var prep time.Duration
+			if !prepStart.IsZero() && prepEnd.After(prepStart) {
+				prep = prepEnd.Sub(prepStart)
+			}
+			m.connPrep.Observe(float64(prep) / float64(time.Millisecond))
+		})
+	}
+
+	ctx := httptrace.WithClientTrace(req.Context(), trace)
+	return req.WithContext(ctx), done
 }
 
 // AddExpVarHandlerToRouter adds the expvar handler to the root
@@ -58,3 +282,13 @@ func InstrumentHTTPRequest(req *http.Request) (*http.Request, func()) {
 func AddExpVarHandlerToRouter(router *mux.Router, urlPath string) {
 	router.HandleFunc(urlPath, expvar.Handler().ServeHTTP).Methods(http.MethodGet)
 }
+
+// AddPrometheusHandlerToRouter serves the metrics of registry in the
+// Prometheus text format at urlPath on the router.
+//
+//	registry := metrics.NewPrometheusRegistry()
+//	ConfigureMetrics(MetricsConfig{Provider: registry})
+//	AddPrometheusHandlerToRouter(router, "/metrics", registry)
+func AddPrometheusHandlerToRouter(router *mux.Router, urlPath string, registry *metrics.PrometheusRegistry) {
+	router.Handle(urlPath, registry.Handler()).Methods(http.MethodGet)
+}
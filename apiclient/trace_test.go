@@ -1,12 +1,18 @@
 package apiclient
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
 
+	"github.com/CodeNamor/http/metrics"
 	"github.com/gorilla/mux"
 	"github.com/kr/pretty"
+	"github.com/stretchr/testify/require"
 )
 
 func mockGetClient() *http.Client {
@@ -15,7 +21,13 @@ func mockGetClient() *http.Client {
 }
 
 func ExampleInstrumentHTTPRequest() {
-	url := "https://postman-echo.com/get"
+	// in a real application, url is the address of the upstream
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+	url := ts.URL + "/get"
+
 	httpClient := mockGetClient()
 	req, err := http.NewRequest(http.MethodGet, url, nil /* body */)
 	if err != nil {
@@ -43,9 +55,71 @@ func ExampleInstrumentHTTPRequest() {
 	}
 
 	pretty.Println("result:", string(bytes))
+	// Output: result: hello
 }
 
 func ExampleAddExpVarHandlerToRouter() {
 	router := mux.NewRouter().StrictSlash(true)
 	AddExpVarHandlerToRouter(router, "/debug/vars")
 }
+
+func TestConfigureMetrics_prometheus(t *testing.T) {
+	registry := metrics.NewPrometheusRegistry()
+	ConfigureMetrics(MetricsConfig{Provider: registry, LatencyBuckets: []float64{1, 5}})
+	defer ConfigureMetrics(MetricsConfig{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	c.Name = "orders"
+	_, err = c.Get(WithRouteTemplate(context.Background(), "/orders/{id}"), "/orders/1", nil)
+	require.NoError(t, err)
+
+	var b strings.Builder
+	require.NoError(t, registry.Write(&b))
+	require.Contains(t, b.String(), `http_client_attempt_duration_bucket{client="orders",method="GET",route="/orders/{id}",status_class="4xx",le="5"} 1`)
+	require.Contains(t, b.String(), "http_client_new_connections_total 1\n")
+}
+
+func TestInstrumentHTTPRequest(t *testing.T) {
+	registry := metrics.NewPrometheusRegistry()
+	ConfigureMetrics(MetricsConfig{Provider: registry})
+	defer ConfigureMetrics(MetricsConfig{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "value")
+	send := func() {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		req, done := InstrumentHTTPRequest(req)
+		require.NotNil(t, done)
+		require.Equal(t, "value", req.Context().Value(key{}), "the request context is kept")
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		require.NoError(t, resp.Body.Close())
+		done()
+		done()
+	}
+	send()
+	send()
+
+	// a request without a connection records nothing
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	require.NoError(t, err)
+	_, done := InstrumentHTTPRequest(req)
+	done()
+
+	var b strings.Builder
+	require.NoError(t, registry.Write(&b))
+	require.Contains(t, b.String(), "http_client_new_connections_total 1\n")
+	require.Contains(t, b.String(), "http_client_reused_connections_total 1\n")
+	require.Contains(t, b.String(), "http_client_connection_preparation_count 2\n")
+}
@@ -0,0 +1,104 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// StatusResumeIncomplete is the status resumable upload protocols use to
+// acknowledge a chunk before the upload is complete.
+const StatusResumeIncomplete = 308
+
+// UploadConfig configures Client.Upload. Zero values are replaced by the
+// defaults noted on each field.
+type UploadConfig struct {
+	// Method defaults to PUT.
+	Method string
+	// ChunkSize is the size of every request but the last one, defaults to
+	// 8MB. Every chunk is held in memory while it is sent.
+	ChunkSize int
+	// ContentType of the uploaded data, defaults to application/octet-stream.
+	ContentType string
+	// Progress is called after every acknowledged chunk with the bytes sent
+	// so far and the total size or -1 when it is not known.
+	Progress func(sent, total int64)
+}
+
+// Upload sends size bytes read from src to urlPath in chunks, one request
+// per chunk made with Do so the authorization, retries and metrics of the
+// client apply. Every chunk carries a Content-Range header such as
+// "bytes 0-8388607/20000000", with * as total when size is -1. The upstream
+// acknowledges chunks with a 2xx or 308 status, the response to the last
+// chunk is returned. Chunk bodies can be rewound, so the retry client can
+// send a chunk again.
+//
+//	file, _ := os.Open(path)
+//	info, _ := file.Stat()
+//	resp, err := client.Upload(ctx, "/uploads/"+id, file, info.Size(), UploadConfig{
+//	  Progress: func(sent, total int64) { log.Printf("%d/%d", sent, total) },
+//	})
+func (c *Client) Upload(ctx context.Context, urlPath string, src io.Reader, size int64, config UploadConfig, opts ...RequestOption) (*Response, error) {
+	if config.Method == "" {
+		config.Method = http.MethodPut
+	}
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = defaultPartSize
+	}
+	if config.ContentType == "" {
+		config.ContentType = "application/octet-stream"
+	}
+	options := newRequestOptions(opts)
+	total := "*"
+	if size >= 0 {
+		total = strconv.FormatInt(size, 10)
+	}
+
+	chunk := make([]byte, config.ChunkSize)
+	var sent int64
+	for {
+		n, err := io.ReadFull(src, chunk)
+		if size >= 0 && sent+int64(n) > size {
+			n = int(size - sent) // the source is longer than size
+		}
+		last := err == io.EOF || err == io.ErrUnexpectedEOF || (size >= 0 && sent+int64(n) >= size)
+		if err != nil && !last {
+			return nil, err
+		}
+		if last && size >= 0 && sent+int64(n) < size {
+			return nil, fmt.Errorf("upload source ended after %d of %d bytes: %w", sent+int64(n), size, io.ErrUnexpectedEOF)
+		}
+		if last && size < 0 {
+			total = strconv.FormatInt(sent+int64(n), 10)
+		}
+
+		options.body, options.contentType, options.encoder = bytes.NewReader(chunk[:n]), config.ContentType, nil
+		request, err := c.newRequest(config.Method, urlPath, options)
+		if err != nil {
+			c.log(ctx, LogLevelError, "failed to create upload request", LogFields{LogFieldError: err})
+			return nil, err
+		}
+		if n > 0 {
+			request.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", sent, sent+int64(n)-1, total))
+		} else {
+			request.Header.Set("Content-Range", "bytes */"+total)
+		}
+		resp, err := c.execute(ctx, request, options)
+		if err != nil {
+			return resp, err
+		}
+		if resp.StatusCode != StatusResumeIncomplete && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+			return resp, c.unexpectedStatus(request, resp, nil)
+		}
+		sent += int64(n)
+		if config.Progress != nil {
+			config.Progress(sent, size)
+		}
+		if last {
+			return resp, nil
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Upload(t *testing.T) {
+	var ranges []string
+	var received strings.Builder
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "text/plain", r.Header.Get("Content-Type"))
+		ranges = append(ranges, r.Header.Get("Content-Range"))
+		body, _ := ioutil.ReadAll(r.Body)
+		received.Write(body)
+		if strings.HasSuffix(r.URL.Path, "/fail") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !strings.HasSuffix(r.Header.Get("Content-Range"), "-24/25") && !strings.HasSuffix(r.Header.Get("Content-Range"), "/20") {
+			w.WriteHeader(StatusResumeIncomplete)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+
+	var progress []int64
+	resp, err := c.Upload(context.Background(), "/uploads/1", strings.NewReader(strings.Repeat("a", 25)), 25, UploadConfig{
+		ChunkSize:   10,
+		ContentType: "text/plain",
+		Progress:    func(sent, total int64) { progress = append(progress, sent) },
+	})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, []string{"bytes 0-9/25", "bytes 10-19/25", "bytes 20-24/25"}, ranges)
+	require.Equal(t, []int64{10, 20, 25}, progress)
+	require.Equal(t, strings.Repeat("a", 25), received.String())
+
+	// of unknown size, ending at a chunk boundary
+	ranges = nil
+	resp, err = c.Upload(context.Background(), "/uploads/2", strings.NewReader(strings.Repeat("b", 20)), -1, UploadConfig{ChunkSize: 10, ContentType: "text/plain"})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, []string{"bytes 0-9/*", "bytes 10-19/*", "bytes */20"}, ranges)
+
+	_, err = c.Upload(context.Background(), "/uploads/3", strings.NewReader("short"), 25, UploadConfig{ContentType: "text/plain"})
+	require.Error(t, err)
+
+	_, err = c.Upload(context.Background(), "/uploads/fail", strings.NewReader("abc"), 3, UploadConfig{ContentType: "text/plain"})
+	var unexpected *UnexpectedStatusError
+	require.True(t, errors.As(err, &unexpected))
+}
@@ -0,0 +1,454 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WireDumpConfig turns on dumping of the requests sent and the responses
+// received by a Client, for debugging an integration. Set it on
+// Client.WireDump, or per call with WithWireDump. Headers, URLs and bodies
+// are redacted with the Redactor of the Client before they reach the sink.
+//
+//	ring := NewRingBufferWireDumpSink(100)
+//	client.WireDump = &WireDumpConfig{Sink: ring, MaxBodyBytes: 16 << 10}
+//	router.Handle("/admin/wiredump", ring.Handler())
+type WireDumpConfig struct {
+	// Sink receives the dumps, it defaults to the logger of the Client.
+	Sink WireDumpSink
+	// MaxBodyBytes truncates the dumped bodies, defaults to 4096. Bodies
+	// are still sent and received whole.
+	MaxBodyBytes int
+}
+
+// WireDump is the record of one request and its response.
+type WireDump struct {
+	Time           time.Time   `json:"time"`
+	Client         string      `json:"client"`
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	Proto          string      `json:"proto,omitempty"`
+	RequestHeader  http.Header `json:"requestHeader"`
+	RequestBody    string      `json:"requestBody,omitempty"`
+	StatusCode     int         `json:"statusCode,omitempty"`
+	Status         string      `json:"status,omitempty"`
+	ResponseHeader http.Header `json:"responseHeader,omitempty"`
+	ResponseBody   string      `json:"responseBody,omitempty"`
+	Truncated      bool        `json:"truncated,omitempty"`
+	Error          string      `json:"error,omitempty"`
+	TimeToHeaders  Duration    `json:"timeToHeaders"`
+	Duration       Duration    `json:"duration"`
+}
+
+// Duration is a time.Duration encoded as a string like 1.5ms in JSON.
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// WireDumpSink receives wire dumps. Implementations must be safe for
+// concurrent use.
+type WireDumpSink interface {
+	Dump(ctx context.Context, dump *WireDump)
+}
+
+// WithWireDump returns a context which dumps the calls made with it using
+// config, overriding Client.WireDump. A nil config turns dumping off.
+func WithWireDump(ctx context.Context, config *WireDumpConfig) context.Context {
+	return context.WithValue(ctx, wireDumpKey, config)
+}
+
+func (c *Client) wireDump(ctx context.Context) *WireDumpConfig {
+	if config, ok := ctx.Value(wireDumpKey).(*WireDumpConfig); ok {
+		return config
+	}
+	return c.WireDump
+}
+
+func (c *Client) redactor() *Redactor {
+	if c.Redactor != nil {
+		return c.Redactor
+	}
+	return defaultRedactor
+}
+
+func (config *WireDumpConfig) maxBody() int {
+	if config.MaxBodyBytes <= 0 {
+		return 4096
+	}
+	return config.MaxBodyBytes
+}
+
+// maxRedactableBody is how much of a body is captured so that it can be
+// redacted as a whole before it is truncated.
+const maxRedactableBody = 1 << 20
+
+func (config *WireDumpConfig) captureLimit() int {
+	if config.maxBody() > maxRedactableBody {
+		return config.maxBody() + 1
+	}
+	return maxRedactableBody + 1
+}
+
+// wireDumper captures one request and its response as they go over the wire.
+type wireDumper struct {
+	ctx      context.Context
+	config   *WireDumpConfig
+	redactor *Redactor
+	dump     *WireDump
+	request  *limitedBuffer
+	start    time.Time
+	once     sync.Once
+}
+
+// startWireDump records the request and returns it with its body wrapped so
+// the bytes sent are captured.
+func (c *Client) startWireDump(ctx context.Context, config *WireDumpConfig, request *http.Request) (*wireDumper, *http.Request) {
+	d := &wireDumper{
+		ctx:      ctx,
+		config:   config,
+		redactor: c.redactor(),
+		request:  &limitedBuffer{limit: config.captureLimit()},
+		start:    time.Now(),
+		dump: &WireDump{
+			Time:          time.Now(),
+			Client:        c.name(),
+			Method:        request.Method,
+			URL:           c.redactor().URL(request.URL),
+			Proto:         request.Proto,
+			RequestHeader: c.redactor().Header(request.Header),
+		},
+	}
+	if request.Host != "" && request.Host != request.URL.Host {
+		d.dump.RequestHeader.Set("Host", request.Host)
+	}
+	if request.Body != nil && request.Body != http.NoBody {
+		if request.GetBody != nil {
+			if body, err := request.GetBody(); err == nil {
+				_, _ = io.Copy(d.request, body)
+				body.Close()
+			}
+		} else {
+			request.Body = &captureBody{ReadCloser: request.Body, buf: d.request}
+		}
+	}
+	return d, request
+}
+
+// finish records the response, or err, and returns the response with its
+// body wrapped so the dump is emitted once the body is closed.
+func (d *wireDumper) finish(response *http.Response, err error) *http.Response {
+	d.dump.TimeToHeaders = Duration(time.Since(d.start))
+	if err != nil {
+		d.dump.Error = d.redactor.text(err.Error())
+		d.emit()
+		return response
+	}
+	d.dump.Proto = response.Proto
+	d.dump.StatusCode = response.StatusCode
+	d.dump.Status = response.Status
+	d.dump.ResponseHeader = d.redactor.Header(response.Header)
+	if response.Body == nil || response.Body == http.NoBody {
+		d.emit()
+		return response
+	}
+	response.Body = &wireResponseBody{
+		ReadCloser: response.Body,
+		buf:        &limitedBuffer{limit: d.config.captureLimit()},
+		dumper:     d,
+		header:     response.Header,
+	}
+	return response
+}
+
+func (d *wireDumper) emit() {
+	d.once.Do(func() {
+		d.dump.Duration = Duration(time.Since(d.start))
+		var truncated bool
+		d.dump.RequestBody, truncated = d.requestBody()
+		d.dump.Truncated = d.dump.Truncated || truncated
+		sink := d.config.Sink
+		if sink == nil {
+			sink = loggerWireDumpSink{}
+		}
+		sink.Dump(d.ctx, d.dump)
+	})
+}
+
+// requestBody returns the captured request body, decoded first when it was
+// compressed so it can be redacted.
+func (d *wireDumper) requestBody() (string, bool) {
+	captured := d.request.Bytes()
+	if encoding := d.dump.RequestHeader.Get("Content-Encoding"); encoding != "" && len(captured) < d.config.captureLimit() {
+		decoded, err := decodeBytes(encoding, captured, d.config.captureLimit())
+		if err != nil {
+			return "[" + encoding + " body could not be decoded]", false
+		}
+		captured = decoded
+	}
+	return d.body(d.dump.RequestHeader.Get("Content-Type"), captured)
+}
+
+// body redacts and truncates a captured body. Bodies too large to be
+// captured whole can not be redacted reliably and are left out.
+func (d *wireDumper) body(contentType string, captured []byte) (string, bool) {
+	if len(captured) >= d.config.captureLimit() {
+		return "[body too large to redact]", true
+	}
+	captured = d.redactor.Body(contentType, captured)
+	if limit := d.config.maxBody(); len(captured) > limit {
+		return string(captured[:limit]), true
+	}
+	return string(captured), false
+}
+
+// limitedBuffer keeps the first limit bytes written to it. The transport
+// writes request bodies from its own goroutine, so it is locked.
+type limitedBuffer struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.limit - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// Bytes returns a copy of the kept bytes.
+func (b *limitedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+// captureBody copies what the transport reads from a request body.
+type captureBody struct {
+	io.ReadCloser
+	buf *limitedBuffer
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	_, _ = b.buf.Write(p[:n])
+	return n, err
+}
+
+// wireResponseBody copies the response body as it is read and emits the
+// dump when it is closed.
+type wireResponseBody struct {
+	io.ReadCloser
+	buf    *limitedBuffer
+	dumper *wireDumper
+	header http.Header
+}
+
+func (b *wireResponseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	_, _ = b.buf.Write(p[:n])
+	return n, err
+}
+
+func (b *wireResponseBody) Close() error {
+	err := b.ReadCloser.Close()
+	var truncated bool
+	b.dumper.dump.ResponseBody, truncated = b.dumper.body(b.header.Get("Content-Type"), b.buf.Bytes())
+	b.dumper.dump.Truncated = b.dumper.dump.Truncated || truncated
+	b.dumper.emit()
+	return err
+}
+
+// Body redacts a request or response body: the JSON fields of JSON bodies
+// and the QueryParams of form encoded bodies. Other bodies are returned
+// unchanged.
+func (r *Redactor) Body(contentType string, body []byte) []byte {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" {
+		u := url.URL{RawQuery: string(body)}
+		redacted := r.URL(&u)
+		if len(redacted) > 0 && redacted[0] == '?' {
+			return []byte(redacted[1:])
+		}
+		return body
+	}
+	return r.JSON(body)
+}
+
+// loggerWireDumpSink logs dumps at debug level with the logger of the Client.
+type loggerWireDumpSink struct{}
+
+func (loggerWireDumpSink) Dump(ctx context.Context, dump *WireDump) {
+	logAt(ctx, LogLevelDebug, "APIClient wire dump\n"+dump.String(), LogFields{
+		LogFieldMethod:   dump.Method,
+		LogFieldStatus:   dump.StatusCode,
+		LogFieldDuration: time.Duration(dump.Duration),
+	})
+}
+
+// NewLoggerWireDumpSink returns a sink writing every dump as a debug entry to
+// logger. The default sink does the same with the logger of the Client.
+func NewLoggerWireDumpSink(logger Logger) WireDumpSink {
+	return &customLoggerWireDumpSink{logger: logger}
+}
+
+type customLoggerWireDumpSink struct {
+	logger Logger
+}
+
+func (s *customLoggerWireDumpSink) Dump(ctx context.Context, dump *WireDump) {
+	s.logger.Log(ctx, LogLevelDebug, "APIClient wire dump\n"+dump.String(), LogFields{
+		LogFieldClient:   dump.Client,
+		LogFieldMethod:   dump.Method,
+		LogFieldStatus:   dump.StatusCode,
+		LogFieldDuration: time.Duration(dump.Duration),
+	})
+}
+
+// WriterWireDumpSink writes dumps as text to a writer, such as a file.
+type WriterWireDumpSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterWireDumpSink creates a sink writing to w.
+func NewWriterWireDumpSink(w io.Writer) *WriterWireDumpSink {
+	return &WriterWireDumpSink{w: w}
+}
+
+// NewFileWireDumpSink creates a sink appending to the file at path. Close
+// the returned file when done.
+func NewFileWireDumpSink(path string) (*WriterWireDumpSink, *os.File, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewWriterWireDumpSink(file), file, nil
+}
+
+// Dump implements WireDumpSink.
+func (s *WriterWireDumpSink) Dump(ctx context.Context, dump *WireDump) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, _ = io.WriteString(s.w, dump.String()+"\n")
+}
+
+// RingBufferWireDumpSink keeps the most recent dumps in memory, it can be
+// queried from an admin endpoint with Handler.
+type RingBufferWireDumpSink struct {
+	mu    sync.Mutex
+	dumps []*WireDump
+	next  int
+	full  bool
+}
+
+// NewRingBufferWireDumpSink creates a sink keeping the last size dumps.
+func NewRingBufferWireDumpSink(size int) *RingBufferWireDumpSink {
+	if size < 1 {
+		size = 1
+	}
+	return &RingBufferWireDumpSink{dumps: make([]*WireDump, size)}
+}
+
+// Dump implements WireDumpSink.
+func (s *RingBufferWireDumpSink) Dump(ctx context.Context, dump *WireDump) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dumps[s.next] = dump
+	s.next = (s.next + 1) % len(s.dumps)
+	if s.next == 0 {
+		s.full = true
+	}
+}
+
+// Dumps returns the kept dumps, oldest first.
+func (s *RingBufferWireDumpSink) Dumps() []*WireDump {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.full {
+		return append([]*WireDump(nil), s.dumps[:s.next]...)
+	}
+	return append(append([]*WireDump(nil), s.dumps[s.next:]...), s.dumps[:s.next]...)
+}
+
+// Handler serves the kept dumps as a JSON array, oldest first. The limit
+// query parameter returns only the most recent ones and client filters by
+// client name.
+func (s *RingBufferWireDumpSink) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dumps := s.Dumps()
+		if client := r.URL.Query().Get("client"); client != "" {
+			filtered := dumps[:0]
+			for _, dump := range dumps {
+				if dump.Client == client {
+					filtered = append(filtered, dump)
+				}
+			}
+			dumps = filtered
+		}
+		if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit >= 0 && limit < len(dumps) {
+			dumps = dumps[len(dumps)-limit:]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(dumps)
+	})
+}
+
+// String formats the dump like the request and response appear on the wire,
+// prefixed with > and <.
+func (dump *WireDump) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "* %s %s %s %s (%s to headers, %s total)\n", dump.Time.Format(time.RFC3339Nano), dump.Client, dump.Method, dump.URL,
+		time.Duration(dump.TimeToHeaders), time.Duration(dump.Duration))
+	fmt.Fprintf(&b, "> %s %s\n", dump.Method, dump.URL)
+	writeHeader(&b, "> ", dump.RequestHeader)
+	if dump.RequestBody != "" {
+		fmt.Fprintf(&b, ">\n> %s\n", dump.RequestBody)
+	}
+	if dump.Error != "" {
+		fmt.Fprintf(&b, "! %s\n", dump.Error)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "< %s %s\n", dump.Proto, dump.Status)
+	writeHeader(&b, "< ", dump.ResponseHeader)
+	if dump.ResponseBody != "" {
+		fmt.Fprintf(&b, "<\n< %s\n", dump.ResponseBody)
+	}
+	if dump.Truncated {
+		b.WriteString("* body truncated\n")
+	}
+	return b.String()
+}
+
+func writeHeader(b *bytes.Buffer, prefix string, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			fmt.Fprintf(b, "%s%s: %s\n", prefix, name, value)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_WireDump(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=abc")
+		_, _ = w.Write([]byte(`{"id":1,"access_token":"tok","note":"` + strings.Repeat("x", 100) + `"}`))
+	}))
+	defer ts.Close()
+
+	ring := NewRingBufferWireDumpSink(2)
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+	c.Name = "partner"
+	c.Authenticator = BearerAuth(StaticSecret("s3cret"))
+	c.WireDump = &WireDumpConfig{Sink: ring, MaxBodyBytes: 64}
+
+	request, err := http.NewRequest(http.MethodPost, ts.URL+"/orders?api_key=k", strings.NewReader("user=a&password=p"))
+	require.NoError(t, err)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, err = c.Do(context.Background(), request)
+	require.NoError(t, err)
+
+	dumps := ring.Dumps()
+	require.Len(t, dumps, 1)
+	dump := dumps[0]
+	require.Equal(t, "partner", dump.Client)
+	require.Equal(t, ts.URL+"/orders?api_key=%5BREDACTED%5D", dump.URL)
+	require.Equal(t, "[REDACTED]", dump.RequestHeader.Get("Authorization"))
+	require.Equal(t, "password=%5BREDACTED%5D&user=a", dump.RequestBody)
+	require.Equal(t, http.StatusOK, dump.StatusCode)
+	require.Equal(t, "[REDACTED]", dump.ResponseHeader.Get("Set-Cookie"))
+	require.True(t, dump.Truncated)
+	require.Len(t, dump.ResponseBody, 64)
+	require.Contains(t, dump.ResponseBody, `"access_token":"[REDACTED]"`)
+	require.NotContains(t, dump.String(), "s3cret")
+	require.Greater(t, dump.Duration, Duration(0))
+
+	// the ring buffer keeps the most recent dumps only
+	for i := 0; i < 3; i++ {
+		_, err = c.Get(context.Background(), "/", nil)
+		require.NoError(t, err)
+	}
+	require.Len(t, ring.Dumps(), 2)
+
+	recorder := httptest.NewRecorder()
+	ring.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/admin/wiredump?limit=1&client=partner", nil))
+	var served []map[string]interface{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &served))
+	require.Len(t, served, 1)
+	require.Equal(t, "GET", served[0]["method"])
+}
+
+func TestWithWireDump_overridesClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("pong"))
+	}))
+	defer ts.Close()
+
+	c, err := InitClient(newClient(), ts.URL, "test", false, "")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	ctx := WithWireDump(context.Background(), &WireDumpConfig{Sink: NewWriterWireDumpSink(&buf)})
+	_, err = c.Get(ctx, "/ping", nil)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "> GET "+ts.URL+"/ping\n")
+	require.Contains(t, buf.String(), "< HTTP/1.1 200 OK\n")
+	require.Contains(t, buf.String(), "<\n< pong\n")
+
+	// a nil config turns dumping off for the call
+	ring := NewRingBufferWireDumpSink(1)
+	c.WireDump = &WireDumpConfig{Sink: ring}
+	_, err = c.Get(WithWireDump(context.Background(), nil), "/ping", nil)
+	require.NoError(t, err)
+	require.Empty(t, ring.Dumps())
+}
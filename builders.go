@@ -99,6 +99,7 @@ func (b *clientBuilder) Build() http.Client {
 	httpClient := http.Client{
 		Timeout: time.Second * time.Duration(b.timeout),
 		Transport: &http.Transport{
+			DisableCompression: b.disableCompression,
 			TLSClientConfig: &tls.Config{
 				InsecureSkipVerify: b.tlsInsecureSkipVerify,
 				RootCAs:            getCertPool(b.tlsInsecureSkipVerify, b.pemCertificates),
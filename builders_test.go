@@ -12,6 +12,13 @@ func Test_ClientBuilder_check_defaults(t *testing.T) {
 	c := NewClientBuilder().Build()
 
 	assert.Equal(t, time.Duration(100)*time.Second, c.Timeout)
+	assert.False(t, c.Transport.(*http.Transport).DisableCompression)
+}
+
+func Test_ClientBuilder_DisableCompression(t *testing.T) {
+	c := NewClientBuilder().DisableCompression(true).Build()
+
+	assert.True(t, c.Transport.(*http.Transport).DisableCompression)
 }
 
 func Test_RequestBuilder(t *testing.T) {
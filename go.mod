@@ -1,6 +1,6 @@
 module github.com/CodeNamor/http
 
-go 1.20
+go 1.21
 
 require (
 	github.com/go-kit/kit v0.13.0
@@ -9,7 +9,6 @@ require (
 	github.com/gorilla/mux v1.8.1
 	github.com/kr/pretty v0.3.1
 	github.com/pkg/errors v0.9.1
-	github.com/sethgrid/pester v1.2.0
 	github.com/sirupsen/logrus v1.9.3
 	github.com/stretchr/testify v1.9.0
 	github.com/CodeNamor/custom_logging v0.1.1
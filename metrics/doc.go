@@ -0,0 +1,27 @@
+/*
+Package metrics is a small metrics abstraction with an expvar backend and a
+Prometheus text exposition backend. apiclient records all of its metrics
+through a Provider, see apiclient.ConfigureMetrics.
+
+# Labels
+
+Metrics declare their label names in Desc, and With takes label name and
+value pairs the same way go-kit metrics do. Unlike go-kit, a label name
+missing from Desc or without a value panics, as both are programming errors.
+
+	requests := provider.NewCounter(metrics.Desc{
+	  Name:   "HTTPClientRequests",
+	  Help:   "Requests sent by the API client.",
+	  Labels: []string{"client", "method"},
+	})
+	requests.With("client", "orders", "method", http.MethodGet).Add(1)
+
+# Exposing metrics
+
+Expvar metrics are served by apiclient.AddExpVarHandlerToRouter, Prometheus
+metrics by PrometheusRegistry.Handler.
+
+	registry := metrics.NewPrometheusRegistry()
+	router.Handle("/metrics", registry.Handler())
+*/
+package metrics
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"expvar"
+	"sync"
+
+	kitexpvar "github.com/go-kit/kit/metrics/expvar"
+)
+
+// NewExpvarProvider creates a Provider publishing to expvar. Metrics without
+// labels are published as a number under their name. Counters and gauges
+// with labels are published as a map under their name keyed by the label
+// names and values. Histograms publish the 50th, 90th, 95th and 99th
+// percentile of every series, named after the metric and its labels.
+func NewExpvarProvider() Provider {
+	return expvarProvider{}
+}
+
+type expvarProvider struct{}
+
+// expvar panics when a name is published twice, so the metrics are shared by
+// every expvar provider.
+var expvarRegistry = struct {
+	sync.Mutex
+	numbers    map[string]*expvarNumber
+	histograms map[string]*expvarHistogram
+}{
+	numbers:    map[string]*expvarNumber{},
+	histograms: map[string]*expvarHistogram{},
+}
+
+func (expvarProvider) NewCounter(desc Desc) Counter {
+	return &expvarCounter{family: numberFamily(desc)}
+}
+
+func (expvarProvider) NewGauge(desc Desc) Gauge {
+	return &expvarGauge{family: numberFamily(desc)}
+}
+
+func (expvarProvider) NewHistogram(desc Desc) Histogram {
+	expvarRegistry.Lock()
+	defer expvarRegistry.Unlock()
+	family, ok := expvarRegistry.histograms[desc.Name]
+	if !ok {
+		family = &expvarHistogram{desc: desc, series: map[string]*kitexpvar.Histogram{}}
+		expvarRegistry.histograms[desc.Name] = family
+	}
+	return &expvarHistogramSeries{family: family}
+}
+
+func numberFamily(desc Desc) *expvarNumber {
+	expvarRegistry.Lock()
+	defer expvarRegistry.Unlock()
+	family, ok := expvarRegistry.numbers[desc.Name]
+	if ok {
+		return family
+	}
+	family = &expvarNumber{desc: desc}
+	if len(desc.Labels) == 0 {
+		family.value = expvar.NewFloat(desc.Name)
+	} else {
+		family.values = expvar.NewMap(desc.Name)
+	}
+	expvarRegistry.numbers[desc.Name] = family
+	return family
+}
+
+type expvarNumber struct {
+	desc   Desc
+	mu     sync.Mutex
+	value  *expvar.Float
+	values *expvar.Map
+}
+
+func (f *expvarNumber) series(labelValues []string) *expvar.Float {
+	if f.value != nil {
+		return f.value
+	}
+	key := f.desc.key(labelValues)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if v, ok := f.values.Get(key).(*expvar.Float); ok {
+		return v
+	}
+	v := new(expvar.Float)
+	f.values.Set(key, v)
+	return v
+}
+
+type expvarCounter struct {
+	family *expvarNumber
+	values []string
+}
+
+func (c *expvarCounter) With(labelValues ...string) Counter {
+	return &expvarCounter{family: c.family, values: c.family.desc.with(c.values, labelValues)}
+}
+
+func (c *expvarCounter) Add(delta float64) {
+	c.family.series(c.values).Add(delta)
+}
+
+type expvarGauge struct {
+	family *expvarNumber
+	values []string
+}
+
+func (g *expvarGauge) With(labelValues ...string) Gauge {
+	return &expvarGauge{family: g.family, values: g.family.desc.with(g.values, labelValues)}
+}
+
+func (g *expvarGauge) Set(value float64) {
+	g.family.series(g.values).Set(value)
+}
+
+func (g *expvarGauge) Add(delta float64) {
+	g.family.series(g.values).Add(delta)
+}
+
+type expvarHistogram struct {
+	desc   Desc
+	mu     sync.Mutex
+	series map[string]*kitexpvar.Histogram
+}
+
+type expvarHistogramSeries struct {
+	family *expvarHistogram
+	values []string
+}
+
+func (h *expvarHistogramSeries) With(labelValues ...string) Histogram {
+	return &expvarHistogramSeries{family: h.family, values: h.family.desc.with(h.values, labelValues)}
+}
+
+func (h *expvarHistogramSeries) Observe(value float64) {
+	name := h.family.desc.Name
+	if len(h.family.desc.Labels) > 0 {
+		name += "{" + h.family.desc.key(h.values) + "}"
+	}
+	h.family.mu.Lock()
+	histogram, ok := h.family.series[name]
+	if !ok {
+		histogram = kitexpvar.NewHistogram(name, 50)
+		h.family.series[name] = histogram
+	}
+	h.family.mu.Unlock()
+	histogram.Observe(value)
+}
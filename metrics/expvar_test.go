@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"expvar"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpvarProvider(t *testing.T) {
+	provider := NewExpvarProvider()
+	provider.NewCounter(Desc{Name: "TestExpvarCounter"}).Add(2)
+	// a second provider shares the published variables
+	NewExpvarProvider().NewCounter(Desc{Name: "TestExpvarCounter"}).Add(1)
+	require.Equal(t, "3", expvar.Get("TestExpvarCounter").String())
+
+	gauge := provider.NewGauge(Desc{Name: "TestExpvarGauge", Labels: []string{"client"}})
+	gauge.With("client", "orders").Set(4)
+	gauge.With("client", "users").Set(1)
+	require.Equal(t, `{"client=orders": 4, "client=users": 1}`, expvar.Get("TestExpvarGauge").String())
+
+	provider.NewHistogram(Desc{Name: "TestExpvarHistogram", Labels: []string{"route"}}).With("route", "/a").Observe(10)
+	require.Equal(t, "10", expvar.Get("TestExpvarHistogram{route=/a}.p50").String())
+}
+
+func TestMultiProvider(t *testing.T) {
+	registry := NewPrometheusRegistry()
+	provider := NewMultiProvider(NewExpvarProvider(), registry)
+	provider.NewCounter(Desc{Name: "TestMultiCounter", Labels: []string{"client"}}).With("client", "orders").Add(1)
+
+	require.Equal(t, `{"client=orders": 1}`, expvar.Get("TestMultiCounter").String())
+	var b strings.Builder
+	require.NoError(t, registry.Write(&b))
+	require.Contains(t, b.String(), `test_multi_counter_total{client="orders"} 1`)
+}
@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Counter is a monotonically increasing value.
+type Counter interface {
+	// With returns the counter for the given label name and value pairs,
+	// labels that are not passed keep their current value.
+	With(labelValues ...string) Counter
+	Add(delta float64)
+}
+
+// Gauge is a value that goes up and down.
+type Gauge interface {
+	With(labelValues ...string) Gauge
+	Set(value float64)
+	Add(delta float64)
+}
+
+// Histogram counts observations into buckets.
+type Histogram interface {
+	With(labelValues ...string) Histogram
+	Observe(value float64)
+}
+
+// Desc describes a metric.
+type Desc struct {
+	// Name is the metric name, for example HTTPClientRetries. The Prometheus
+	// backend converts it to snake case.
+	Name string
+	Help string
+	// Labels are the label names of the metric, every series has a value for
+	// each of them.
+	Labels []string
+	// Buckets are the upper bounds of the histogram buckets, in increasing
+	// order. They default to DefaultBuckets.
+	Buckets []float64
+}
+
+// DefaultBuckets suit latencies measured in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Provider creates metrics for a backend. Creating a metric that already
+// exists returns the existing one.
+type Provider interface {
+	NewCounter(desc Desc) Counter
+	NewGauge(desc Desc) Gauge
+	NewHistogram(desc Desc) Histogram
+}
+
+func (d Desc) buckets() []float64 {
+	if len(d.Buckets) == 0 {
+		return DefaultBuckets
+	}
+	return d.Buckets
+}
+
+// with returns a copy of values with the label name and value pairs applied.
+// Unknown label names and a label name without a value panic, like a typo in
+// a metric name would go unnoticed otherwise.
+func (d Desc) with(values []string, labelValues []string) []string {
+	if len(labelValues)%2 != 0 {
+		panic(fmt.Sprintf("metric %s: label %q has no value", d.Name, labelValues[len(labelValues)-1]))
+	}
+	next := make([]string, len(d.Labels))
+	copy(next, values)
+	for i := 0; i < len(labelValues); i += 2 {
+		index := d.labelIndex(labelValues[i])
+		if index < 0 {
+			panic(fmt.Sprintf("metric %s has no label %q", d.Name, labelValues[i]))
+		}
+		next[index] = labelValues[i+1]
+	}
+	return next
+}
+
+func (d Desc) labelIndex(name string) int {
+	for i, label := range d.Labels {
+		if label == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// key joins the label names and values, it identifies a series.
+func (d Desc) key(values []string) string {
+	pairs := make([]string, len(d.Labels))
+	for i, label := range d.Labels {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = label + "=" + value
+	}
+	return strings.Join(pairs, ",")
+}
+
+// NewMultiProvider creates a Provider which records every metric with all of
+// providers, for example both expvar and Prometheus.
+func NewMultiProvider(providers ...Provider) Provider {
+	return multiProvider(providers)
+}
+
+type multiProvider []Provider
+
+func (m multiProvider) NewCounter(desc Desc) Counter {
+	counters := make(multiCounter, len(m))
+	for i, p := range m {
+		counters[i] = p.NewCounter(desc)
+	}
+	return counters
+}
+
+func (m multiProvider) NewGauge(desc Desc) Gauge {
+	gauges := make(multiGauge, len(m))
+	for i, p := range m {
+		gauges[i] = p.NewGauge(desc)
+	}
+	return gauges
+}
+
+func (m multiProvider) NewHistogram(desc Desc) Histogram {
+	histograms := make(multiHistogram, len(m))
+	for i, p := range m {
+		histograms[i] = p.NewHistogram(desc)
+	}
+	return histograms
+}
+
+type multiCounter []Counter
+
+func (m multiCounter) With(labelValues ...string) Counter {
+	next := make(multiCounter, len(m))
+	for i, c := range m {
+		next[i] = c.With(labelValues...)
+	}
+	return next
+}
+
+func (m multiCounter) Add(delta float64) {
+	for _, c := range m {
+		c.Add(delta)
+	}
+}
+
+type multiGauge []Gauge
+
+func (m multiGauge) With(labelValues ...string) Gauge {
+	next := make(multiGauge, len(m))
+	for i, g := range m {
+		next[i] = g.With(labelValues...)
+	}
+	return next
+}
+
+func (m multiGauge) Set(value float64) {
+	for _, g := range m {
+		g.Set(value)
+	}
+}
+
+func (m multiGauge) Add(delta float64) {
+	for _, g := range m {
+		g.Add(delta)
+	}
+}
+
+type multiHistogram []Histogram
+
+func (m multiHistogram) With(labelValues ...string) Histogram {
+	next := make(multiHistogram, len(m))
+	for i, h := range m {
+		next[i] = h.With(labelValues...)
+	}
+	return next
+}
+
+func (m multiHistogram) Observe(value float64) {
+	for _, h := range m {
+		h.Observe(value)
+	}
+}
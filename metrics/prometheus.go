@@ -0,0 +1,280 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// PrometheusRegistry is a Provider keeping metrics in memory and exposing
+// them in the Prometheus text exposition format. Metric names are converted
+// to snake case, so HTTPClientRetries becomes http_client_retries, and
+// counters get the _total suffix.
+//
+//	registry := metrics.NewPrometheusRegistry()
+//	apiclient.ConfigureMetrics(apiclient.MetricsConfig{
+//	  Provider: metrics.NewMultiProvider(metrics.NewExpvarProvider(), registry),
+//	})
+//	router.Handle("/metrics", registry.Handler())
+type PrometheusRegistry struct {
+	mu       sync.Mutex
+	families map[string]*promFamily
+}
+
+type promFamily struct {
+	name    string
+	kind    string
+	desc    Desc
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*promSeries
+}
+
+type promSeries struct {
+	labelValues []string
+	value       float64
+	counts      []uint64 // per bucket, not cumulative
+	sum         float64
+	count       uint64
+}
+
+// NewPrometheusRegistry creates an empty PrometheusRegistry.
+func NewPrometheusRegistry() *PrometheusRegistry {
+	return &PrometheusRegistry{families: map[string]*promFamily{}}
+}
+
+// NewCounter implements Provider.
+func (r *PrometheusRegistry) NewCounter(desc Desc) Counter {
+	return &promCounter{family: r.family(desc, "counter")}
+}
+
+// NewGauge implements Provider.
+func (r *PrometheusRegistry) NewGauge(desc Desc) Gauge {
+	return &promGauge{family: r.family(desc, "gauge")}
+}
+
+// NewHistogram implements Provider.
+func (r *PrometheusRegistry) NewHistogram(desc Desc) Histogram {
+	return &promHistogram{family: r.family(desc, "histogram")}
+}
+
+func (r *PrometheusRegistry) family(desc Desc, kind string) *promFamily {
+	name := PrometheusName(desc.Name)
+	if kind == "counter" && !strings.HasSuffix(name, "_total") {
+		name += "_total"
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if family, ok := r.families[name]; ok {
+		if family.kind != kind {
+			panic(fmt.Sprintf("metric %s registered as %s and %s", name, family.kind, kind))
+		}
+		return family
+	}
+	family := &promFamily{
+		name:   name,
+		kind:   kind,
+		desc:   desc,
+		series: map[string]*promSeries{},
+	}
+	if kind == "histogram" {
+		family.buckets = append([]float64(nil), desc.buckets()...)
+		sort.Float64s(family.buckets)
+	}
+	r.families[name] = family
+	return family
+}
+
+// update runs fn on the series with labelValues under the family lock.
+func (f *promFamily) update(labelValues []string, fn func(s *promSeries)) {
+	key := f.desc.key(labelValues)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &promSeries{labelValues: f.desc.with(labelValues, nil)}
+		if f.kind == "histogram" {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	fn(s)
+}
+
+type promCounter struct {
+	family *promFamily
+	values []string
+}
+
+func (c *promCounter) With(labelValues ...string) Counter {
+	return &promCounter{family: c.family, values: c.family.desc.with(c.values, labelValues)}
+}
+
+func (c *promCounter) Add(delta float64) {
+	if delta < 0 {
+		return // counters only go up
+	}
+	c.family.update(c.values, func(s *promSeries) { s.value += delta })
+}
+
+type promGauge struct {
+	family *promFamily
+	values []string
+}
+
+func (g *promGauge) With(labelValues ...string) Gauge {
+	return &promGauge{family: g.family, values: g.family.desc.with(g.values, labelValues)}
+}
+
+func (g *promGauge) Set(value float64) {
+	g.family.update(g.values, func(s *promSeries) { s.value = value })
+}
+
+func (g *promGauge) Add(delta float64) {
+	g.family.update(g.values, func(s *promSeries) { s.value += delta })
+}
+
+type promHistogram struct {
+	family *promFamily
+	values []string
+}
+
+func (h *promHistogram) With(labelValues ...string) Histogram {
+	return &promHistogram{family: h.family, values: h.family.desc.with(h.values, labelValues)}
+}
+
+func (h *promHistogram) Observe(value float64) {
+	index := sort.SearchFloat64s(h.family.buckets, value)
+	h.family.update(h.values, func(s *promSeries) {
+		if index < len(s.counts) {
+			s.counts[index]++
+		}
+		s.sum += value
+		s.count++
+	})
+}
+
+// Handler returns an http.Handler serving the metrics in the Prometheus text
+// format.
+func (r *PrometheusRegistry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.Write(w)
+	})
+}
+
+// Write writes the metrics in the Prometheus text format, sorted by name and
+// labels.
+func (r *PrometheusRegistry) Write(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*promFamily, 0, len(r.families))
+	for _, family := range r.families {
+		families = append(families, family)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, family := range families {
+		family.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (f *promFamily) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.series) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if f.desc.Help != "" {
+		fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.desc.Help))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, f.labels(s.labelValues, ""), formatFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labels(s.labelValues, formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labels(s.labelValues, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, f.labels(s.labelValues, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, f.labels(s.labelValues, ""), s.count)
+	}
+}
+
+// labels formats the label set of a series, adding le for histogram buckets.
+func (f *promFamily) labels(values []string, le string) string {
+	pairs := make([]string, 0, len(f.desc.Labels)+1)
+	for i, label := range f.desc.Labels {
+		pairs = append(pairs, PrometheusName(label)+`="`+escapeLabelValue(values[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// PrometheusName converts a metric or label name to snake case and replaces
+// characters Prometheus does not allow with underscores.
+func PrometheusName(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			previousLower := i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]))
+			nextLower := i > 0 && i+1 < len(runes) && unicode.IsUpper(runes[i-1]) && unicode.IsLower(runes[i+1])
+			if previousLower || nextLower {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		if r == '_' || r == ':' || (r < unicode.MaxASCII && (unicode.IsLetter(r) || (unicode.IsDigit(r) && i > 0))) {
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteByte('_')
+	}
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
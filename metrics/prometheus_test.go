@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusRegistry(t *testing.T) {
+	registry := NewPrometheusRegistry()
+	requests := registry.NewCounter(Desc{Name: "HTTPClientRequests", Help: "Requests sent.", Labels: []string{"client", "method"}})
+	requests.With("client", "orders", "method", "GET").Add(2)
+	requests.With("client", "orders").With("method", "POST").Add(1)
+	requests.With("client", "orders", "method", "GET").Add(-1) // ignored
+
+	inFlight := registry.NewGauge(Desc{Name: "in_flight"})
+	inFlight.Set(3)
+	inFlight.Add(-1)
+
+	latency := registry.NewHistogram(Desc{Name: "HTTPClientLatency", Labels: []string{"route"}, Buckets: []float64{0.1, 1}})
+	latency.With("route", `/a"b`).Observe(0.05)
+	latency.With("route", `/a"b`).Observe(0.1)
+	latency.With("route", `/a"b`).Observe(5)
+
+	registry.NewCounter(Desc{Name: "unused"})
+
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, "text/plain; version=0.0.4; charset=utf-8", recorder.Header().Get("Content-Type"))
+	require.Equal(t, strings.Join([]string{
+		`# TYPE http_client_latency histogram`,
+		`http_client_latency_bucket{route="/a\"b",le="0.1"} 2`,
+		`http_client_latency_bucket{route="/a\"b",le="1"} 2`,
+		`http_client_latency_bucket{route="/a\"b",le="+Inf"} 3`,
+		`http_client_latency_sum{route="/a\"b"} 5.15`,
+		`http_client_latency_count{route="/a\"b"} 3`,
+		`# HELP http_client_requests_total Requests sent.`,
+		`# TYPE http_client_requests_total counter`,
+		`http_client_requests_total{client="orders",method="GET"} 2`,
+		`http_client_requests_total{client="orders",method="POST"} 1`,
+		`# TYPE in_flight gauge`,
+		`in_flight 2`,
+		``,
+	}, "\n"), recorder.Body.String())
+}
+
+func TestPrometheusRegistry_sameNameReturnsSameMetric(t *testing.T) {
+	registry := NewPrometheusRegistry()
+	registry.NewCounter(Desc{Name: "calls"}).Add(1)
+	registry.NewCounter(Desc{Name: "calls"}).Add(1)
+
+	var b strings.Builder
+	require.NoError(t, registry.Write(&b))
+	require.Contains(t, b.String(), "calls_total 2\n")
+	require.Panics(t, func() { registry.NewGauge(Desc{Name: "calls_total"}) })
+	require.Panics(t, func() { registry.NewCounter(Desc{Name: "calls"}).With("unknown", "x") })
+	require.Panics(t, func() { registry.NewCounter(Desc{Name: "calls", Labels: []string{"client"}}).With("client") })
+}
+
+func TestPrometheusName(t *testing.T) {
+	for name, want := range map[string]string{
+		"HTTPClientRetries":               "http_client_retries",
+		"HTTPClientConnectionPreparation": "http_client_connection_preparation",
+		"status_class":                    "status_class",
+		"requestsV2":                      "requests_v2",
+		"cache.hits":                      "cache_hits",
+	} {
+		require.Equal(t, want, PrometheusName(name), name)
+	}
+}
@@ -1,20 +1,103 @@
 package middleware
 
 import (
+	"context"
+	"net/http"
+
+	cLog "github.com/CodeNamor/custom_logging"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
-	"net/http"
 )
 
+// RequestIDHeader is the header carrying the request ID between services.
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const correlationKey contextKey = iota
+
 // AddContextValues is a common middleware which will populate the context with the fields from the context and log using said fields.
+// The request ID is taken from the X-Request-ID header, or generated when missing, and echoed on the response.
 func AddContextValues(handler http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		reqID := r.Header.Get("X-Request-ID")
-		if reqID == "" {
-			reqID = uuid.New().String()
-			r.Header.Set("X-Request-ID", reqID)
-		}
-		log.Debugf("incoming request %s %s %s", r.Method, r.RequestURI, reqID)
-		handler.ServeHTTP(w, r)
-	})
+	return Correlation()(handler)
+}
+
+// Correlation returns a middleware like AddContextValues which also stores the
+// given correlation headers, such as a tenant or session ID, in the context.
+// apiclient forwards the request ID and these headers on outbound calls and
+// adds them to its log fields.
+//
+//	router.Use(middleware.Correlation("X-Tenant-ID", "X-Session-ID"))
+func Correlation(headers ...string) func(http.Handler) http.Handler {
+	canonical := make([]string, len(headers))
+	for i, header := range headers {
+		canonical[i] = http.CanonicalHeaderKey(header)
+	}
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqID := r.Header.Get(RequestIDHeader)
+			if reqID == "" {
+				reqID = uuid.New().String()
+				r.Header.Set(RequestIDHeader, reqID)
+			}
+			w.Header().Set(RequestIDHeader, reqID)
+
+			correlation := http.Header{}
+			for _, header := range canonical {
+				if values := r.Header.Values(header); len(values) > 0 {
+					correlation[header] = append([]string{}, values...)
+				}
+			}
+			ctx := WithCorrelationHeaders(WithRequestID(r.Context(), reqID), correlation)
+
+			log.WithFields(cLog.FieldsFromCTX(ctx)).Debugf("incoming request %s %s %s", r.Method, r.RequestURI, reqID)
+			handler.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID. It is stored the
+// way custom_logging reads it, so FieldsFromCTX includes it as requestId.
+func WithRequestID(ctx context.Context, reqID string) context.Context {
+	values, _ := ctx.Value(cLog.ContextValues).(cLog.CtxValues)
+	values.RequestID = reqID
+	ctx = context.WithValue(ctx, cLog.ContextValues, values)
+	return context.WithValue(ctx, cLog.RequestID, reqID)
+}
+
+// RequestID returns the request ID stored in ctx, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	if values, ok := ctx.Value(cLog.ContextValues).(cLog.CtxValues); ok && values.RequestID != "" {
+		return values.RequestID
+	}
+	reqID, _ := ctx.Value(cLog.RequestID).(string)
+	return reqID
+}
+
+// WithCorrelationHeaders returns a copy of ctx carrying headers, which are
+// added to the ones already in ctx.
+func WithCorrelationHeaders(ctx context.Context, headers http.Header) context.Context {
+	if len(headers) == 0 {
+		return ctx
+	}
+	merged := CorrelationHeaders(ctx)
+	for name, values := range headers {
+		merged[http.CanonicalHeaderKey(name)] = append([]string{}, values...)
+	}
+	delete(merged, RequestIDHeader)
+	return context.WithValue(ctx, correlationKey, merged)
+}
+
+// CorrelationHeaders returns the headers to forward on outbound calls made
+// on behalf of the request in ctx, including X-Request-ID. The returned
+// header is a copy.
+func CorrelationHeaders(ctx context.Context) http.Header {
+	headers := http.Header{}
+	if stored, ok := ctx.Value(correlationKey).(http.Header); ok {
+		headers = stored.Clone()
+	}
+	if reqID := RequestID(ctx); reqID != "" {
+		headers.Set(RequestIDHeader, reqID)
+	}
+	return headers
 }
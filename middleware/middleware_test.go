@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cLog "github.com/CodeNamor/custom_logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCorrelation(t *testing.T) {
+	var ctx context.Context
+	handler := Correlation("x-tenant-id")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	req.Header.Set("X-Tenant-ID", "acme")
+	req.Header.Set("X-Other", "ignored")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	require.Equal(t, "req-1", rec.Header().Get(RequestIDHeader))
+	require.Equal(t, "req-1", RequestID(ctx))
+	require.Equal(t, "req-1", cLog.FieldsFromCTX(ctx)["requestId"])
+	require.Equal(t, http.Header{
+		"X-Request-Id": {"req-1"},
+		"X-Tenant-Id":  {"acme"},
+	}, CorrelationHeaders(ctx))
+}
+
+func TestAddContextValues_generatesRequestID(t *testing.T) {
+	var reqID string
+	handler := AddContextValues(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqID = RequestID(r.Context())
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	require.NotEmpty(t, reqID)
+	require.Equal(t, reqID, rec.Header().Get(RequestIDHeader))
+}
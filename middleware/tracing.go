@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/CodeNamor/http/tracing"
+	"github.com/gorilla/mux"
+)
+
+// Tracing returns a middleware which continues the W3C trace of incoming
+// requests, or starts a new one, and records a server span for each request
+// with recorder. Handlers find the span with tracing.SpanFromContext, and
+// apiclient calls made with the request context become its children.
+//
+//	router.Use(middleware.Tracing(tracing.NewJSONLinesRecorder(os.Stdout)))
+func Tracing(recorder tracing.SpanRecorder) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if remote, ok := tracing.Extract(r.Header); ok {
+				ctx = tracing.ContextWithRemoteSpanContext(ctx, remote)
+			}
+			route := routeTemplate(r)
+			ctx, span := tracing.StartSpan(ctx, r.Method+" "+route, tracing.SpanKindServer, recorder)
+			span.SetAttribute("http.method", r.Method)
+			span.SetAttribute("http.route", route)
+
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			defer func() {
+				span.SetAttribute("http.status_code", strconv.Itoa(sw.status))
+				span.End()
+			}()
+			handler.ServeHTTP(sw, r.WithContext(ctx))
+		})
+	}
+}
+
+// routeTemplate returns the mux route template of r, or its path when it was
+// not routed by mux.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
+
+// statusWriter remembers the status code written by a handler.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps streaming handlers working behind the middleware.
+func (w *statusWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		flusher.Flush()
+	}
+}
+
+// Hijack hands the connection over to handlers such as websocket upgraders.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("middleware: the response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the wrapped writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
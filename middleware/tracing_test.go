@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CodeNamor/http/tracing"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracing.NewInMemoryRecorder()
+	router := mux.NewRouter()
+	router.Use(Tracing(recorder))
+	var handlerSpan *tracing.Span
+	router.HandleFunc("/orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = tracing.SpanFromContext(r.Context())
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/orders/42", nil)
+	req.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Spans()
+	require.Len(t, spans, 1)
+	require.Equal(t, "GET /orders/{id}", spans[0].Name)
+	require.Equal(t, tracing.SpanKindServer, spans[0].Kind)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].TraceID.String())
+	require.Equal(t, "00f067aa0ba902b7", spans[0].ParentSpanID.String())
+	require.Equal(t, "202", spans[0].Attributes["http.status_code"])
+	require.Equal(t, handlerSpan.SpanContext().SpanID, spans[0].SpanID)
+}
+
+func TestTracing_flushAndHijack(t *testing.T) {
+	var hijackable bool
+	handler := Tracing(tracing.NewInMemoryRecorder())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		require.True(t, ok, "streaming handlers need http.Flusher")
+		_, _ = w.Write([]byte("data: 1\n\n"))
+		flusher.Flush()
+		_, hijackable = w.(http.Hijacker)
+	}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/events", nil))
+	require.True(t, recorder.Flushed)
+	require.True(t, hijackable)
+}
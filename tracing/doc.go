@@ -0,0 +1,32 @@
+/*
+Package tracing propagates W3C Trace Context (traceparent and tracestate
+headers) between services and records lightweight spans without needing an
+external collector.
+
+# Incoming requests
+
+middleware.Tracing extracts the trace context of incoming requests and starts
+a server span for each of them.
+
+	recorder := tracing.NewJSONLinesRecorder(os.Stdout)
+	router := mux.NewRouter().StrictSlash(true)
+	router.Use(middleware.Tracing(recorder))
+
+# Outgoing calls
+
+apiclient.Client starts a client span for every call made with a context
+carrying a span, or for every call when its SpanRecorder is set, and a child
+span for every attempt of the retry client. The traceparent header sent
+upstream names the attempt span.
+
+	client.SpanRecorder = recorder
+
+# Tests
+
+InMemoryRecorder keeps the spans so tests can assert on them.
+
+	recorder := tracing.NewInMemoryRecorder()
+	// ... make calls
+	spans := recorder.Spans()
+*/
+package tracing
@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// SpanRecorder receives finished spans. Implementations must be safe for
+// concurrent use and should not block.
+type SpanRecorder interface {
+	Record(span SpanData)
+}
+
+// InMemoryRecorder keeps finished spans in memory, it is meant for tests.
+type InMemoryRecorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewInMemoryRecorder creates an empty InMemoryRecorder.
+func NewInMemoryRecorder() *InMemoryRecorder {
+	return &InMemoryRecorder{}
+}
+
+// Record implements SpanRecorder.
+func (r *InMemoryRecorder) Record(span SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+// Spans returns the recorded spans in the order they ended.
+func (r *InMemoryRecorder) Spans() []SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]SpanData(nil), r.spans...)
+}
+
+// Reset drops the recorded spans.
+func (r *InMemoryRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = nil
+}
+
+// JSONLinesRecorder writes every finished span as one line of JSON, so spans
+// can be shipped with the service logs without an external collector.
+type JSONLinesRecorder struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	err     error
+}
+
+// NewJSONLinesRecorder creates a JSONLinesRecorder writing to w.
+//
+//	file, err := os.OpenFile("spans.jsonl", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+//	if err != nil {
+//	  return err
+//	}
+//	recorder := tracing.NewJSONLinesRecorder(file)
+func NewJSONLinesRecorder(w io.Writer) *JSONLinesRecorder {
+	return &JSONLinesRecorder{encoder: json.NewEncoder(w)}
+}
+
+// Record implements SpanRecorder.
+func (r *JSONLinesRecorder) Record(span SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.encoder.Encode(span); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// Err returns the first error writing a span, spans are dropped on errors.
+func (r *JSONLinesRecorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
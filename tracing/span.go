@@ -0,0 +1,153 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SpanKind tells whether a span covers an incoming request, an outgoing call
+// or work inside the service.
+type SpanKind string
+
+const (
+	SpanKindServer   SpanKind = "server"
+	SpanKindClient   SpanKind = "client"
+	SpanKindInternal SpanKind = "internal"
+)
+
+// SpanData is the record of a finished span handed to a SpanRecorder.
+type SpanData struct {
+	Name         string            `json:"name"`
+	Kind         SpanKind          `json:"kind"`
+	TraceID      TraceID           `json:"traceId"`
+	SpanID       SpanID            `json:"spanId"`
+	ParentSpanID SpanID            `json:"parentSpanId,omitempty"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// Duration returns how long the span took.
+func (d SpanData) Duration() time.Duration {
+	return d.End.Sub(d.Start)
+}
+
+// Span is an operation in a trace. Spans of sampled traces are handed to
+// their SpanRecorder when they end, others only propagate the trace.
+type Span struct {
+	recorder SpanRecorder
+	context  SpanContext
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+type contextKey int
+
+const (
+	spanKey contextKey = iota
+	remoteKey
+)
+
+// StartSpan starts a span which is a child of the span in ctx, or of the
+// remote span context stored with ContextWithRemoteSpanContext, or the root
+// of a new trace when there is neither. A nil recorder inherits the recorder
+// of the parent span. The returned context carries the new span.
+func StartSpan(ctx context.Context, name string, kind SpanKind, recorder SpanRecorder) (context.Context, *Span) {
+	parent := SpanContext{Sampled: true}
+	if span := SpanFromContext(ctx); span != nil {
+		parent = span.context
+		if recorder == nil {
+			recorder = span.recorder
+		}
+	} else if remote, ok := ctx.Value(remoteKey).(SpanContext); ok {
+		parent = remote
+	}
+
+	sc := parent
+	if !sc.TraceID.IsValid() {
+		sc.TraceID = newTraceID()
+	}
+	sc.SpanID = newSpanID()
+
+	span := &Span{
+		recorder: recorder,
+		context:  sc,
+		data: SpanData{
+			Name:         name,
+			Kind:         kind,
+			TraceID:      sc.TraceID,
+			SpanID:       sc.SpanID,
+			ParentSpanID: parent.SpanID,
+			Start:        time.Now(),
+		},
+	}
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// SpanFromContext returns the span in ctx, or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext returns a copy of ctx carrying a span context
+// received from another service, spans started from it become its children.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey, sc)
+}
+
+// SpanContextFromContext returns the span context of the span in ctx, or the
+// remote span context, and whether there was one.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.context, true
+	}
+	sc, ok := ctx.Value(remoteKey).(SpanContext)
+	return sc, ok
+}
+
+// SpanContext returns the span context to propagate to other services.
+func (s *Span) SpanContext() SpanContext {
+	return s.context
+}
+
+// SetAttribute sets an attribute on the span.
+func (s *Span) SetAttribute(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = map[string]string{}
+	}
+	s.data.Attributes[key] = value
+}
+
+// SetError marks the span as failed with err, nil is ignored.
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End finishes the span and records it. Only the first call has an effect.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if s.recorder != nil && s.context.Sampled {
+		s.recorder.Record(data)
+	}
+}
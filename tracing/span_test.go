@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartSpan(t *testing.T) {
+	recorder := NewInMemoryRecorder()
+	remote, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	remote.TraceState = "congo=t61rcWkgMzE"
+
+	ctx := ContextWithRemoteSpanContext(context.Background(), remote)
+	ctx, server := StartSpan(ctx, "GET /orders", SpanKindServer, recorder)
+	_, child := StartSpan(ctx, "GET orders-db", SpanKindClient, nil)
+	child.SetAttribute("peer", "db")
+	child.SetError(errors.New("boom"))
+	child.End()
+	child.End()
+	server.End()
+
+	spans := recorder.Spans()
+	require.Len(t, spans, 2)
+	require.Equal(t, remote.TraceID, spans[0].TraceID)
+	require.Equal(t, server.SpanContext().SpanID, spans[0].ParentSpanID)
+	require.Equal(t, "boom", spans[0].Error)
+	require.Equal(t, map[string]string{"peer": "db"}, spans[0].Attributes)
+	require.Equal(t, remote.SpanID, spans[1].ParentSpanID)
+	require.Equal(t, remote.TraceState, child.SpanContext().TraceState)
+	require.NotEqual(t, remote.SpanID, server.SpanContext().SpanID)
+
+	recorder.Reset()
+	require.Empty(t, recorder.Spans())
+}
+
+func TestStartSpan_notSampled(t *testing.T) {
+	recorder := NewInMemoryRecorder()
+	remote, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	require.NoError(t, err)
+
+	_, span := StartSpan(ContextWithRemoteSpanContext(context.Background(), remote), "op", SpanKindInternal, recorder)
+	span.End()
+	require.Empty(t, recorder.Spans())
+	require.False(t, span.SpanContext().Sampled)
+}
+
+func TestJSONLinesRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := NewJSONLinesRecorder(&buf)
+	ctx, root := StartSpan(context.Background(), "root", SpanKindInternal, recorder)
+	_, child := StartSpan(ctx, "child", SpanKindInternal, nil)
+	child.End()
+	root.End()
+	require.NoError(t, recorder.Err())
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(lines[0], &decoded))
+	require.Equal(t, "child", decoded["name"])
+	require.Equal(t, root.SpanContext().TraceID.String(), decoded["traceId"])
+	require.Equal(t, root.SpanContext().SpanID.String(), decoded["parentSpanId"])
+
+	require.NoError(t, json.Unmarshal(lines[1], &decoded))
+	require.Equal(t, "", decoded["parentSpanId"])
+}
@@ -0,0 +1,167 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// TraceparentHeader is the W3C Trace Context header carrying the trace ID,
+	// the parent span ID and the trace flags.
+	TraceparentHeader = "Traceparent"
+	// TracestateHeader is the W3C Trace Context header carrying vendor
+	// specific trace state, it is forwarded unchanged.
+	TracestateHeader = "Tracestate"
+
+	sampledFlag = 0x01
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// IsValid reports whether the trace ID is not all zeros.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// String returns the lower case hex encoding of the trace ID.
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// MarshalText encodes the trace ID as hex.
+func (t TraceID) MarshalText() ([]byte, error) { return []byte(t.String()), nil }
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// IsValid reports whether the span ID is not all zeros.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// String returns the lower case hex encoding of the span ID.
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// MarshalText encodes the span ID as hex, or as an empty string when it is
+// all zeros.
+func (s SpanID) MarshalText() ([]byte, error) {
+	if !s.IsValid() {
+		return []byte{}, nil
+	}
+	return []byte(s.String()), nil
+}
+
+// SpanContext is the part of a span that is propagated between services.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+}
+
+// IsValid reports whether both the trace and the span ID are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a version 00 traceparent value.
+func (sc SpanContext) Traceparent() string {
+	var flags byte
+	if sc.Sampled {
+		flags |= sampledFlag
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+// ErrInvalidTraceparent is returned by ParseTraceparent for malformed values.
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses a traceparent header value. Values of future
+// versions are accepted as long as they start with the version 00 fields.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	value = strings.TrimSpace(value)
+	if len(value) < 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+	version, err := decodeHex(value[0:2], 1)
+	if err != nil || version[0] == 0xff {
+		return sc, ErrInvalidTraceparent
+	}
+	if version[0] == 0 && len(value) != 55 {
+		return sc, ErrInvalidTraceparent
+	}
+	if version[0] > 0 && len(value) > 55 && value[55] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+
+	traceID, err := decodeHex(value[3:35], 16)
+	if err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	spanID, err := decodeHex(value[36:52], 8)
+	if err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	flags, err := decodeHex(value[53:55], 1)
+	if err != nil {
+		return sc, ErrInvalidTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&sampledFlag != 0
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	return sc, nil
+}
+
+// decodeHex decodes lower case hex of exactly n bytes, as required by the
+// specification.
+func decodeHex(s string, n int) ([]byte, error) {
+	if strings.ToLower(s) != s {
+		return nil, ErrInvalidTraceparent
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != n {
+		return nil, ErrInvalidTraceparent
+	}
+	return b, nil
+}
+
+// Extract reads the span context from the traceparent and tracestate
+// headers. It returns false when there is no valid traceparent, in which case
+// tracestate is ignored as well.
+func Extract(header http.Header) (SpanContext, bool) {
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.TraceState = strings.Join(header.Values(TracestateHeader), ",")
+	return sc, true
+}
+
+// Inject writes sc to the traceparent and tracestate headers.
+func Inject(sc SpanContext, header http.Header) {
+	header.Set(TraceparentHeader, sc.Traceparent())
+	if sc.TraceState != "" {
+		header.Set(TracestateHeader, sc.TraceState)
+	} else {
+		header.Del(TracestateHeader)
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
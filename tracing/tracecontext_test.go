@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	require.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	require.True(t, sc.Sampled)
+	require.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	// future versions may append fields
+	sc, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	require.NoError(t, err)
+	require.False(t, sc.Sampled)
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceparent(invalid)
+		require.Equal(t, ErrInvalidTraceparent, err, invalid)
+	}
+}
+
+func TestExtractInject(t *testing.T) {
+	header := http.Header{}
+	header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	header.Add(TracestateHeader, "congo=t61rcWkgMzE")
+	header.Add(TracestateHeader, "rojo=00f067aa0ba902b7")
+
+	sc, ok := Extract(header)
+	require.True(t, ok)
+	require.Equal(t, "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7", sc.TraceState)
+
+	out := http.Header{}
+	Inject(sc, out)
+	require.Equal(t, header.Get(TraceparentHeader), out.Get(TraceparentHeader))
+	require.Equal(t, sc.TraceState, out.Get(TracestateHeader))
+
+	_, ok = Extract(http.Header{TracestateHeader: {"congo=t61rcWkgMzE"}})
+	require.False(t, ok)
+}